@@ -2,12 +2,17 @@
 package main
 
 import (
+    "context"
+    "encoding/json"
+    "errors"
     "flag"
     "fmt"
     "os"
+    "os/signal"
     "path/filepath"
     "sort"
     "strings"
+    "time"
     "github.com/example/gblobs/gblobs"
 )
 
@@ -15,38 +20,137 @@ import (
 func main() {
     if len(os.Args) < 2 {
         fmt.Printf("gblobs <command> [options]\n")
-        fmt.Println("Commands: putfile, putstring, get, exists, delete, purge, stats, inspect, search")
+        fmt.Println("Commands: create, putfile, putdir, restore, diff, ref, putstring, get, exists, delete, purge, expire, retention, lock, hold, audit, stats, inspect, search, suggest, similar, near-dups, reindex, health")
         os.Exit(1)
     }
+    // Commands return their exit code instead of exiting, so that their
+    // deferred Close runs first
+    var code int
     cmd := os.Args[1]
     switch cmd {
+    case "create":
+        code = createCmd(os.Args[2:])
     case "putfile":
-        putFileCmd(os.Args[2:])
+        code = putFileCmd(os.Args[2:])
+    case "putdir":
+        code = putDirCmd(os.Args[2:])
+    case "restore":
+        code = restoreCmd(os.Args[2:])
+    case "diff":
+        code = diffCmd(os.Args[2:])
+    case "ref":
+        code = refCmd(os.Args[2:])
     case "putstring":
-        putStringCmd(os.Args[2:])
+        code = putStringCmd(os.Args[2:])
     case "get":
-        getCmd(os.Args[2:])
+        code = getCmd(os.Args[2:])
     case "exists":
-        existsCmd(os.Args[2:])
+        code = existsCmd(os.Args[2:])
     case "delete":
-        deleteCmd(os.Args[2:])
+        code = deleteCmd(os.Args[2:])
     case "purge":
-        purgeCmd(os.Args[2:])
+        code = purgeCmd(os.Args[2:])
+    case "expire":
+        code = expireCmd(os.Args[2:])
+    case "retention":
+        code = retentionCmd(os.Args[2:])
+    case "lock":
+        code = lockCmd(os.Args[2:])
+    case "hold":
+        code = holdCmd(os.Args[2:])
+    case "audit":
+        code = auditCmd(os.Args[2:])
     case "stats":
-        statsCmd(os.Args[2:])
+        code = statsCmd(os.Args[2:])
     case "inspect":
-        inspectCmd(os.Args[2:])
+        code = inspectCmd(os.Args[2:])
     case "search":
-        searchCmd(os.Args[2:])
+        code = searchCmd(os.Args[2:])
+    case "suggest":
+        code = suggestCmd(os.Args[2:])
+    case "similar":
+        code = similarCmd(os.Args[2:])
+    case "near-dups":
+        code = nearDupsCmd(os.Args[2:])
+    case "reindex":
+        code = reindexCmd(os.Args[2:])
+    case "health":
+        code = healthCmd(os.Args[2:])
     default:
         fmt.Println("Unknown command.")
-        os.Exit(1)
+        code = exitError
     }
+    os.Exit(code)
+}
+
+// Exit codes, so scripts can tell failures apart without parsing messages.
+const (
+    exitError            = 1 // Any other error, including usage errors
+    exitNotFound         = 3
+    exitCorrupt          = 4
+    exitWrongKey         = 5
+    exitIndexUnavailable = 6
+    exitStoreClosed      = 7
+    exitInvalidID        = 8
+    exitStoreLocked      = 9
+    exitReadOnly         = 10
+    exitRefConflict      = 11
+    exitRetentionLocked  = 12
+)
+
+// exitCode maps an error to the exit code for its kind.
+func exitCode(err error) int {
+    switch {
+    case errors.Is(err, gblobs.ErrNotFound):
+        return exitNotFound
+    case errors.Is(err, gblobs.ErrCorrupt):
+        return exitCorrupt
+    case errors.Is(err, gblobs.ErrWrongKey):
+        return exitWrongKey
+    case errors.Is(err, gblobs.ErrIndexUnavailable):
+        return exitIndexUnavailable
+    case errors.Is(err, gblobs.ErrStoreClosed):
+        return exitStoreClosed
+    case errors.Is(err, gblobs.ErrInvalidID):
+        return exitInvalidID
+    case errors.Is(err, gblobs.ErrStoreLocked):
+        return exitStoreLocked
+    case errors.Is(err, gblobs.ErrReadOnly):
+        return exitReadOnly
+    case errors.Is(err, gblobs.ErrRefConflict):
+        return exitRefConflict
+    case errors.Is(err, gblobs.ErrRetentionLocked):
+        return exitRetentionLocked
+    }
+    return exitError
+}
+
+// fail prints err after prefix and returns its exit code.
+func fail(prefix string, err error) int {
+    fmt.Printf("%s: %v\n", prefix, err)
+    return exitCode(err)
 }
 
 // helpers for store setup
 func openStoreOrDie(path, key string) *gblobs.LocalStore {
-    st := &gblobs.LocalStore{}
+    return openOrDie(&gblobs.LocalStore{}, path, key)
+}
+
+// openReadOnlyOrDie opens the store for commands that only read, so that they
+// can run while another command writes to the store. These commands do not use
+// the search index, so an outdated one does not keep them from working.
+func openReadOnlyOrDie(path, key string) *gblobs.LocalStore {
+    return openOrDie(&gblobs.LocalStore{ReadOnly: true, MappingPolicy: gblobs.MappingIgnore}, path, key)
+}
+
+// openSearchOrDie opens the store read-only for commands that query the search
+// index; they fail with exit code 6 if the index is outdated.
+func openSearchOrDie(path, key string) *gblobs.LocalStore {
+    return openOrDie(&gblobs.LocalStore{ReadOnly: true}, path, key)
+}
+
+// openOrDie opens st, configured by the caller, at path.
+func openOrDie(st *gblobs.LocalStore, path, key string) *gblobs.LocalStore {
     var err error
     if key != "" {
         err = st.OpenStore(path, key)
@@ -54,8 +158,7 @@ func openStoreOrDie(path, key string) *gblobs.LocalStore {
         err = st.OpenStore(path)
     }
     if err != nil {
-        fmt.Printf("Error opening store: %v\n", err)
-        os.Exit(1)
+        os.Exit(fail("Error opening store", err))
     }
     return st
 }
@@ -73,8 +176,7 @@ func openOrCreateStoreOrDie(path, key string) *gblobs.LocalStore {
             err = st.CreateStore(path)
         }
         if err != nil {
-            fmt.Printf("Error creating store: %v\n", err)
-            os.Exit(1)
+            os.Exit(fail("Error creating store", err))
         }
     } else {
         // Directory exists, try to open store
@@ -84,8 +186,7 @@ func openOrCreateStoreOrDie(path, key string) *gblobs.LocalStore {
             err = st.OpenStore(path)
         }
         if err != nil {
-            fmt.Printf("Error opening store: %v\n", err)
-            os.Exit(1)
+            os.Exit(fail("Error opening store", err))
         }
     }
     return st
@@ -100,86 +201,443 @@ func createStoreOrDie(path, key string) *gblobs.LocalStore {
         err = st.CreateStore(path)
     }
     if err != nil {
-        fmt.Printf("Error creating store: %v\n", err)
-        os.Exit(1)
+        os.Exit(fail("Error creating store", err))
     }
     return st
 }
 
-func putFileCmd(args []string) {
+func createCmd(args []string) int {
+    fs := flag.NewFlagSet("create", flag.ExitOnError)
+    storePath := fs.String("store", "./store", "Store directory")
+    key := fs.String("key", "", "Encryption key (optional)")
+    worm := fs.Bool("worm", false, "Create a write-once store: blobs can never be deleted or their metadata changed")
+    fs.Parse(args)
+    if fs.NArg() != 0 {
+        fmt.Println("Usage: gblobs create [flags]")
+        return exitError
+    }
+    if _, err := os.Stat(*storePath); err == nil {
+        fmt.Printf("Error: %s exists already\n", *storePath)
+        return exitError
+    }
+    st := &gblobs.LocalStore{WORM: *worm}
+    var err error
+    if *key != "" {
+        err = st.CreateStore(*storePath, *key)
+    } else {
+        err = st.CreateStore(*storePath)
+    }
+    if err != nil {
+        return fail("Error creating store", err)
+    }
+    st.Close()
+    return 0
+}
+
+func putFileCmd(args []string) int {
     fs := flag.NewFlagSet("putfile", flag.ExitOnError)
     storePath := fs.String("store", "./store", "Store directory")
     key := fs.String("key", "", "Encryption key (optional)")
     owner := fs.String("owner", "", "Owner (optional)")
+    lang := fs.String("lang", "", "Content language, e.g. de (optional, detected if empty)")
+    mimeType := fs.String("type", "", "MIME type (optional, detected if empty)")
+    ttl := fs.Duration("ttl", 0, "Expire the blob after this long, e.g. 720h (optional)")
     fs.Parse(args)
     if fs.NArg() < 1 {
-        fmt.Println("Usage: gblobs putfile <file> [flags]")
-        os.Exit(1)
+        fmt.Println("Usage: gblobs putfile <file>... [flags]")
+        return exitError
+    }
+    // Read every file first so that a typo does not leave half the files stored
+    items := make([]gblobs.PutItem, fs.NArg())
+    for i, fname := range fs.Args() {
+        dat, err := os.ReadFile(fname)
+        if err != nil {
+            return fail("Error reading file", err)
+        }
+        items[i] = gblobs.PutItem{Data: dat, Meta: gblobs.BlobType{
+            Name: filepath.Base(fname),
+            URI:  fname,
+            Owner: *owner,
+            Language: *lang,
+            MIMEType: *mimeType,
+            IngestionTime: gblobs.NowUTC(),
+            ExpiresAt: expiryOf(*ttl),
+        }}
     }
-    fname := fs.Arg(0)
-    dat, err := os.ReadFile(fname)
+    st := openOrCreateStoreOrDie(*storePath, *key)
+    defer st.Close()
+    results, err := st.PutBlobs(items)
     if err != nil {
-        fmt.Printf("Error reading file: %v\n", err)
-        os.Exit(1)
+        return fail("Store error", err)
     }
-    meta := gblobs.BlobType{
-        Name: filepath.Base(fname),
-        URI:  fname,
-        Owner: *owner,
-        IngestionTime: gblobs.NowUTC(),
+    if err := st.Flush(); err != nil {
+        fmt.Fprintf(os.Stderr, "Warning: blobs stored but not indexed: %v\n", err)
+    }
+    var firstErr error
+    for i, r := range results {
+        switch {
+        case r.Err != nil:
+            fmt.Printf("Store error: %s: %v\n", fs.Arg(i), r.Err)
+            if firstErr == nil {
+                firstErr = r.Err
+            }
+        case len(results) == 1:
+            fmt.Println(r.BlobID)
+        default:
+            fmt.Printf("%s  %s\n", r.BlobID, fs.Arg(i))
+        }
+    }
+    if firstErr != nil {
+        return exitCode(firstErr)
+    }
+    return 0
+}
+
+// globList is a flag that may be given several times.
+type globList []string
+
+func (g *globList) String() string { return strings.Join(*g, ",") }
+
+func (g *globList) Set(v string) error {
+    *g = append(*g, v)
+    return nil
+}
+
+// expiryOf returns the ExpiresAt for a --ttl flag; zero if none was given.
+func expiryOf(ttl time.Duration) time.Time {
+    if ttl <= 0 {
+        return time.Time{}
+    }
+    return gblobs.NowUTC().Add(ttl)
+}
+
+func putDirCmd(args []string) int {
+    fs := flag.NewFlagSet("putdir", flag.ExitOnError)
+    storePath := fs.String("store", "./store", "Store directory")
+    key := fs.String("key", "", "Encryption key (optional)")
+    owner := fs.String("owner", "", "Owner (optional)")
+    ttl := fs.Duration("ttl", 0, "Expire the blobs after this long, e.g. 720h (optional)")
+    var include, exclude globList
+    fs.Var(&include, "include", "Only ingest files matching this glob (repeatable)")
+    fs.Var(&exclude, "exclude", "Skip files and directories matching this glob (repeatable)")
+    followSymlinks := fs.Bool("follow-symlinks", false, "Ingest the targets of symlinks to files")
+    special := fs.Bool("special", false, "Read devices, named pipes and sockets like regular files")
+    workers := fs.Int("workers", 0, "Number of parallel workers (default: number of CPUs)")
+    dryRun := fs.Bool("dry-run", false, "Only report what would be stored")
+    snapshot := fs.Bool("snapshot", false, "Also store the directory structure and print its tree ID")
+    verbose := fs.Bool("v", false, "List every file")
+    fs.Parse(args)
+    if fs.NArg() != 1 {
+        fmt.Println("Usage: gblobs putdir <dir> [flags]")
+        return exitError
+    }
+    root := fs.Arg(0)
+    if fi, err := os.Stat(root); err != nil {
+        return fail("Error reading directory", err)
+    } else if !fi.IsDir() {
+        fmt.Printf("Error: %s is not a directory\n", root)
+        return exitError
+    }
+
+    var st *gblobs.LocalStore
+    if *dryRun {
+        st = openReadOnlyOrDie(*storePath, *key)
+    } else {
+        st = openOrCreateStoreOrDie(*storePath, *key)
+    }
+    defer st.Close()
+    st.BatchWorkers = *workers
+
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+    defer stop()
+    res, err := st.PutDirContext(ctx, root, gblobs.PutDirOptions{
+        Include:        include,
+        Exclude:        exclude,
+        FollowSymlinks: *followSymlinks,
+        SpecialFiles:   *special,
+        DryRun:         *dryRun,
+        Snapshot:       *snapshot,
+        Meta:           gblobs.BlobType{Owner: *owner, ExpiresAt: expiryOf(*ttl)},
+    })
+    if !*dryRun {
+        if err := st.Flush(); err != nil {
+            fmt.Fprintf(os.Stderr, "Warning: blobs stored but not indexed: %v\n", err)
+        }
+    }
+    var firstErr error
+    for _, f := range res.Files {
+        switch {
+        case f.Err != nil:
+            fmt.Printf("Error: %s: %v\n", f.Path, f.Err)
+            if firstErr == nil {
+                firstErr = f.Err
+            }
+        case *verbose && f.Deduplicated:
+            fmt.Printf("%s  %s (deduplicated)\n", f.BlobID, f.Path)
+        case *verbose:
+            fmt.Printf("%s  %s\n", f.BlobID, f.Path)
+        }
     }
-    st := openOrCreateStoreOrDie(*storePath, *key)
-    id, err := st.PutBlob(dat, meta)
     if err != nil {
-        fmt.Printf("Store error: %v\n", err)
-        os.Exit(1)
+        return fail("Store error", err)
     }
-    fmt.Println(id)
+
+    if *dryRun {
+        fmt.Println("Dry run, nothing stored")
+    }
+    fmt.Printf("Files:        %d\n", res.New+res.Deduplicated+res.Failed)
+    fmt.Printf("New:          %d (%d bytes)\n", res.New, res.NewBytes)
+    fmt.Printf("Deduplicated: %d (%d bytes saved)\n", res.Deduplicated, res.SavedBytes)
+    fmt.Printf("Failed:       %d\n", res.Failed)
+    fmt.Printf("Skipped:      %d\n", res.Skipped)
+    if res.TreeID != "" {
+        fmt.Printf("Tree:         %s\n", res.TreeID)
+    }
+    if firstErr != nil {
+        return exitCode(firstErr)
+    }
+    return 0
+}
+
+func restoreCmd(args []string) int {
+    fs := flag.NewFlagSet("restore", flag.ExitOnError)
+    storePath := fs.String("store", "./store", "Store directory")
+    key := fs.String("key", "", "Encryption key (optional)")
+    fs.Parse(args)
+    if fs.NArg() != 2 {
+        fmt.Println("Usage: gblobs restore <treeID> <dest> [flags]")
+        return exitError
+    }
+    st := openReadOnlyOrDie(*storePath, *key)
+    defer st.Close()
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+    defer stop()
+    if err := st.RestoreTreeContext(ctx, fs.Arg(0), fs.Arg(1)); err != nil {
+        return fail("Restore error", err)
+    }
+    return 0
+}
+
+func diffCmd(args []string) int {
+    fs := flag.NewFlagSet("diff", flag.ExitOnError)
+    storePath := fs.String("store", "./store", "Store directory")
+    key := fs.String("key", "", "Encryption key (optional)")
+    asJSON := fs.Bool("json", false, "Print the changes as JSON")
+    fs.Parse(args)
+    if fs.NArg() != 2 {
+        fmt.Println("Usage: gblobs diff <oldTreeID> <newTreeID> [flags]")
+        return exitError
+    }
+    st := openReadOnlyOrDie(*storePath, *key)
+    defer st.Close()
+    changes, err := st.DiffTrees(fs.Arg(0), fs.Arg(1))
+    if err != nil {
+        return fail("Diff error", err)
+    }
+    if *asJSON {
+        if changes == nil {
+            changes = []gblobs.TreeChange{}
+        }
+        enc := json.NewEncoder(os.Stdout)
+        enc.SetIndent("", "  ")
+        if err := enc.Encode(changes); err != nil {
+            return fail("Error", err)
+        }
+        return 0
+    }
+    counts := map[gblobs.ChangeKind]int{}
+    for _, c := range changes {
+        counts[c.Kind]++
+        switch c.Kind {
+        case gblobs.ChangeAdded:
+            fmt.Printf("A  %s\n", c.Path)
+        case gblobs.ChangeRemoved:
+            fmt.Printf("D  %s\n", c.Path)
+        case gblobs.ChangeModified:
+            fmt.Printf("M  %s\n", c.Path)
+        case gblobs.ChangeRenamed:
+            fmt.Printf("R  %s -> %s\n", c.OldPath, c.Path)
+        }
+    }
+    fmt.Printf("%d added, %d removed, %d modified, %d renamed\n",
+        counts[gblobs.ChangeAdded], counts[gblobs.ChangeRemoved], counts[gblobs.ChangeModified], counts[gblobs.ChangeRenamed])
+    return 0
+}
+
+func refCmd(args []string) int {
+    usage := func() int {
+        fmt.Println("Usage: gblobs ref set <name> <blobID|ref> [--expect <blobID>] [flags]")
+        fmt.Println("       gblobs ref get <name> [flags]")
+        fmt.Println("       gblobs ref list [prefix] [flags]")
+        fmt.Println("       gblobs ref log <name> [flags]")
+        fmt.Println("       gblobs ref delete <name> [--expect <blobID>] [flags]")
+        return exitError
+    }
+    if len(args) < 1 {
+        return usage()
+    }
+    sub := args[0]
+    fs := flag.NewFlagSet("ref "+sub, flag.ExitOnError)
+    storePath := fs.String("store", "./store", "Store directory")
+    key := fs.String("key", "", "Encryption key (optional)")
+    expect := fs.String("expect", "", "Only update if the ref points at this ID; empty means it must not exist")
+    fs.Parse(args[1:])
+    expectSet := false
+    fs.Visit(func(f *flag.Flag) { expectSet = expectSet || f.Name == "expect" })
+
+    switch sub {
+    case "set":
+        if fs.NArg() != 2 {
+            return usage()
+        }
+        st := openStoreOrDie(*storePath, *key)
+        defer st.Close()
+        // The target may itself be a ref, e.g. to copy release/1.2 to stable
+        id := fs.Arg(1)
+        if gblobs.ValidateBlobID(id) != nil {
+            target, err := st.GetRef(id)
+            if err != nil {
+                return fail("Error", err)
+            }
+            id = target
+        }
+        var err error
+        if expectSet {
+            err = st.UpdateRef(fs.Arg(0), *expect, id)
+        } else {
+            err = st.SetRef(fs.Arg(0), id)
+        }
+        if err != nil {
+            return fail("Error", err)
+        }
+    case "delete":
+        if fs.NArg() != 1 {
+            return usage()
+        }
+        st := openStoreOrDie(*storePath, *key)
+        defer st.Close()
+        var err error
+        if expectSet {
+            err = st.UpdateRef(fs.Arg(0), *expect, "")
+        } else {
+            err = st.DeleteRef(fs.Arg(0))
+        }
+        if err != nil {
+            return fail("Error", err)
+        }
+    case "get":
+        if fs.NArg() != 1 {
+            return usage()
+        }
+        st := openReadOnlyOrDie(*storePath, *key)
+        defer st.Close()
+        id, err := st.GetRef(fs.Arg(0))
+        if err != nil {
+            return fail("Error", err)
+        }
+        fmt.Println(id)
+    case "list":
+        if fs.NArg() > 1 {
+            return usage()
+        }
+        st := openReadOnlyOrDie(*storePath, *key)
+        defer st.Close()
+        refs, err := st.ListRefs(fs.Arg(0))
+        if err != nil {
+            return fail("Error", err)
+        }
+        for _, r := range refs {
+            fmt.Printf("%s  %s\n", r.ID, r.Name)
+        }
+    case "log":
+        if fs.NArg() != 1 {
+            return usage()
+        }
+        st := openReadOnlyOrDie(*storePath, *key)
+        defer st.Close()
+        log, err := st.RefLog(fs.Arg(0))
+        if err != nil {
+            return fail("Error", err)
+        }
+        for _, e := range log {
+            switch {
+            case e.Old == "":
+                fmt.Printf("%s  created  %s\n", e.Time.Format("2006-01-02 15:04:05 MST"), e.New)
+            case e.New == "":
+                fmt.Printf("%s  deleted  (was %s)\n", e.Time.Format("2006-01-02 15:04:05 MST"), e.Old)
+            default:
+                fmt.Printf("%s  updated  %s (was %s)\n", e.Time.Format("2006-01-02 15:04:05 MST"), e.New, e.Old)
+            }
+        }
+    default:
+        return usage()
+    }
+    return 0
 }
 
-func putStringCmd(args []string) {
+func putStringCmd(args []string) int {
     fs := flag.NewFlagSet("putstring", flag.ExitOnError)
     storePath := fs.String("store", "./store", "Store directory")
     key := fs.String("key", "", "Encryption key (optional)")
     name := fs.String("name", "string", "Name of blob")
     owner := fs.String("owner", "", "Owner (optional)")
+    lang := fs.String("lang", "", "Content language, e.g. de (optional, detected if empty)")
+    mimeType := fs.String("type", "", "MIME type (optional, detected if empty)")
+    ttl := fs.Duration("ttl", 0, "Expire the blob after this long, e.g. 720h (optional)")
     fs.Parse(args)
     if fs.NArg() < 1 {
         fmt.Println("Usage: gblobs putstring <string> [flags]")
-        os.Exit(1)
+        return exitError
     }
     str := fs.Arg(0)
     meta := gblobs.BlobType{
         Name: *name,
         URI:  "string://local",
         Owner: *owner,
+        Language: *lang,
+        MIMEType: *mimeType,
         IngestionTime: gblobs.NowUTC(),
+        ExpiresAt: expiryOf(*ttl),
     }
     st := openOrCreateStoreOrDie(*storePath, *key)
+    defer st.Close()
     id, err := st.PutBlob([]byte(str), meta)
     if err != nil {
-        fmt.Printf("Store error: %v\n", err)
-        os.Exit(1)
+        return fail("Store error", err)
+    }
+    if err := st.Flush(); err != nil {
+        fmt.Fprintf(os.Stderr, "Warning: blob stored but not indexed: %v\n", err)
     }
     fmt.Println(id)
+    return 0
 }
 
-func getCmd(args []string) {
+func getCmd(args []string) int {
     fs := flag.NewFlagSet("get", flag.ExitOnError)
     storePath := fs.String("store", "./store", "Store directory")
     key := fs.String("key", "", "Encryption key (optional)")
     outFile := fs.String("out", "", "Write output to file (default: stdout)")
+    showType := fs.Bool("type", false, "Print the blob's MIME type instead of its content")
     fs.Parse(args)
     if fs.NArg() < 1 {
-        fmt.Println("Usage: gblobs get <blobID> [flags]")
-        os.Exit(1)
+        fmt.Println("Usage: gblobs get <blobID|ref> [flags]")
+        return exitError
     }
     blobID := fs.Arg(0)
-    st := openStoreOrDie(*storePath, *key)
+    st := openReadOnlyOrDie(*storePath, *key)
+    defer st.Close()
     data, meta, err := st.GetBlob(blobID)
     if err != nil {
-        fmt.Printf("Error: %v\n", err)
-        os.Exit(1)
+        return fail("Error", err)
+    }
+    if *showType {
+        mimeType := meta.MIMEType
+        if mimeType == "" {
+            // Blobs stored before types were recorded
+            mimeType = gblobs.DetectMIMEType(data, meta.Name)
+        }
+        fmt.Println(mimeType)
+        return 0
     }
     if *outFile == "" {
         if len(data) > 1024*1024 {
@@ -189,90 +647,263 @@ func getCmd(args []string) {
         os.Stdout.Write(data)
     } else {
         if err := os.WriteFile(*outFile, data, 0o644); err != nil {
-            fmt.Printf("Write error: %v\n", err)
-            os.Exit(1)
+            return fail("Write error", err)
         }
     }
+    return 0
 }
 
-func existsCmd(args []string) {
+func existsCmd(args []string) int {
     fs := flag.NewFlagSet("exists", flag.ExitOnError)
     storePath := fs.String("store", "./store", "Store directory")
     key := fs.String("key", "", "Encryption key (optional)")
     fs.Parse(args)
     if fs.NArg() < 1 {
         fmt.Println("Usage: gblobs exists <blobID> [flags]")
-        os.Exit(1)
+        return exitError
     }
     blobID := fs.Arg(0)
-    st := openStoreOrDie(*storePath, *key)
+    st := openReadOnlyOrDie(*storePath, *key)
+    defer st.Close()
     exists, err := st.ExistsBlob(blobID)
     if err != nil {
-        fmt.Printf("Error: %v\n", err)
-        os.Exit(1)
+        return fail("Error", err)
     }
     fmt.Println(exists)
+    return 0
 }
 
-func deleteCmd(args []string) {
+func deleteCmd(args []string) int {
     fs := flag.NewFlagSet("delete", flag.ExitOnError)
     storePath := fs.String("store", "./store", "Store directory")
     key := fs.String("key", "", "Encryption key (optional)")
     fs.Parse(args)
     if fs.NArg() < 1 {
         fmt.Println("Usage: gblobs delete <blobID> [flags]")
-        os.Exit(1)
+        return exitError
     }
     blobID := fs.Arg(0)
     st := openStoreOrDie(*storePath, *key)
+    defer st.Close()
     err := st.DeleteBlob(blobID)
     if err != nil {
-        fmt.Printf("Error: %v\n", err)
-        os.Exit(1)
+        return fail("Error", err)
+    }
+    if err := st.Flush(); err != nil {
+        fmt.Fprintf(os.Stderr, "Warning: blob deleted but still in search index: %v\n", err)
     }
     fmt.Println("deleted")
+    return 0
 }
 
-func purgeCmd(args []string) {
+func purgeCmd(args []string) int {
     fs := flag.NewFlagSet("purge", flag.ExitOnError)
     storePath := fs.String("store", "./store", "Store directory")
     key := fs.String("key", "", "Encryption key (optional)")
     fs.Parse(args)
     st := openStoreOrDie(*storePath, *key)
+    defer st.Close()
     err := st.PurgeStore()
     if err != nil {
-        fmt.Printf("Error: %v\n", err)
-        os.Exit(1)
+        return fail("Error", err)
     }
     fmt.Println("purged store")
+    return 0
 }
 
-func statsCmd(args []string) {
-    fs := flag.NewFlagSet("stats", flag.ExitOnError)
+func expireCmd(args []string) int {
+    fs := flag.NewFlagSet("expire", flag.ExitOnError)
+    storePath := fs.String("store", "./store", "Store directory")
+    key := fs.String("key", "", "Encryption key (optional)")
+    dryRun := fs.Bool("dry-run", false, "Only list the expired blobs")
+    verbose := fs.Bool("v", false, "List every expired blob")
+    fs.Parse(args)
+    if fs.NArg() != 0 {
+        fmt.Println("Usage: gblobs expire [flags]")
+        return exitError
+    }
+    var st *gblobs.LocalStore
+    if *dryRun {
+        st = openReadOnlyOrDie(*storePath, *key)
+    } else {
+        st = openStoreOrDie(*storePath, *key)
+    }
+    defer st.Close()
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+    defer stop()
+    expired, err := st.ExpireContext(ctx, *dryRun)
+    if err != nil {
+        return fail("Error", err)
+    }
+    if !*dryRun {
+        if err := st.Flush(); err != nil {
+            fmt.Fprintf(os.Stderr, "Warning: blobs deleted but still in search index: %v\n", err)
+        }
+    }
+    var bytes int64
+    for _, e := range expired {
+        bytes += e.Metadata.Length
+        if *verbose || *dryRun {
+            fmt.Printf("%s  %s  expired %s\n", e.BlobID, e.Metadata.Name, e.ExpiredAt.Format("2006-01-02 15:04:05 MST"))
+        }
+    }
+    if *dryRun {
+        fmt.Printf("%d blobs expired (%d bytes), nothing deleted\n", len(expired), bytes)
+    } else {
+        fmt.Printf("%d blobs expired and deleted (%d bytes)\n", len(expired), bytes)
+    }
+    return 0
+}
+
+func retentionCmd(args []string) int {
+    usage := func() int {
+        fmt.Println("Usage: gblobs retention [list] [flags]")
+        fmt.Println("       gblobs retention add --max-age <duration> [--owner <owner>] [--type <MIME type>] [flags]")
+        fmt.Println("       gblobs retention clear [flags]")
+        return exitError
+    }
+    sub := "list"
+    if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+        sub, args = args[0], args[1:]
+    }
+    fs := flag.NewFlagSet("retention "+sub, flag.ExitOnError)
+    storePath := fs.String("store", "./store", "Store directory")
+    key := fs.String("key", "", "Encryption key (optional)")
+    maxAge := fs.Duration("max-age", 0, "Expire matching blobs this long after ingestion, e.g. 2160h")
+    owner := fs.String("owner", "", "Only blobs of this owner (optional)")
+    mimeType := fs.String("type", "", "Only blobs of this MIME type or pattern, e.g. image/* (optional)")
+    fs.Parse(args)
+    if fs.NArg() != 0 {
+        return usage()
+    }
+
+    switch sub {
+    case "list":
+        st := openReadOnlyOrDie(*storePath, *key)
+        defer st.Close()
+        for _, r := range st.RetentionRules() {
+            fmt.Printf("max-age %s  owner %q  type %q\n", r.MaxAge, r.Owner, r.MIMEType)
+        }
+    case "add":
+        if *maxAge <= 0 {
+            return usage()
+        }
+        st := openStoreOrDie(*storePath, *key)
+        defer st.Close()
+        rules := append(st.RetentionRules(), gblobs.RetentionRule{Owner: *owner, MIMEType: *mimeType, MaxAge: *maxAge})
+        if err := st.SetRetentionRules(rules); err != nil {
+            return fail("Error", err)
+        }
+    case "clear":
+        st := openStoreOrDie(*storePath, *key)
+        defer st.Close()
+        if err := st.SetRetentionRules(nil); err != nil {
+            return fail("Error", err)
+        }
+    default:
+        return usage()
+    }
+    return 0
+}
+
+func lockCmd(args []string) int {
+    fs := flag.NewFlagSet("lock", flag.ExitOnError)
+    storePath := fs.String("store", "./store", "Store directory")
+    key := fs.String("key", "", "Encryption key (optional)")
+    until := fs.String("until", "", "Keep the blob until this time, e.g. 2030-01-01T00:00:00Z")
+    forDur := fs.Duration("for", 0, "Keep the blob this long from now, e.g. 61320h")
+    fs.Parse(args)
+    if fs.NArg() != 1 || (*until == "") == (*forDur == 0) {
+        fmt.Println("Usage: gblobs lock <blobID> (--until <time> | --for <duration>) [flags]")
+        return exitError
+    }
+    t := gblobs.NowUTC().Add(*forDur)
+    if *until != "" {
+        var err error
+        if t, err = time.Parse(time.RFC3339, *until); err != nil {
+            return fail("Error", err)
+        }
+    }
+    st := openStoreOrDie(*storePath, *key)
+    defer st.Close()
+    if err := st.SetRetentionLock(fs.Arg(0), t); err != nil {
+        return fail("Error", err)
+    }
+    if err := st.Flush(); err != nil {
+        fmt.Fprintf(os.Stderr, "Warning: blob locked but its index entry is outdated: %v\n", err)
+    }
+    return 0
+}
+
+func holdCmd(args []string) int {
+    fs := flag.NewFlagSet("hold", flag.ExitOnError)
     storePath := fs.String("store", "./store", "Store directory")
     key := fs.String("key", "", "Encryption key (optional)")
+    release := fs.Bool("release", false, "Release the legal hold instead of placing it")
     fs.Parse(args)
+    if fs.NArg() != 1 {
+        fmt.Println("Usage: gblobs hold <blobID> [--release] [flags]")
+        return exitError
+    }
     st := openStoreOrDie(*storePath, *key)
+    defer st.Close()
+    if err := st.SetLegalHold(fs.Arg(0), !*release); err != nil {
+        return fail("Error", err)
+    }
+    if err := st.Flush(); err != nil {
+        fmt.Fprintf(os.Stderr, "Warning: hold changed but the blob's index entry is outdated: %v\n", err)
+    }
+    return 0
+}
+
+func auditCmd(args []string) int {
+    fs := flag.NewFlagSet("audit", flag.ExitOnError)
+    storePath := fs.String("store", "./store", "Store directory")
+    key := fs.String("key", "", "Encryption key (optional)")
+    fs.Parse(args)
+    st := openReadOnlyOrDie(*storePath, *key)
+    defer st.Close()
+    log, err := st.AuditLog()
+    if err != nil {
+        return fail("Error", err)
+    }
+    for _, e := range log {
+        blob := e.BlobID
+        if blob == "" {
+            blob = "-"
+        }
+        fmt.Printf("%s  %s  %s  refused: %s\n", e.Time.Format("2006-01-02 15:04:05 MST"), e.Op, blob, e.Reason)
+    }
+    return 0
+}
+
+func statsCmd(args []string) int {
+    fs := flag.NewFlagSet("stats", flag.ExitOnError)
+    storePath := fs.String("store", "./store", "Store directory")
+    key := fs.String("key", "", "Encryption key (optional)")
+    fs.Parse(args)
+    st := openReadOnlyOrDie(*storePath, *key)
+    defer st.Close()
     stats, err := st.Stats()
     if err != nil {
-        fmt.Printf("Error: %v\n", err)
-        os.Exit(1)
+        return fail("Error", err)
     }
     fmt.Printf("Total blobs: %d\n", stats.TotalBlobCount)
     fmt.Printf("Max count per level: %v\n", stats.MaxCountPerLevel)
     fmt.Printf("Average count per level: %.2f\n", stats.AverageCountPerLevel)
+    return 0
 }
 
-func inspectCmd(args []string) {
+func inspectCmd(args []string) int {
     fs := flag.NewFlagSet("inspect", flag.ExitOnError)
     storePath := fs.String("store", "./store", "Store directory")
     key := fs.String("key", "", "Encryption key (optional)")
     fs.Parse(args)
-    st := openStoreOrDie(*storePath, *key)
+    st := openReadOnlyOrDie(*storePath, *key)
+    defer st.Close()
     blobs, err := st.InspectStore()
     if err != nil {
-        fmt.Printf("Error: %v\n", err)
-        os.Exit(1)
+        return fail("Error", err)
     }
 
     // Sort blobs by name first, then by IngestionTime (newest first)
@@ -288,17 +919,26 @@ func inspectCmd(args []string) {
         fmt.Printf("%s [%s]\n", blob.Name, blob.BlobHash)
         fmt.Printf("  %s, %d bytes\n", blob.IngestionTime.Format("2006-01-02 15:04:05 UTC"), blob.Length)
         fmt.Printf("  URI: %s Owner: %s\n", blob.URI, blob.Owner)
+        if blob.MIMEType != "" {
+            fmt.Printf("  Type: %s\n", blob.MIMEType)
+        }
         fmt.Println()
     }
+    return 0
 }
 
-func searchCmd(args []string) {
+func searchCmd(args []string) int {
     fs := flag.NewFlagSet("search", flag.ExitOnError)
     storePath := fs.String("store", "./store", "Store directory")
     key := fs.String("key", "", "Encryption key (optional)")
     limit := fs.Int("limit", 10, "Maximum results to return")
     offset := fs.Int("offset", 0, "Starting offset for pagination")
     highlight := fs.Bool("highlight", true, "Show highlighted matches")
+    lang := fs.String("lang", "", "Also match stemmed content in this language, e.g. de")
+    mimeType := fs.String("type", "", "Only return blobs of this MIME type, e.g. image/*")
+    mode := fs.String("mode", "", "Query mode: match, phrase, fuzzy, prefix, wildcard or regexp (default: query syntax)")
+    fuzziness := fs.Int("fuzziness", 1, "Edit distance for --mode fuzzy (1 or 2)")
+    field := fs.String("field", "", "Field to search with --mode, e.g. name (default: all)")
     fs.Parse(args)
 
     if fs.NArg() < 1 {
@@ -309,32 +949,50 @@ func searchCmd(args []string) {
         fmt.Println("  --limit <n>         Maximum results (default: 10)")
         fmt.Println("  --offset <n>        Starting offset (default: 0)")
         fmt.Println("  --highlight         Show highlighted matches (default: true)")
-        os.Exit(1)
+        fmt.Println("  --lang <code>       Also match stemmed content in this language (e.g. de, ru, cjk)")
+        fmt.Println("  --type <mime>       Only return blobs of this MIME type (e.g. image/*)")
+        fmt.Println("  --mode <mode>       match, phrase, fuzzy, prefix, wildcard or regexp (default: query syntax)")
+        fmt.Println("  --fuzziness <n>     Edit distance for --mode fuzzy, 1 or 2 (default: 1)")
+        fmt.Println("  --field <name>      Field to search with --mode, e.g. name (default: all)")
+        return exitError
     }
 
     query := fs.Arg(0)
-    st := openStoreOrDie(*storePath, *key)
+    st := openSearchOrDie(*storePath, *key)
+    defer st.Close()
 
     // Prepare search request
     req := gblobs.SearchRequest{
         Query:     query,
+        Mode:      gblobs.SearchMode(*mode),
+        Field:     *field,
         Limit:     *limit,
         Offset:    *offset,
         Highlight: *highlight,
+        Language:  *lang,
+        MIMEType:  *mimeType,
+    }
+    if req.Mode == gblobs.SearchFuzzy {
+        req.Fuzziness = *fuzziness
     }
 
     results, err := st.SearchWithOptions(req)
     if err != nil {
-        fmt.Printf("Search error: %v\n", err)
-        os.Exit(1)
+        return fail("Search error", err)
     }
 
     if len(results) == 0 {
         fmt.Printf("No results found for query: \"%s\"\n", query)
-        return
+        return 0
     }
 
     fmt.Printf("Found %d results for \"%s\":\n\n", len(results), query)
+    printResults(results, *highlight)
+    return 0
+}
+
+// printResults lists search hits with their metadata and, if wanted, highlights.
+func printResults(results []gblobs.SearchResult, highlight bool) {
     for i, result := range results {
         fmt.Printf("%d. %s (score: %.3f)\n",
             i+1, result.Metadata.Name, result.Score)
@@ -344,12 +1002,16 @@ func searchCmd(args []string) {
             result.Metadata.Length,
             result.Metadata.IngestionTime.Format("2006-01-02 15:04:05"))
 
+        if result.Metadata.MIMEType != "" {
+            fmt.Printf(", %s", result.Metadata.MIMEType)
+        }
+
         if result.Metadata.Owner != "" {
             fmt.Printf(", owner: %s", result.Metadata.Owner)
         }
         fmt.Println()
 
-        if *highlight && len(result.Highlights) > 0 {
+        if highlight && len(result.Highlights) > 0 {
             for field, fragments := range result.Highlights {
                 if len(fragments) > 0 {
                     fmt.Printf("   %s: %s\n", field, strings.Join(fragments, " ... "))
@@ -359,3 +1021,154 @@ func searchCmd(args []string) {
         fmt.Println()
     }
 }
+
+func suggestCmd(args []string) int {
+    fs := flag.NewFlagSet("suggest", flag.ExitOnError)
+    storePath := fs.String("store", "./store", "Store directory")
+    key := fs.String("key", "", "Encryption key (optional)")
+    field := fs.String("field", "name", "Field to complete, e.g. name, content or owner")
+    limit := fs.Int("limit", 10, "Maximum suggestions to return")
+    fs.Parse(args)
+    if fs.NArg() < 1 {
+        fmt.Println("Usage: gblobs suggest <prefix> [flags]")
+        return exitError
+    }
+    st := openSearchOrDie(*storePath, *key)
+    defer st.Close()
+    suggestions, err := st.Suggest(fs.Arg(0), *field, *limit)
+    if err != nil {
+        return fail("Error", err)
+    }
+    for _, sg := range suggestions {
+        fmt.Printf("%s\t%d\n", sg.Term, sg.Count)
+    }
+    return 0
+}
+
+func similarCmd(args []string) int {
+    fs := flag.NewFlagSet("similar", flag.ExitOnError)
+    storePath := fs.String("store", "./store", "Store directory")
+    key := fs.String("key", "", "Encryption key (optional)")
+    limit := fs.Int("limit", 10, "Maximum results to return")
+    fs.Parse(args)
+    if fs.NArg() < 1 {
+        fmt.Println("Usage: gblobs similar <blobID> [flags]")
+        return exitError
+    }
+    blobID := fs.Arg(0)
+    st := openSearchOrDie(*storePath, *key)
+    defer st.Close()
+    results, err := st.SimilarTo(blobID, *limit)
+    if err != nil {
+        return fail("Error", err)
+    }
+    if len(results) == 0 {
+        fmt.Printf("No blobs similar to %s\n", blobID)
+        return 0
+    }
+    fmt.Printf("Found %d blobs similar to %s:\n\n", len(results), blobID)
+    printResults(results, false)
+    return 0
+}
+
+func nearDupsCmd(args []string) int {
+    fs := flag.NewFlagSet("near-dups", flag.ExitOnError)
+    storePath := fs.String("store", "./store", "Store directory")
+    key := fs.String("key", "", "Encryption key (optional)")
+    threshold := fs.Float64("threshold", 0.9, "Minimum estimated similarity (0-1)")
+    fs.Parse(args)
+    st := openSearchOrDie(*storePath, *key)
+    defer st.Close()
+    clusters, err := st.NearDuplicates(*threshold)
+    if err != nil {
+        return fail("Error", err)
+    }
+    if len(clusters) == 0 {
+        fmt.Printf("No near-duplicates at threshold %.2f\n", *threshold)
+        return 0
+    }
+    var total int64
+    for i, c := range clusters {
+        fmt.Printf("Cluster %d: %d blobs, %d bytes\n", i+1, len(c.Blobs), c.TotalSize)
+        for _, b := range c.Blobs {
+            fmt.Printf("  %s  %10d bytes  %.2f  %s\n", b.BlobID, b.Metadata.Length, b.Similarity, b.Metadata.Name)
+        }
+        fmt.Println()
+        total += c.TotalSize
+    }
+    fmt.Printf("%d clusters, %d bytes in near-duplicate blobs\n", len(clusters), total)
+    return 0
+}
+
+func reindexCmd(args []string) int {
+    fs := flag.NewFlagSet("reindex", flag.ExitOnError)
+    storePath := fs.String("store", "./store", "Store directory")
+    key := fs.String("key", "", "Encryption key (optional)")
+    failedOnly := fs.Bool("failed-only", false, "Only retry blobs whose indexing failed (see health)")
+    fs.Parse(args)
+
+    // Open even if the index is outdated or missing; that is what we are here to fix
+    st := openOrDie(&gblobs.LocalStore{MappingPolicy: gblobs.MappingIgnore}, *storePath, *key)
+    defer st.Close()
+
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+    defer stop()
+
+    var last gblobs.ReindexProgress
+    report := func(p gblobs.ReindexProgress) {
+        last = p
+        fmt.Fprintf(os.Stderr, "\rIndexed %d/%d blobs (%d failed)", p.Indexed, p.Total, p.Failed)
+    }
+    var err error
+    if *failedOnly {
+        err = st.RepairIndex(ctx, report)
+    } else {
+        err = st.Reindex(ctx, report)
+    }
+    fmt.Fprintln(os.Stderr)
+    if err != nil {
+        return fail("Reindex error", err)
+    }
+    fmt.Printf("reindexed %d blobs, %d failed\n", last.Indexed, last.Failed)
+    return 0
+}
+
+func healthCmd(args []string) int {
+    fs := flag.NewFlagSet("health", flag.ExitOnError)
+    storePath := fs.String("store", "./store", "Store directory")
+    key := fs.String("key", "", "Encryption key (optional)")
+    fs.Parse(args)
+
+    // Report on outdated indexes instead of refusing to open them
+    st := openOrDie(&gblobs.LocalStore{MappingPolicy: gblobs.MappingIgnore}, *storePath, *key)
+    defer st.Close()
+    h, err := st.IndexHealth()
+    if err != nil {
+        return fail("Error", err)
+    }
+
+    fmt.Printf("Blobs:           %d\n", h.Blobs)
+    fmt.Printf("Indexed docs:    %d\n", h.Indexed)
+    fmt.Printf("Pending:         %d\n", h.Pending)
+    if h.Outdated {
+        fmt.Printf("Mapping version: %d (outdated, current is %d)\n", h.MappingVersion, gblobs.IndexMappingVersion)
+    } else {
+        fmt.Printf("Mapping version: %d (current)\n", h.MappingVersion)
+    }
+    fmt.Printf("Failed:          %d\n", len(h.Failed))
+    for _, f := range h.Failed {
+        fmt.Printf("  %s  %s  %s\n", f.BlobID, f.Time.Format("2006-01-02 15:04:05"), f.Error)
+    }
+
+    switch {
+    case h.Healthy():
+        fmt.Println("Index is healthy")
+    case len(h.Failed) > 0 && !h.Outdated:
+        fmt.Println("Index needs repair: run `gblobs reindex --failed-only`")
+        return exitError
+    default:
+        fmt.Println("Index needs a rebuild: run `gblobs reindex`")
+        return exitError
+    }
+    return 0
+}
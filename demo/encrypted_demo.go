@@ -20,11 +20,13 @@ func main() {
     blobID, err := st.PutBlob(data, meta)
     if err != nil { panic(err) }
     fmt.Println("Encrypted blob id:", blobID)
+    if err := st.Close(); err != nil { panic(err) }
 
     // Open with correct key
     st2 := &gblobs.LocalStore{}
     err = st2.OpenStore(storePath, key)
     if err != nil { panic(err) }
+    defer st2.Close()
     plain, meta2, err := st2.GetBlob(blobID)
     fmt.Printf("Decrypted content: %q (meta: %v)\n", plain, meta2)
 }
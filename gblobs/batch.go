@@ -0,0 +1,222 @@
+package gblobs
+
+import (
+    "context"
+    "runtime"
+    "sync"
+    "sync/atomic"
+)
+
+// PutItem is one blob for PutBlobs.
+type PutItem struct {
+    Data []byte
+    Meta BlobType
+}
+
+// PutResult is the outcome of one PutItem.
+type PutResult struct {
+    BlobID       string
+    Deduplicated bool  // The blob was stored already; only its index entry was updated
+    Err          error // Why the blob could not be stored
+}
+
+// GetResult is the outcome of one blob ID given to GetBlobs.
+type GetResult struct {
+    BlobID   string
+    Data     []byte
+    Metadata BlobType
+    Err      error
+}
+
+// DeleteResult is the outcome of one blob ID given to DeleteBlobs.
+type DeleteResult struct {
+    BlobID string
+    Err    error
+}
+
+// PutBlobs stores many blobs with BatchWorkers goroutines. Results are in the
+// order of items; a failed item does not stop the others. The index updates of
+// the whole batch are committed in one Bleve batch. The returned error is only
+// set if the batch as a whole failed, e.g. because the store is read-only.
+func (s *LocalStore) PutBlobs(items []PutItem) ([]PutResult, error) {
+    return s.PutBlobsContext(context.Background(), items)
+}
+
+// PutBlobsContext is PutBlobs with a context; items not yet stored when it is
+// done fail with ctx.Err(), which is also returned.
+func (s *LocalStore) PutBlobsContext(ctx context.Context, items []PutItem) ([]PutResult, error) {
+    if err := s.checkOpen(); err != nil {
+        return nil, err
+    }
+    if err := s.checkWritable(); err != nil {
+        return nil, err
+    }
+    results := make([]PutResult, len(items))
+    ops := make([]indexOp, len(items))
+    var dirs sync.Map // Directories made in this batch
+    ensureDir := func(dir string) error {
+        if _, ok := dirs.Load(dir); ok {
+            return nil
+        }
+        if err := EnsureDir(dir); err != nil {
+            return err
+        }
+        dirs.Store(dir, struct{}{})
+        return nil
+    }
+
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+    s.forEach(ctx, len(items), func(i int) {
+        r := &results[i]
+        p, err := s.preparePut(ctx, items[i].Data, items[i].Meta, ensureDir)
+        if err != nil {
+            r.Err = err
+            return
+        }
+        r.BlobID = p.blobID
+        bl := s.blobLocks.of(p.blobID)
+        bl.Lock()
+        defer bl.Unlock()
+        if r.Deduplicated, r.Err = s.writePrepared(ctx, &p); r.Err == nil {
+            ops[i] = indexOp{blobID: p.blobID, doc: &p.doc}
+        }
+    }, func(i int, err error) {
+        results[i].Err = err
+    })
+    if err := s.enqueueGroup(compactOps(ops)); err != nil {
+        // The index updates of all stored blobs went into the failed write
+        for i := range results {
+            if results[i].Err == nil {
+                results[i].Err = err
+            }
+        }
+    }
+    return results, ctx.Err()
+}
+
+// GetBlobs reads many blobs with BatchWorkers goroutines. Like GetBlob, it
+// takes ref names as well as blob IDs. Results are in the order of blobIDs,
+// with BlobID set to the resolved ID; a missing or unreadable blob only fails
+// its own result.
+func (s *LocalStore) GetBlobs(blobIDs []string) ([]GetResult, error) {
+    return s.GetBlobsContext(context.Background(), blobIDs)
+}
+
+// GetBlobsContext is GetBlobs with a context; blobs not yet read when it is
+// done fail with ctx.Err(), which is also returned.
+func (s *LocalStore) GetBlobsContext(ctx context.Context, blobIDs []string) ([]GetResult, error) {
+    if err := s.checkOpen(); err != nil {
+        return nil, err
+    }
+    results := make([]GetResult, len(blobIDs))
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+    s.forEach(ctx, len(blobIDs), func(i int) {
+        r := &results[i]
+        r.BlobID = blobIDs[i]
+        blobID, err := s.resolveIDLocked(r.BlobID)
+        if err != nil {
+            r.Err = err
+            return
+        }
+        r.BlobID = blobID
+        bl := s.blobLocks.of(r.BlobID)
+        bl.RLock()
+        defer bl.RUnlock()
+        r.Data, r.Metadata, r.Err = s.readBlob(ctx, r.BlobID)
+    }, func(i int, err error) {
+        results[i] = GetResult{BlobID: blobIDs[i], Err: err}
+    })
+    return results, ctx.Err()
+}
+
+// DeleteBlobs removes many blobs with BatchWorkers goroutines, removing them
+// from the index in one Bleve batch. Like DeleteBlob, deleting a blob that does
+// not exist succeeds.
+func (s *LocalStore) DeleteBlobs(blobIDs []string) ([]DeleteResult, error) {
+    return s.DeleteBlobsContext(context.Background(), blobIDs)
+}
+
+// DeleteBlobsContext is DeleteBlobs with a context; blobs not yet removed when
+// it is done fail with ctx.Err(), which is also returned.
+func (s *LocalStore) DeleteBlobsContext(ctx context.Context, blobIDs []string) ([]DeleteResult, error) {
+    if err := s.checkOpen(); err != nil {
+        return nil, err
+    }
+    if err := s.checkWritable(); err != nil {
+        return nil, err
+    }
+    results := make([]DeleteResult, len(blobIDs))
+    ops := make([]indexOp, len(blobIDs))
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+    s.forEach(ctx, len(blobIDs), func(i int) {
+        r := &results[i]
+        r.BlobID = blobIDs[i]
+        if r.Err = ValidateBlobID(r.BlobID); r.Err != nil {
+            return
+        }
+        bl := s.blobLocks.of(r.BlobID)
+        bl.Lock()
+        defer bl.Unlock()
+        if r.Err = s.checkDeletable(r.BlobID); r.Err != nil {
+            return
+        }
+        if r.Err = s.removeBlob(r.BlobID); r.Err != nil {
+            return
+        }
+        ops[i] = indexOp{blobID: r.BlobID}
+    }, func(i int, err error) {
+        results[i] = DeleteResult{BlobID: blobIDs[i], Err: err}
+    })
+    if err := s.enqueueGroup(compactOps(ops)); err != nil {
+        for i := range results {
+            if results[i].Err == nil {
+                results[i].Err = err
+            }
+        }
+    }
+    return results, ctx.Err()
+}
+
+// forEach calls do for 0..n-1 from BatchWorkers goroutines. Once ctx is done,
+// the remaining indexes go to skip instead.
+func (s *LocalStore) forEach(ctx context.Context, n int, do func(i int), skip func(i int, err error)) {
+    workers := s.BatchWorkers
+    if workers <= 0 {
+        workers = runtime.GOMAXPROCS(0)
+    }
+    workers = min(workers, n)
+    var next atomic.Int64
+    var wg sync.WaitGroup
+    for w := 0; w < workers; w++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for {
+                i := int(next.Add(1) - 1)
+                if i >= n {
+                    return
+                }
+                if err := ctx.Err(); err != nil {
+                    skip(i, err)
+                    continue
+                }
+                do(i)
+            }
+        }()
+    }
+    wg.Wait()
+}
+
+// compactOps drops the zero ops of items that failed.
+func compactOps(ops []indexOp) []indexOp {
+    out := ops[:0]
+    for _, op := range ops {
+        if op.blobID != "" {
+            out = append(out, op)
+        }
+    }
+    return out
+}
@@ -0,0 +1,113 @@
+package gblobs
+
+import (
+    "bytes"
+    "context"
+    "io"
+    "os"
+    "path/filepath"
+)
+
+// ioChunkSize is how much is read or written between context checks.
+const ioChunkSize = 1 << 20
+
+// ctxReader fails reads with ctx.Err() once ctx is done.
+type ctxReader struct {
+    ctx context.Context
+    r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+    if err := r.ctx.Err(); err != nil {
+        return 0, err
+    }
+    if len(p) > ioChunkSize {
+        p = p[:ioChunkSize]
+    }
+    return r.r.Read(p)
+}
+
+// readFileContext is os.ReadFile that stops between chunks when ctx is done.
+func readFileContext(ctx context.Context, name string) ([]byte, error) {
+    if err := ctx.Err(); err != nil {
+        return nil, err
+    }
+    f, err := os.Open(name)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    var buf bytes.Buffer
+    if fi, err := f.Stat(); err == nil {
+        buf.Grow(int(fi.Size()) + bytes.MinRead)
+    }
+    if _, err := buf.ReadFrom(ctxReader{ctx, f}); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+// writeFileContext is os.WriteFile that stops between chunks when ctx is done,
+// removing the partly written file.
+func writeFileContext(ctx context.Context, name string, data []byte, perm os.FileMode) error {
+    return writeFileFlags(ctx, name, data, os.O_TRUNC, perm)
+}
+
+// createFileContext is writeFileContext for a file that must not exist yet, so
+// that it never writes through a symlink or over another file.
+func createFileContext(ctx context.Context, name string, data []byte, perm os.FileMode) error {
+    return writeFileFlags(ctx, name, data, os.O_EXCL, perm)
+}
+
+func writeFileFlags(ctx context.Context, name string, data []byte, flag int, perm os.FileMode) error {
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+    f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|flag, perm)
+    if err != nil {
+        return err
+    }
+    for len(data) > 0 {
+        if err = ctx.Err(); err != nil {
+            break
+        }
+        n := min(len(data), ioChunkSize)
+        if _, err = f.Write(data[:n]); err != nil {
+            break
+        }
+        data = data[n:]
+    }
+    if cerr := f.Close(); err == nil {
+        err = cerr
+    }
+    if err != nil {
+        os.Remove(name)
+    }
+    return err
+}
+
+// writeFileAtomic is writeFileContext into a temporary file that is renamed
+// over name once complete, so readers see either the old file or the new one.
+func writeFileAtomic(ctx context.Context, name string, data []byte, perm os.FileMode) error {
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+    f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*.tmp")
+    if err != nil {
+        return err
+    }
+    tmp := f.Name()
+    f.Close()
+    if err := writeFileContext(ctx, tmp, data, perm); err != nil {
+        return err
+    }
+    if err := os.Chmod(tmp, perm); err != nil {
+        os.Remove(tmp)
+        return err
+    }
+    if err := os.Rename(tmp, name); err != nil {
+        os.Remove(tmp)
+        return err
+    }
+    return nil
+}
@@ -0,0 +1,159 @@
+package gblobs
+
+import (
+    "context"
+    "path"
+    "slices"
+    "strings"
+)
+
+// ChangeKind says how an entry differs between two trees.
+type ChangeKind string
+
+const (
+    ChangeAdded    ChangeKind = "added"
+    ChangeRemoved  ChangeKind = "removed"
+    ChangeModified ChangeKind = "modified" // Content, type or permissions changed
+    ChangeRenamed  ChangeKind = "renamed"  // Same content under another path
+)
+
+// TreeChange is one difference found by DiffTrees.
+type TreeChange struct {
+    Kind    ChangeKind `json:"kind"`
+    Path    string     `json:"path"`              // Path in the new tree; in the old tree if removed
+    OldPath string     `json:"oldPath,omitempty"` // Path in the old tree, if renamed
+    Old     *TreeEntry `json:"old,omitempty"`     // Entry in the old tree; nil if added
+    New     *TreeEntry `json:"new,omitempty"`     // Entry in the new tree; nil if removed
+}
+
+// DiffTrees compares two snapshots, see DiffTreesContext.
+func (s *LocalStore) DiffTrees(oldTreeID, newTreeID string) ([]TreeChange, error) {
+    return s.DiffTreesContext(context.Background(), oldTreeID, newTreeID)
+}
+
+// DiffTreesContext lists the files and symlinks that differ between two
+// snapshots, sorted by path. A file that was removed while a file with the
+// same blob ID was added elsewhere is reported as renamed. Changed
+// modification times alone are not a change, and directories are only
+// compared through their entries. Subtrees with equal IDs are not read.
+func (s *LocalStore) DiffTreesContext(ctx context.Context, oldTreeID, newTreeID string) ([]TreeChange, error) {
+    if err := s.checkOpen(); err != nil {
+        return nil, err
+    }
+    d := treeDiff{s: s}
+    if err := d.compare(ctx, "", oldTreeID, newTreeID); err != nil {
+        return nil, err
+    }
+    d.matchRenames()
+    return d.changes, nil
+}
+
+// treeDiff collects the changes of a DiffTrees run.
+type treeDiff struct {
+    s       *LocalStore
+    changes []TreeChange
+}
+
+// compare diffs the trees of one directory; an empty ID stands for a
+// directory that does not exist on that side.
+func (d *treeDiff) compare(ctx context.Context, dir, oldID, newID string) error {
+    if oldID == newID {
+        return nil
+    }
+    var oldTree, newTree Tree
+    var err error
+    if oldID != "" {
+        if oldTree, err = d.s.GetTreeContext(ctx, oldID); err != nil {
+            return err
+        }
+    }
+    if newID != "" {
+        if newTree, err = d.s.GetTreeContext(ctx, newID); err != nil {
+            return err
+        }
+    }
+    olds := map[string]TreeEntry{}
+    for _, e := range oldTree.Entries {
+        olds[e.Name] = e
+    }
+    for _, n := range newTree.Entries {
+        p := path.Join(dir, n.Name)
+        o, ok := olds[n.Name]
+        delete(olds, n.Name)
+        switch {
+        case !ok:
+            if err := d.added(ctx, p, n); err != nil {
+                return err
+            }
+        case o.IsDir() && n.IsDir():
+            if err := d.compare(ctx, p, o.TreeID, n.TreeID); err != nil {
+                return err
+            }
+        case o.IsDir() || n.IsDir():
+            // A directory replaced by a file or the other way round
+            if err := d.removed(ctx, p, o); err != nil {
+                return err
+            }
+            if err := d.added(ctx, p, n); err != nil {
+                return err
+            }
+        case o.BlobID != n.BlobID || o.Target != n.Target || o.Mode != n.Mode:
+            d.changes = append(d.changes, TreeChange{Kind: ChangeModified, Path: p, Old: &o, New: &n})
+        }
+    }
+    for _, o := range oldTree.Entries {
+        if _, ok := olds[o.Name]; ok {
+            if err := d.removed(ctx, path.Join(dir, o.Name), o); err != nil {
+                return err
+            }
+        }
+    }
+    return ctx.Err()
+}
+
+// added records e and, for a directory, everything in it as added.
+func (d *treeDiff) added(ctx context.Context, p string, e TreeEntry) error {
+    if e.IsDir() {
+        return d.compare(ctx, p, "", e.TreeID)
+    }
+    d.changes = append(d.changes, TreeChange{Kind: ChangeAdded, Path: p, New: &e})
+    return nil
+}
+
+// removed records e and, for a directory, everything in it as removed.
+func (d *treeDiff) removed(ctx context.Context, p string, e TreeEntry) error {
+    if e.IsDir() {
+        return d.compare(ctx, p, e.TreeID, "")
+    }
+    d.changes = append(d.changes, TreeChange{Kind: ChangeRemoved, Path: p, Old: &e})
+    return nil
+}
+
+// matchRenames sorts the changes by path, then pairs removed and added files
+// with the same blob ID, in path order, and replaces each pair by a rename.
+func (d *treeDiff) matchRenames() {
+    slices.SortFunc(d.changes, func(a, b TreeChange) int { return strings.Compare(a.Path, b.Path) })
+    removed := map[string][]int{} // Blob ID -> indexes of removals
+    for i, c := range d.changes {
+        if c.Kind == ChangeRemoved && c.Old.BlobID != "" {
+            removed[c.Old.BlobID] = append(removed[c.Old.BlobID], i)
+        }
+    }
+    drop := map[int]bool{}
+    for i, c := range d.changes {
+        if c.Kind != ChangeAdded || c.New.BlobID == "" || len(removed[c.New.BlobID]) == 0 {
+            continue
+        }
+        j := removed[c.New.BlobID][0]
+        removed[c.New.BlobID] = removed[c.New.BlobID][1:]
+        d.changes[i] = TreeChange{Kind: ChangeRenamed, Path: c.Path, OldPath: d.changes[j].Path, Old: d.changes[j].Old, New: c.New}
+        drop[j] = true
+    }
+    kept := d.changes[:0]
+    for i, c := range d.changes {
+        if !drop[i] {
+            kept = append(kept, c)
+        }
+    }
+    d.changes = kept
+}
@@ -8,6 +8,9 @@ import (
     "io"
 )
 
+// errShortCiphertext is returned by DecryptBlob for data too short to hold a nonce.
+var errShortCiphertext = errors.New("ciphertext too short")
+
 // EncryptBlob encrypts data using AES-GCM with the provided key.
 // If key is empty, no encryption is performed and data is returned unchanged.
 func EncryptBlob(data []byte, key []byte) ([]byte, error) {
@@ -48,7 +51,7 @@ func DecryptBlob(encData []byte, key []byte) ([]byte, error) {
     }
     nonceSize := gcm.NonceSize()
     if len(encData) < nonceSize {
-        return nil, errors.New("ciphertext too short")
+        return nil, errShortCiphertext
     }
     nonce, ciphertext := encData[:nonceSize], encData[nonceSize:]
     return gcm.Open(nil, nonce, ciphertext, nil)
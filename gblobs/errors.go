@@ -0,0 +1,65 @@
+package gblobs
+
+import (
+    "errors"
+    "fmt"
+)
+
+// Errors returned by Store methods. They wrap the underlying cause, so test
+// for them with errors.Is rather than comparing.
+var (
+    // ErrNotFound means the blob (or store directory) does not exist.
+    ErrNotFound = errors.New("not found")
+    // ErrCorrupt means a blob, its metadata or a store file cannot be decoded.
+    ErrCorrupt = errors.New("corrupt data")
+    // ErrWrongKey means a blob could not be decrypted with the store's key:
+    // the key is wrong, or the ciphertext was tampered with.
+    ErrWrongKey = errors.New("wrong encryption key")
+    // ErrIndexUnavailable means the search index cannot serve the request.
+    ErrIndexUnavailable = errors.New("search index unavailable")
+    // ErrStoreClosed means the store has not been opened, or has been closed.
+    ErrStoreClosed = errors.New("store is closed")
+    // ErrInvalidID means a blob ID is not a valid blob ID.
+    ErrInvalidID = errors.New("invalid blob id")
+    // ErrStoreLocked means another process (or LocalStore) holds a conflicting
+    // lock on the store, such as a second writer.
+    ErrStoreLocked = errors.New("store is locked")
+    // ErrReadOnly means a write was attempted on a store opened with ReadOnly.
+    ErrReadOnly = errors.New("store is read-only")
+    // ErrNotTree means a blob used as a tree is not a tree object.
+    ErrNotTree = errors.New("not a tree")
+    // ErrRefConflict means a ref did not point where UpdateRef expected, or
+    // its name clashes with another ref's.
+    ErrRefConflict = errors.New("ref conflict")
+    // ErrRetentionLocked means a blob is under a retention lock or legal hold,
+    // or the store is write-once, so it cannot be deleted or its metadata changed.
+    ErrRetentionLocked = errors.New("retention locked")
+)
+
+// ErrInvalidRef means a name is not a valid ref name. It matches ErrInvalidID
+// too, as GetBlob takes either.
+var ErrInvalidRef = fmt.Errorf("%w: invalid ref name", ErrInvalidID)
+
+// blobError wraps cause in kind, naming the blob, e.g. "not found: blob ab12...: <cause>".
+func blobError(kind error, blobID string, cause error) error {
+    if cause == nil {
+        return fmt.Errorf("%w: blob %s", kind, blobID)
+    }
+    return fmt.Errorf("%w: blob %s: %w", kind, blobID, cause)
+}
+
+// checkOpen returns ErrStoreClosed unless the store has been created or opened.
+func (s *LocalStore) checkOpen() error {
+    if s.path == "" {
+        return ErrStoreClosed
+    }
+    return nil
+}
+
+// checkWritable returns ErrReadOnly if the store was opened with ReadOnly.
+func (s *LocalStore) checkWritable() error {
+    if s.ReadOnly {
+        return ErrReadOnly
+    }
+    return nil
+}
@@ -0,0 +1,244 @@
+package gblobs
+
+import (
+    "context"
+    "fmt"
+    "path"
+    "strings"
+    "time"
+
+    "github.com/blevesearch/bleve/v2"
+    "github.com/blevesearch/bleve/v2/search/query"
+)
+
+// RetentionRule expires the blobs it matches MaxAge after their IngestionTime.
+// Rules are stored in the store manifest, see SetRetentionRules.
+type RetentionRule struct {
+    Owner    string        `json:"owner,omitempty"`    // Only blobs of this owner; empty matches all
+    MIMEType string        `json:"mimeType,omitempty"` // Only this media type or pattern, e.g. "image/*"; empty matches all
+    MaxAge   time.Duration `json:"maxAge"`
+}
+
+// matches reports whether r applies to a blob with meta.
+func (r RetentionRule) matches(meta BlobType) bool {
+    if r.Owner != "" && r.Owner != meta.Owner {
+        return false
+    }
+    if r.MIMEType != "" {
+        ok, _ := path.Match(strings.ToLower(r.MIMEType), strings.ToLower(meta.MIMEType))
+        return ok
+    }
+    return true
+}
+
+// ExpiredBlob is a blob found by Expire.
+type ExpiredBlob struct {
+    BlobID    string
+    Metadata  BlobType
+    ExpiredAt time.Time
+}
+
+// RetentionRules returns the store's retention rules.
+func (s *LocalStore) RetentionRules() []RetentionRule {
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+    return append([]RetentionRule(nil), s.manifest.Retention...)
+}
+
+// SetRetentionRules replaces the store's retention rules. They apply to blobs
+// stored before as well; Search hides what they expire right away.
+func (s *LocalStore) SetRetentionRules(rules []RetentionRule) error {
+    if err := s.checkOpen(); err != nil {
+        return err
+    }
+    if err := s.checkWritable(); err != nil {
+        return err
+    }
+    for _, r := range rules {
+        if r.MaxAge <= 0 {
+            return fmt.Errorf("retention rule: max age must be positive, not %v", r.MaxAge)
+        }
+        if _, err := path.Match(r.MIMEType, ""); err != nil {
+            return fmt.Errorf("retention rule: bad MIME type pattern %q: %w", r.MIMEType, err)
+        }
+    }
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    m := s.manifest
+    m.Retention = append([]RetentionRule(nil), rules...)
+    if err := saveManifest(s.path, m); err != nil {
+        return err
+    }
+    s.manifest = m
+    return nil
+}
+
+// expiresAt returns when a blob with meta expires under rules: the earliest of
+// its ExpiresAt and what the rules matching it give. Zero means never.
+func expiresAt(meta BlobType, rules []RetentionRule) time.Time {
+    if isVersionHistory(meta) {
+        // Expiring it would leave its ref pointing at nothing
+        return time.Time{}
+    }
+    at := meta.ExpiresAt
+    for _, r := range rules {
+        if !r.matches(meta) {
+            continue
+        }
+        if t := meta.IngestionTime.Add(r.MaxAge); at.IsZero() || t.Before(at) {
+            at = t
+        }
+    }
+    return at
+}
+
+// Expire deletes the blobs that have expired, see ExpireContext.
+func (s *LocalStore) Expire(dryRun bool) ([]ExpiredBlob, error) {
+    return s.ExpireContext(context.Background(), dryRun)
+}
+
+// ExpireContext deletes the blobs whose ExpiresAt, or a retention rule, has
+// passed, and returns them. With dryRun it only returns them. Blobs under a
+// retention lock or legal hold expire once that ends; in a write-once store
+// nothing expires.
+func (s *LocalStore) ExpireContext(ctx context.Context, dryRun bool) ([]ExpiredBlob, error) {
+    if err := s.checkOpen(); err != nil {
+        return nil, err
+    }
+    if !dryRun {
+        if err := s.checkWritable(); err != nil {
+            return nil, err
+        }
+    }
+    ids, err := s.listBlobIDs(ctx)
+    if err != nil {
+        return nil, err
+    }
+    rules := s.RetentionRules()
+    now := NowUTC()
+    var expired []ExpiredBlob
+    var expiredIDs []string
+    for _, id := range ids {
+        if err := ctx.Err(); err != nil {
+            return nil, err
+        }
+        meta, err := s.readMeta(id)
+        if err != nil {
+            // Deleted meanwhile, or broken; either way not ours to judge
+            continue
+        }
+        if at := expiresAt(meta, rules); !at.IsZero() && !at.After(now) && s.lockReason(meta, now) == "" {
+            expired = append(expired, ExpiredBlob{BlobID: id, Metadata: meta, ExpiredAt: at})
+            expiredIDs = append(expiredIDs, id)
+        }
+    }
+    if dryRun || len(expired) == 0 {
+        return expired, nil
+    }
+    results, err := s.DeleteBlobsContext(ctx, expiredIDs)
+    if err != nil {
+        return nil, err
+    }
+    deleted := expired[:0]
+    for i, r := range results {
+        if r.Err == nil {
+            deleted = append(deleted, expired[i])
+        }
+    }
+    return deleted, nil
+}
+
+// startSweeper runs Expire every ExpireInterval until Close.
+func (s *LocalStore) startSweeper() {
+    if s.ExpireInterval <= 0 || s.ReadOnly || s.WORM {
+        return
+    }
+    stop, done := make(chan struct{}), make(chan struct{})
+    s.sweeperStop, s.sweeperDone = stop, done
+    go func() {
+        defer close(done)
+        t := time.NewTicker(s.ExpireInterval)
+        defer t.Stop()
+        for {
+            select {
+            case <-stop:
+                return
+            case <-t.C:
+            }
+            ctx, cancel := context.WithCancel(context.Background())
+            go func() {
+                select {
+                case <-stop:
+                    cancel()
+                case <-ctx.Done():
+                }
+            }()
+            if _, err := s.ExpireContext(ctx, false); err != nil && ctx.Err() == nil {
+                s.logf("Warning: expiring blobs failed: %v", err)
+            }
+            cancel()
+        }
+    }()
+}
+
+// stopSweeper stops the goroutine started by startSweeper and waits for it.
+func (s *LocalStore) stopSweeper() {
+    if s.sweeperStop == nil {
+        return
+    }
+    close(s.sweeperStop)
+    <-s.sweeperDone
+    s.sweeperStop, s.sweeperDone = nil, nil
+}
+
+// withoutHidden narrows q to the blobs searches return: those that have not
+// expired at now and are not version histories.
+func (s *LocalStore) withoutHidden(q query.Query, now time.Time) query.Query {
+    b := bleve.NewBooleanQuery()
+    b.AddMust(q)
+    b.AddMustNot(s.expiredQuery(now), mimeTypeQuery(VersionsMIMEType))
+    return b
+}
+
+// expiredQuery matches the blobs that have expired at now, by their ExpiresAt
+// or a retention rule, and are not protected from deletion.
+func (s *LocalStore) expiredQuery(now time.Time) query.Query {
+    if s.WORM {
+        return bleve.NewMatchNoneQuery()
+    }
+    expired := []query.Query{dateUpTo("expiresAt", now)}
+    for _, r := range s.RetentionRules() {
+        conj := []query.Query{dateUpTo("ingestionTime", now.Add(-r.MaxAge))}
+        if r.Owner != "" {
+            oq := bleve.NewTermQuery(r.Owner)
+            oq.SetField("owner")
+            conj = append(conj, oq)
+        }
+        if r.MIMEType != "" {
+            conj = append(conj, mimeTypeQuery(r.MIMEType))
+        }
+        expired = append(expired, bleve.NewConjunctionQuery(conj...))
+    }
+    held := bleve.NewBoolFieldQuery(true)
+    held.SetField("legalHold")
+    b := bleve.NewBooleanQuery()
+    b.AddMust(bleve.NewDisjunctionQuery(expired...))
+    b.AddMustNot(held, dateAfter("retainUntil", now))
+    return b
+}
+
+// dateAfter matches documents whose date field is after t.
+func dateAfter(field string, t time.Time) query.Query {
+    exclusive := false
+    q := bleve.NewDateRangeInclusiveQuery(t, time.Time{}, &exclusive, nil)
+    q.SetField(field)
+    return q
+}
+
+// dateUpTo matches documents whose date field is at or before t.
+func dateUpTo(field string, t time.Time) query.Query {
+    inclusive := true
+    q := bleve.NewDateRangeInclusiveQuery(time.Time{}, t, nil, &inclusive)
+    q.SetField(field)
+    return q
+}
@@ -0,0 +1,114 @@
+package gblobs
+
+import (
+    "mime"
+    "path/filepath"
+    "strings"
+    "sync"
+)
+
+// TextExtractor turns the raw bytes of a blob into plain text for the search index.
+// Implementations should return an error rather than partial garbage when the data
+// is not in the format they understand; the store then falls back to plain text detection.
+type TextExtractor interface {
+    ExtractText(data []byte, meta BlobType) (string, error)
+}
+
+// TextExtractorFunc adapts an ordinary function to the TextExtractor interface.
+type TextExtractorFunc func(data []byte, meta BlobType) (string, error)
+
+// ExtractText calls f(data, meta).
+func (f TextExtractorFunc) ExtractText(data []byte, meta BlobType) (string, error) {
+    return f(data, meta)
+}
+
+// maxExtractBytes caps how much the built-in extractors decompress from one
+// blob, so that a small crafted DOCX, ODT or PDF cannot expand to gigabytes in
+// memory. Text beyond it is not indexed.
+const maxExtractBytes = 32 << 20
+
+var (
+    extractorsMu sync.RWMutex
+    extractors   = map[string]TextExtractor{}
+)
+
+// RegisterTextExtractor registers x for a MIME type (e.g. "application/pdf") or a
+// file extension including the dot (e.g. ".pdf"). Keys are case-insensitive.
+// Registering a key again replaces the previous extractor, so the built-in
+// extractors can be overridden; a nil x removes the registration.
+func RegisterTextExtractor(key string, x TextExtractor) {
+    key = strings.ToLower(key)
+    extractorsMu.Lock()
+    defer extractorsMu.Unlock()
+    if x == nil {
+        delete(extractors, key)
+        return
+    }
+    extractors[key] = x
+}
+
+// lookupTextExtractor finds the extractor for a blob: first by the extension of
+// its name, then of its URI, then by its MIME type (sniffed if not recorded).
+func lookupTextExtractor(data []byte, meta BlobType) TextExtractor {
+    extractorsMu.RLock()
+    defer extractorsMu.RUnlock()
+    if len(extractors) == 0 {
+        return nil
+    }
+    for _, name := range []string{meta.Name, meta.URI} {
+        if ext := strings.ToLower(filepath.Ext(name)); ext != "" {
+            if x, ok := extractors[ext]; ok {
+                return x
+            }
+        }
+    }
+    mt := meta.MIMEType
+    if mt == "" {
+        mt = DetectMIMEType(data, "")
+    }
+    if mt, _, err := mime.ParseMediaType(mt); err == nil {
+        if x, ok := extractors[mt]; ok {
+            return x
+        }
+    }
+    return nil
+}
+
+func init() {
+    html := TextExtractorFunc(extractHTMLText)
+    for _, k := range []string{".html", ".htm", ".xhtml", "text/html", "application/xhtml+xml"} {
+        RegisterTextExtractor(k, html)
+    }
+    pdf := TextExtractorFunc(extractPDFText)
+    for _, k := range []string{".pdf", "application/pdf"} {
+        RegisterTextExtractor(k, pdf)
+    }
+    docx := TextExtractorFunc(extractDOCXText)
+    for _, k := range []string{".docx", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"} {
+        RegisterTextExtractor(k, docx)
+    }
+    odt := TextExtractorFunc(extractODTText)
+    for _, k := range []string{".odt", "application/vnd.oasis.opendocument.text"} {
+        RegisterTextExtractor(k, odt)
+    }
+    eml := TextExtractorFunc(extractEMLText)
+    for _, k := range []string{".eml", "message/rfc822"} {
+        RegisterTextExtractor(k, eml)
+    }
+    RegisterTextExtractor(TreeMIMEType, TextExtractorFunc(extractTreeText))
+    // Version histories are found through their URI, not their content
+    RegisterTextExtractor(VersionsMIMEType, TextExtractorFunc(func([]byte, BlobType) (string, error) { return "", nil }))
+}
+
+// collapseSpace trims s and folds runs of blank lines and spaces into single ones.
+func collapseSpace(s string) string {
+    lines := strings.Split(s, "\n")
+    out := make([]string, 0, len(lines))
+    for _, l := range lines {
+        l = strings.Join(strings.Fields(l), " ")
+        if l != "" {
+            out = append(out, l)
+        }
+    }
+    return strings.Join(out, "\n")
+}
@@ -0,0 +1,121 @@
+package gblobs
+
+import (
+    "bytes"
+    "encoding/base64"
+    "io"
+    "mime"
+    "mime/multipart"
+    "mime/quotedprintable"
+    "net/mail"
+    "net/textproto"
+    "strings"
+)
+
+// emlMaxDepth bounds recursion into nested multiparts and attached messages.
+const emlMaxDepth = 8
+
+// extractEMLText returns the main headers and the text parts of an RFC 5322 email.
+// HTML parts are stripped, and attachments are run through the registered extractors.
+func extractEMLText(data []byte, _ BlobType) (string, error) {
+    var out strings.Builder
+    if err := writeEMLMessage(&out, data, 0); err != nil {
+        return "", err
+    }
+    return collapseSpace(out.String()), nil
+}
+
+// writeEMLMessage parses a whole message and writes its headers and body text.
+func writeEMLMessage(out *strings.Builder, data []byte, depth int) error {
+    msg, err := mail.ReadMessage(bytes.NewReader(data))
+    if err != nil {
+        return err
+    }
+    dec := new(mime.WordDecoder)
+    for _, h := range []string{"Subject", "From", "To", "Cc", "Date"} {
+        v := msg.Header.Get(h)
+        if v == "" {
+            continue
+        }
+        if decoded, err := dec.DecodeHeader(v); err == nil {
+            v = decoded
+        }
+        out.WriteString(h + ": " + v + "\n")
+    }
+    out.WriteByte('\n')
+    body, err := io.ReadAll(msg.Body)
+    if err != nil {
+        return err
+    }
+    return writeEMLPart(out, textproto.MIMEHeader(msg.Header), body, depth)
+}
+
+// writeEMLPart decodes one MIME entity and writes whatever text it carries.
+func writeEMLPart(out *strings.Builder, header textproto.MIMEHeader, body []byte, depth int) error {
+    if depth > emlMaxDepth {
+        return nil
+    }
+    body = decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body)
+
+    mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+    if err != nil {
+        mediaType = "text/plain"
+    }
+    switch {
+    case strings.HasPrefix(mediaType, "multipart/"):
+        mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+        for {
+            part, err := mr.NextRawPart()
+            if err != nil {
+                // io.EOF, or a damaged message: keep what was read so far
+                return nil
+            }
+            pb, err := io.ReadAll(part)
+            if err != nil {
+                return nil
+            }
+            if err := writeEMLPart(out, part.Header, pb, depth+1); err != nil {
+                return err
+            }
+        }
+    case mediaType == "message/rfc822":
+        return writeEMLMessage(out, body, depth+1)
+    case mediaType == "text/html":
+        out.WriteString(stripHTML(string(body)))
+    case strings.HasPrefix(mediaType, "text/"):
+        out.Write(body)
+    default:
+        // Attachment: index it if some extractor understands it
+        var meta BlobType
+        if _, p, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil {
+            meta.Name = p["filename"]
+        }
+        if meta.Name == "" {
+            meta.Name = params["name"]
+        }
+        if x := lookupTextExtractor(body, meta); x != nil {
+            if text, err := x.ExtractText(body, meta); err == nil {
+                out.WriteString(text)
+            }
+        }
+    }
+    out.WriteByte('\n')
+    return nil
+}
+
+// decodeTransferEncoding undoes base64 or quoted-printable encoding of a part body.
+func decodeTransferEncoding(encoding string, body []byte) []byte {
+    switch strings.ToLower(strings.TrimSpace(encoding)) {
+    case "base64":
+        // The decoder skips the line breaks of wrapped bodies
+        dec := base64.NewDecoder(base64.StdEncoding, bytes.NewReader(body))
+        if b, err := io.ReadAll(dec); err == nil {
+            return b
+        }
+    case "quoted-printable":
+        if b, err := io.ReadAll(quotedprintable.NewReader(bytes.NewReader(body))); err == nil {
+            return b
+        }
+    }
+    return body
+}
@@ -0,0 +1,148 @@
+package gblobs
+
+import (
+    "html"
+    "strings"
+)
+
+// htmlBlockTags are elements that start a new line of text when stripped.
+var htmlBlockTags = map[string]bool{
+    "p": true, "br": true, "div": true, "li": true, "tr": true, "td": true, "th": true,
+    "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+    "title": true, "section": true, "article": true, "header": true, "footer": true,
+    "blockquote": true, "pre": true, "table": true, "ul": true, "ol": true, "hr": true,
+}
+
+// extractHTMLText strips tags, comments, scripts and styles from an HTML document
+// and returns its visible text with entities decoded.
+func extractHTMLText(data []byte, _ BlobType) (string, error) {
+    return stripHTML(string(data)), nil
+}
+
+// stripHTML is a forgiving tag stripper; it does not need well-formed input.
+func stripHTML(src string) string {
+    var out strings.Builder
+    i := 0
+    for i < len(src) {
+        lt := strings.IndexByte(src[i:], '<')
+        if lt < 0 {
+            out.WriteString(html.UnescapeString(src[i:]))
+            break
+        }
+        out.WriteString(html.UnescapeString(src[i : i+lt]))
+        i += lt
+
+        // A '<' not followed by a tag name is just text, as in "a < b"
+        if i+1 >= len(src) || !isHTMLTagStart(src[i+1]) {
+            out.WriteByte('<')
+            i++
+            continue
+        }
+
+        // Comments and doctype/CDATA declarations
+        if strings.HasPrefix(src[i:], "<!--") {
+            end := strings.Index(src[i+4:], "-->")
+            if end < 0 {
+                break
+            }
+            i += 4 + end + 3
+            continue
+        }
+        if strings.HasPrefix(src[i:], "<!") || strings.HasPrefix(src[i:], "<?") {
+            end := strings.IndexByte(src[i:], '>')
+            if end < 0 {
+                break
+            }
+            i += end + 1
+            continue
+        }
+
+        end := htmlTagEnd(src, i)
+        if end < 0 {
+            break
+        }
+        tag := src[i+1 : end]
+        i = end + 1
+        name := strings.ToLower(strings.TrimPrefix(tag, "/"))
+        if n := strings.IndexAny(name, " \t\r\n/"); n >= 0 {
+            name = name[:n]
+        }
+        if name == "script" || name == "style" {
+            if strings.HasPrefix(tag, "/") {
+                continue
+            }
+            // Skip the element body up to its closing tag
+            closing := indexASCIIFold(src[i:], "</"+name)
+            if closing < 0 {
+                break
+            }
+            i += closing
+            continue
+        }
+        if htmlBlockTags[name] {
+            out.WriteByte('\n')
+        } else {
+            out.WriteByte(' ')
+        }
+    }
+    return collapseSpace(out.String())
+}
+
+// indexASCIIFold is strings.Index ignoring ASCII case in s. lower must be
+// lowercase and start with a byte that has no case, such as '<'. Unlike
+// lowercasing s, it does not copy the rest of the document for every search,
+// and its offsets stay valid for non-ASCII text.
+func indexASCIIFold(s, lower string) int {
+    for i := 0; ; i++ {
+        j := strings.IndexByte(s[i:], lower[0])
+        if j < 0 {
+            return -1
+        }
+        i += j
+        if hasPrefixASCIIFold(s[i:], lower) {
+            return i
+        }
+    }
+}
+
+// hasPrefixASCIIFold reports whether s starts with lower, ignoring ASCII case.
+func hasPrefixASCIIFold(s, lower string) bool {
+    if len(s) < len(lower) {
+        return false
+    }
+    for k := 0; k < len(lower); k++ {
+        c := s[k]
+        if 'A' <= c && c <= 'Z' {
+            c += 'a' - 'A'
+        }
+        if c != lower[k] {
+            return false
+        }
+    }
+    return true
+}
+
+// htmlTagEnd returns the index of the '>' closing the tag that starts at src[start],
+// skipping over quoted attribute values, or -1 if the tag is never closed.
+func htmlTagEnd(src string, start int) int {
+    var quote byte
+    for j := start + 1; j < len(src); j++ {
+        c := src[j]
+        switch {
+        case quote != 0:
+            if c == quote {
+                quote = 0
+            }
+        case (c == '"' || c == '\'') && src[j-1] == '=':
+            quote = c
+        case c == '>':
+            return j
+        }
+    }
+    return -1
+}
+
+// isHTMLTagStart reports whether c can follow '<' in markup.
+func isHTMLTagStart(c byte) bool {
+    return c == '/' || c == '!' || c == '?' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
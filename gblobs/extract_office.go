@@ -0,0 +1,114 @@
+package gblobs
+
+import (
+    "archive/zip"
+    "bytes"
+    "encoding/xml"
+    "fmt"
+    "io"
+    "strings"
+)
+
+// extractDOCXText returns the paragraphs of a Word (Office Open XML) document.
+func extractDOCXText(data []byte, _ BlobType) (string, error) {
+    body, truncated, err := readZipEntry(data, "word/document.xml")
+    if err != nil {
+        return "", err
+    }
+    text, err := xmlText(body, map[string]bool{"p": true, "br": true, "cr": true, "tab": true}, "t")
+    if truncated {
+        // The cut leaves the XML unterminated; keep the text before it
+        err = nil
+    }
+    return text, err
+}
+
+// extractODTText returns the paragraphs and headings of an OpenDocument text file.
+func extractODTText(data []byte, _ BlobType) (string, error) {
+    body, truncated, err := readZipEntry(data, "content.xml")
+    if err != nil {
+        return "", err
+    }
+    text, err := xmlText(body, map[string]bool{"p": true, "h": true, "line-break": true, "tab": true, "s": true}, "")
+    if truncated {
+        err = nil
+    }
+    return text, err
+}
+
+// readZipEntry returns the contents of one file inside a zip archive, cut at
+// maxExtractBytes; truncated tells whether it was.
+func readZipEntry(data []byte, name string) (body []byte, truncated bool, err error) {
+    zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+    if err != nil {
+        return nil, false, err
+    }
+    for _, f := range zr.File {
+        if f.Name != name {
+            continue
+        }
+        rc, err := f.Open()
+        if err != nil {
+            return nil, false, err
+        }
+        defer rc.Close()
+        body, err := io.ReadAll(io.LimitReader(rc, maxExtractBytes+1))
+        if err != nil {
+            return nil, false, err
+        }
+        if len(body) > maxExtractBytes {
+            return body[:maxExtractBytes], true, nil
+        }
+        return body, false, nil
+    }
+    return nil, false, fmt.Errorf("archive has no %s", name)
+}
+
+// xmlText collects the character data of an XML document. Elements whose local
+// name is in breaks end a line (or add a space for tab-like elements). If textElem
+// is set, only character data inside elements of that local name is kept. On a
+// syntax error it returns the text collected so far along with the error.
+func xmlText(body []byte, breaks map[string]bool, textElem string) (string, error) {
+    var out strings.Builder
+    dec := xml.NewDecoder(bytes.NewReader(body))
+    depth := 0 // nesting inside textElem
+    for {
+        tok, err := dec.Token()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return collapseSpace(out.String()), err
+        }
+        switch t := tok.(type) {
+        case xml.StartElement:
+            if t.Name.Local == textElem {
+                depth++
+            }
+            switch t.Name.Local {
+            case "tab", "s":
+                if breaks[t.Name.Local] {
+                    out.WriteByte(' ')
+                }
+            case "br", "cr", "line-break":
+                if breaks[t.Name.Local] {
+                    out.WriteByte('\n')
+                }
+            }
+        case xml.EndElement:
+            if t.Name.Local == textElem {
+                depth--
+            }
+            if t.Name.Local == "p" || t.Name.Local == "h" {
+                if breaks[t.Name.Local] {
+                    out.WriteByte('\n')
+                }
+            }
+        case xml.CharData:
+            if textElem == "" || depth > 0 {
+                out.Write(t)
+            }
+        }
+    }
+    return collapseSpace(out.String()), nil
+}
@@ -0,0 +1,289 @@
+package gblobs
+
+import (
+    "bytes"
+    "compress/zlib"
+    "errors"
+    "io"
+    "strconv"
+    "strings"
+)
+
+// extractPDFText pulls the text shown by text operators (Tj, TJ, ', ") out of a
+// PDF's content streams. It is deliberately simple: streams must be unfiltered or
+// FlateDecode, and fonts with custom encodings may yield unreadable text, which
+// is dropped. That covers most text-based PDFs produced by office tools.
+func extractPDFText(data []byte, _ BlobType) (string, error) {
+    if !bytes.HasPrefix(data, []byte("%PDF-")) {
+        return "", errors.New("not a PDF document")
+    }
+    var out strings.Builder
+    rest := data
+    budget := int64(maxExtractBytes) // decompressed bytes left, see maxExtractBytes
+    for {
+        start := bytes.Index(rest, []byte("stream"))
+        if start < 0 {
+            break
+        }
+        dict := pdfStreamDict(rest[:start])
+        body := rest[start+len("stream"):]
+        // The keyword is followed by CRLF or LF before the data
+        if bytes.HasPrefix(body, []byte("\r\n")) {
+            body = body[2:]
+        } else if bytes.HasPrefix(body, []byte("\n")) {
+            body = body[1:]
+        } else {
+            // "endstream" or a false match inside other data
+            rest = body
+            continue
+        }
+        end := bytes.Index(body, []byte("endstream"))
+        if end < 0 {
+            break
+        }
+        content := body[:end]
+        rest = body[end+len("endstream"):]
+
+        if bytes.Contains(dict, []byte("/Image")) {
+            continue
+        }
+        if bytes.Contains(dict, []byte("/Filter")) {
+            if !bytes.Contains(dict, []byte("/FlateDecode")) {
+                continue
+            }
+            if budget <= 0 {
+                continue
+            }
+            zr, err := zlib.NewReader(bytes.NewReader(content))
+            if err != nil {
+                continue
+            }
+            // Truncated streams still yield useful text up to the damage
+            content, _ = io.ReadAll(io.LimitReader(zr, budget))
+            zr.Close()
+            budget -= int64(len(content))
+        }
+        if text := pdfContentText(content); text != "" {
+            out.WriteString(text)
+            out.WriteByte('\n')
+        }
+    }
+    return collapseSpace(out.String()), nil
+}
+
+// pdfStreamDict returns the dictionary preceding a stream keyword, i.e. the text
+// after the last "obj" in before.
+func pdfStreamDict(before []byte) []byte {
+    if i := bytes.LastIndex(before, []byte("obj")); i >= 0 {
+        return before[i:]
+    }
+    return before
+}
+
+// pdfContentText interprets the text operators of a content stream.
+func pdfContentText(content []byte) string {
+    var out strings.Builder
+    var operands []string // strings seen since the last operator
+    inText := false
+    inArray := false
+    i := 0
+    for i < len(content) {
+        c := content[i]
+        switch {
+        case c == '(':
+            s, n := pdfLiteralString(content[i:])
+            operands = append(operands, s)
+            i += n
+        case c == '<' && i+1 < len(content) && content[i+1] != '<':
+            end := bytes.IndexByte(content[i:], '>')
+            if end < 0 {
+                return out.String()
+            }
+            operands = append(operands, pdfHexString(content[i+1:i+end]))
+            i += end + 1
+        case c == '[':
+            operands = operands[:0]
+            inArray = true
+            i++
+        case c == ']':
+            inArray = false
+            i++
+        case c == '/':
+            // Name object, e.g. a font resource
+            i++
+            for i < len(content) && isPDFRegular(content[i]) {
+                i++
+            }
+        case (c >= '0' && c <= '9') || c == '-' || c == '+' || c == '.':
+            j := i + 1
+            for j < len(content) && isPDFRegular(content[j]) {
+                j++
+            }
+            // In TJ arrays a large negative adjustment separates words
+            if inArray {
+                if v, err := strconv.ParseFloat(string(content[i:j]), 64); err == nil && v < -200 {
+                    operands = append(operands, " ")
+                }
+            }
+            i = j
+        case c == '%':
+            // Comment to end of line
+            for i < len(content) && content[i] != '\n' && content[i] != '\r' {
+                i++
+            }
+        case isPDFRegular(c):
+            j := i
+            for j < len(content) && isPDFRegular(content[j]) {
+                j++
+            }
+            op := string(content[i:j])
+            i = j
+            switch op {
+            case "BT":
+                inText = true
+            case "ET":
+                inText = false
+                out.WriteByte('\n')
+            case "Tj", "TJ":
+                if inText {
+                    for _, s := range operands {
+                        if pdfReadable(s) {
+                            out.WriteString(s)
+                        }
+                    }
+                }
+            case "Td", "TD", "T*", "Tm":
+                if inText {
+                    out.WriteByte(' ')
+                }
+            }
+            operands = operands[:0]
+        default:
+            if c == '\'' || c == '"' {
+                // Single-character operators
+                if inText {
+                    out.WriteByte('\n')
+                    for _, s := range operands {
+                        if pdfReadable(s) {
+                            out.WriteString(s)
+                        }
+                    }
+                }
+                operands = operands[:0]
+            }
+            i++
+        }
+    }
+    return out.String()
+}
+
+// isPDFRegular reports whether c is a regular (non-delimiter, non-space) PDF character.
+func isPDFRegular(c byte) bool {
+    switch c {
+    case ' ', '\t', '\r', '\n', '\f', 0, '(', ')', '<', '>', '[', ']', '{', '}', '/', '%', '\'', '"':
+        return false
+    }
+    return true
+}
+
+// pdfLiteralString decodes a (...) string starting at b[0] and returns it with the
+// number of bytes consumed.
+func pdfLiteralString(b []byte) (string, int) {
+    var out []byte
+    depth := 0
+    i := 0
+    for i < len(b) {
+        c := b[i]
+        switch c {
+        case '(':
+            if depth > 0 {
+                out = append(out, c)
+            }
+            depth++
+        case ')':
+            depth--
+            if depth == 0 {
+                return string(out), i + 1
+            }
+            out = append(out, c)
+        case '\\':
+            i++
+            if i >= len(b) {
+                return string(out), i
+            }
+            switch e := b[i]; e {
+            case 'n':
+                out = append(out, '\n')
+            case 'r':
+                out = append(out, '\r')
+            case 't':
+                out = append(out, '\t')
+            case 'b', 'f':
+            case '\r', '\n':
+                // Line continuation
+            default:
+                if e >= '0' && e <= '7' {
+                    v := 0
+                    k := 0
+                    for k < 3 && i < len(b) && b[i] >= '0' && b[i] <= '7' {
+                        v = v*8 + int(b[i]-'0')
+                        i++
+                        k++
+                    }
+                    i--
+                    out = append(out, byte(v))
+                } else {
+                    out = append(out, e)
+                }
+            }
+        default:
+            out = append(out, c)
+        }
+        i++
+    }
+    return string(out), i
+}
+
+// pdfHexString decodes the body of a <...> hex string.
+func pdfHexString(b []byte) string {
+    var out []byte
+    var hi byte
+    half := false
+    for _, c := range b {
+        var v byte
+        switch {
+        case c >= '0' && c <= '9':
+            v = c - '0'
+        case c >= 'a' && c <= 'f':
+            v = c - 'a' + 10
+        case c >= 'A' && c <= 'F':
+            v = c - 'A' + 10
+        default:
+            continue
+        }
+        if half {
+            out = append(out, hi<<4|v)
+        } else {
+            hi = v
+        }
+        half = !half
+    }
+    if half {
+        out = append(out, hi<<4)
+    }
+    return string(out)
+}
+
+// pdfReadable filters out strings in custom font encodings, which decode to control bytes.
+func pdfReadable(s string) bool {
+    if s == "" {
+        return false
+    }
+    printable := 0
+    for i := 0; i < len(s); i++ {
+        if c := s[i]; c >= 32 || c == '\t' || c == '\n' || c == '\r' {
+            printable++
+        }
+    }
+    return float64(printable)/float64(len(s)) > 0.8
+}
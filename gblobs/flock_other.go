@@ -0,0 +1,15 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package gblobs
+
+import "os"
+
+// flock is not implemented on this platform: stores are not protected against
+// use by several processes, beyond the lock the search index takes itself.
+func flock(f *os.File, exclusive bool) error {
+    return nil
+}
+
+func funlock(f *os.File) error {
+    return nil
+}
@@ -0,0 +1,36 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package gblobs
+
+import (
+    "errors"
+    "os"
+    "syscall"
+)
+
+// flock places an advisory lock on f without waiting, converting any lock
+// already held through f. It returns errLockBusy if another lock conflicts.
+func flock(f *os.File, exclusive bool) error {
+    how := syscall.LOCK_SH
+    if exclusive {
+        how = syscall.LOCK_EX
+    }
+    for {
+        err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+        switch {
+        case err == nil:
+            return nil
+        case errors.Is(err, syscall.EINTR):
+            continue
+        case errors.Is(err, syscall.EWOULDBLOCK):
+            return errLockBusy
+        default:
+            return err
+        }
+    }
+}
+
+// funlock releases the lock held through f.
+func funlock(f *os.File) error {
+    return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,254 @@
+package gblobs
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "time"
+)
+
+// indexFailedFile lists blobs whose last index update failed, so a later run can repair them.
+const indexFailedFile = "index.failed"
+
+// Logger receives warnings the store cannot return to a caller, such as a failed
+// background index commit. *log.Logger satisfies it.
+type Logger interface {
+    Printf(format string, v ...any)
+}
+
+// IndexFailure records a blob whose last index update failed.
+type IndexFailure struct {
+    BlobID string
+    Error  string
+    Time   time.Time
+}
+
+// IndexHealth describes how well the search index covers the blobs on disk.
+type IndexHealth struct {
+    Blobs          int            // Blob files in the store
+    Indexed        uint64         // Documents in the search index
+    Pending        int            // Index updates queued but not committed yet
+    Failed         []IndexFailure // Blobs whose last index update failed, sorted by ID
+    MappingVersion int            // Mapping version of the index; 0 if it does not cover the blobs
+    Outdated       bool           // MappingVersion differs from IndexMappingVersion
+}
+
+// Healthy reports whether the index is current and has no failed blobs, and,
+// once nothing is pending, holds exactly one document per blob.
+func (h IndexHealth) Healthy() bool {
+    if len(h.Failed) > 0 || h.Outdated {
+        return false
+    }
+    return h.Pending > 0 || h.Indexed == uint64(h.Blobs)
+}
+
+// logf reports a warning through Logger, or the standard logger (stderr) if none is set.
+func (s *LocalStore) logf(format string, v ...any) {
+    if s.Logger != nil {
+        s.Logger.Printf(format, v...)
+        return
+    }
+    log.Printf(format, v...)
+}
+
+// loadIndexFailures reads the failed-blob list of the store, if there is one.
+func (s *LocalStore) loadIndexFailures() error {
+    s.failedMu.Lock()
+    defer s.failedMu.Unlock()
+    s.failed = map[string]IndexFailure{}
+    b, err := os.ReadFile(filepath.Join(s.path, indexFailedFile))
+    if os.IsNotExist(err) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    var list []IndexFailure
+    if err := json.Unmarshal(b, &list); err != nil {
+        // Losing the list only means fewer blobs are repaired; a full reindex still covers them
+        s.logf("Warning: ignoring unreadable %s: %v", indexFailedFile, err)
+        return nil
+    }
+    for _, f := range list {
+        s.failed[f.BlobID] = f
+    }
+    return nil
+}
+
+// saveIndexFailures atomically writes the failed-blob list; failedMu must be held.
+func (s *LocalStore) saveIndexFailures() {
+    p := filepath.Join(s.path, indexFailedFile)
+    if len(s.failed) == 0 {
+        if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+            s.logf("Warning: failed to remove %s: %v", indexFailedFile, err)
+        }
+        return
+    }
+    b, err := json.MarshalIndent(s.indexFailures(), "", "  ")
+    if err == nil {
+        tmp := p + ".tmp"
+        if err = os.WriteFile(tmp, b, 0o600); err == nil {
+            err = os.Rename(tmp, p)
+        }
+    }
+    if err != nil {
+        s.logf("Warning: failed to save %s: %v", indexFailedFile, err)
+    }
+}
+
+// indexFailures returns the failed blobs sorted by ID; failedMu must be held.
+func (s *LocalStore) indexFailures() []IndexFailure {
+    list := make([]IndexFailure, 0, len(s.failed))
+    for _, f := range s.failed {
+        list = append(list, f)
+    }
+    sort.Slice(list, func(i, j int) bool { return list[i].BlobID < list[j].BlobID })
+    return list
+}
+
+// recordIndexResults clears blobs that were indexed from the failed list, adds
+// the ones that failed and tells OnIndexError about each failure.
+func (s *LocalStore) recordIndexResults(ok []string, failed map[string]error) {
+    s.failedMu.Lock()
+    changed := false
+    for _, blobID := range ok {
+        if _, was := s.failed[blobID]; was {
+            delete(s.failed, blobID)
+            changed = true
+        }
+    }
+    now := time.Now().UTC()
+    for blobID, err := range failed {
+        s.failed[blobID] = IndexFailure{BlobID: blobID, Error: err.Error(), Time: now}
+        changed = true
+    }
+    if changed {
+        s.saveIndexFailures()
+    }
+    s.failedMu.Unlock()
+
+    if s.OnIndexError != nil {
+        for blobID, err := range failed {
+            s.OnIndexError(blobID, err)
+        }
+    }
+}
+
+// resetIndexFailures replaces the failed list, e.g. after a rebuild.
+func (s *LocalStore) resetIndexFailures(failed map[string]error) {
+    s.failedMu.Lock()
+    had := len(s.failed) > 0
+    s.failed = map[string]IndexFailure{}
+    if had && len(failed) == 0 {
+        s.saveIndexFailures()
+    }
+    s.failedMu.Unlock()
+    if len(failed) > 0 {
+        s.recordIndexResults(nil, failed)
+    }
+}
+
+// IndexHealth reports how many blobs are indexed, pending or failed, and whether
+// the index mapping is current.
+func (s *LocalStore) IndexHealth() (IndexHealth, error) {
+    return s.IndexHealthContext(context.Background())
+}
+
+// IndexHealthContext is IndexHealth with a context that can cancel counting the blobs.
+func (s *LocalStore) IndexHealthContext(ctx context.Context) (IndexHealth, error) {
+    if err := s.checkOpen(); err != nil {
+        return IndexHealth{}, err
+    }
+    ids, err := s.listBlobIDs(ctx)
+    if err != nil {
+        return IndexHealth{}, err
+    }
+    h := IndexHealth{Blobs: len(ids)}
+    if s.ReadOnly {
+        release, err := s.useIndex()
+        if err != nil {
+            return IndexHealth{}, err
+        }
+        defer release()
+    }
+
+    s.lock.RLock()
+    h.MappingVersion = s.manifest.MappingVersion
+    if s.index != nil {
+        if h.Indexed, err = s.index.DocCount(); err != nil {
+            s.lock.RUnlock()
+            return IndexHealth{}, err
+        }
+    }
+    s.lock.RUnlock()
+    h.Outdated = h.MappingVersion != IndexMappingVersion
+
+    if q := s.queue; q != nil {
+        q.mu.Lock()
+        h.Pending = len(q.pending) + q.inFlight
+        q.mu.Unlock()
+    }
+
+    s.failedMu.Lock()
+    h.Failed = s.indexFailures()
+    s.failedMu.Unlock()
+    return h, nil
+}
+
+// RepairIndex indexes the blobs whose last index update failed again, and drops
+// the ones that no longer exist from the index. It is much cheaper than Reindex
+// when the index is otherwise sound. progress, if non-nil, is called once at the end.
+func (s *LocalStore) RepairIndex(ctx context.Context, progress func(ReindexProgress)) error {
+    if err := s.checkOpen(); err != nil {
+        return err
+    }
+    if err := s.checkWritable(); err != nil {
+        return err
+    }
+    s.failedMu.Lock()
+    list := s.indexFailures()
+    s.failedMu.Unlock()
+
+    unreadable := map[string]error{}
+    for _, f := range list {
+        if err := ctx.Err(); err != nil {
+            return err
+        }
+        data, meta, err := s.GetBlobContext(ctx, f.BlobID)
+        switch {
+        case err == nil:
+            err = s.enqueueIndex(f.BlobID, s.createIndexDocument(f.BlobID, data, meta))
+        case errors.Is(err, os.ErrNotExist):
+            err = s.enqueueDelete(f.BlobID)
+        default:
+            unreadable[f.BlobID] = err
+            continue
+        }
+        if err != nil {
+            return err
+        }
+    }
+    if len(unreadable) > 0 {
+        s.recordIndexResults(nil, unreadable)
+    }
+    flushErr := s.FlushContext(ctx)
+
+    p := ReindexProgress{Total: len(list)}
+    s.failedMu.Lock()
+    for _, f := range list {
+        if _, still := s.failed[f.BlobID]; still {
+            p.Failed++
+        } else {
+            p.Indexed++
+        }
+    }
+    s.failedMu.Unlock()
+    if progress != nil {
+        progress(p)
+    }
+    return flushErr
+}
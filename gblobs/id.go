@@ -3,6 +3,9 @@ package gblobs
 import (
     "crypto/sha256"
     "encoding/hex"
+    "fmt"
+    "path/filepath"
+    "strings"
 )
 
 // GenerateBlobID computes SHA-256 over input data and returns the hex-encoded blob id.
@@ -11,8 +14,25 @@ func GenerateBlobID(data []byte) string {
     return hex.EncodeToString(h[:])
 }
 
+// ValidateBlobID reports whether blobID can be a blob id: a SHA-256 digest as
+// 64 lowercase hex characters. Anything else fails with ErrInvalidID, so ids
+// such as "../../etc/passwd" never reach the file system.
+func ValidateBlobID(blobID string) error {
+    if len(blobID) != 2*sha256.Size {
+        return fmt.Errorf("%w: %q: want %d hex characters, got %d", ErrInvalidID, blobID, 2*sha256.Size, len(blobID))
+    }
+    for i := 0; i < len(blobID); i++ {
+        c := blobID[i]
+        if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+            return fmt.Errorf("%w: %q: not lowercase hex", ErrInvalidID, blobID)
+        }
+    }
+    return nil
+}
+
 // BlobIDToPath maps a blob id to a store file path using subdirectory grouping,
 // yielding a structure ba/781/6bf/xxxxxx.blob for input id ba7816bf8f01cfea414140de5.
+// It does not check the id; Store methods call ValidateBlobID first.
 func BlobIDToPath(blobID string) string {
     // Sanity: must be at least 8 chars for three levels
     if len(blobID) < 8 {
@@ -24,3 +44,10 @@ func BlobIDToPath(blobID string) string {
     rest := blobID[8:]
     return lvl1 + "/" + lvl2 + "/" + lvl3 + "/" + rest + ".blob"
 }
+
+// BlobPathToID is the inverse of BlobIDToPath: it turns a store-relative blob
+// path such as ba/781/6bf/8f01cfea414140de5.blob back into its blob id.
+func BlobPathToID(relPath string) string {
+    relPath = strings.TrimSuffix(filepath.ToSlash(relPath), ".blob")
+    return strings.ReplaceAll(relPath, "/", "")
+}
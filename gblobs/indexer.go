@@ -0,0 +1,431 @@
+package gblobs
+
+import (
+    "bufio"
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+)
+
+const (
+    // indexBatchSize is the maximum number of documents per Bleve batch commit.
+    indexBatchSize = 1000
+    // indexLinger is how long the worker waits for more documents before
+    // committing a batch that is not full, unless someone is waiting on Flush.
+    indexLinger = 100 * time.Millisecond
+    // indexQueueFile journals pending index work so it survives a crash.
+    indexQueueFile = "index.queue"
+)
+
+// indexOp is one pending change to the search index.
+type indexOp struct {
+    blobID string
+    doc    *IndexDocument // nil means delete
+    group  int            // On the first op of a group: the number of ops to commit together
+}
+
+// indexQueue batches index updates and commits them from a background worker.
+// Every queued op is also appended to a journal file, which is truncated once
+// the queue drains; OpenStore replays whatever a crash left behind.
+type indexQueue struct {
+    mu       sync.Mutex
+    pending  []indexOp
+    inFlight int           // ops taken by the worker but not committed yet
+    urgent   bool          // a Flush is waiting; commit without lingering
+    idle     chan struct{} // closed while nothing is pending or in flight
+    lastErr  error         // most recent batch failure, reported by Flush
+    journal  *os.File
+
+    wake chan struct{}
+    stop chan struct{}
+    done chan struct{}
+}
+
+// startIndexer opens the journal and starts the background index worker.
+func (s *LocalStore) startIndexer() error {
+    f, err := os.OpenFile(filepath.Join(s.path, indexQueueFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+    if err != nil {
+        return err
+    }
+    idle := make(chan struct{})
+    close(idle)
+    q := &indexQueue{
+        idle:    idle,
+        journal: f,
+        wake:    make(chan struct{}, 1),
+        stop:    make(chan struct{}),
+        done:    make(chan struct{}),
+    }
+    s.queue = q
+    go q.run(s)
+    return nil
+}
+
+// stopIndexer commits what is pending and stops the worker.
+func (s *LocalStore) stopIndexer() {
+    q := s.queue
+    if q == nil {
+        return
+    }
+    close(q.stop)
+    <-q.done
+    q.journal.Close()
+    s.queue = nil
+}
+
+// enqueueIndex schedules doc to be indexed under blobID.
+func (s *LocalStore) enqueueIndex(blobID string, doc IndexDocument) error {
+    return s.queue.enqueue(indexOp{blobID: blobID, doc: &doc})
+}
+
+// enqueueDelete schedules blobID to be removed from the index.
+func (s *LocalStore) enqueueDelete(blobID string) error {
+    return s.queue.enqueue(indexOp{blobID: blobID})
+}
+
+// enqueueGroup schedules ops to be committed in the same Bleve batch, however
+// many they are.
+func (s *LocalStore) enqueueGroup(ops []indexOp) error {
+    if len(ops) == 0 {
+        return nil
+    }
+    ops[0].group = len(ops)
+    return s.queue.enqueue(ops...)
+}
+
+// enqueue queues ops and returns once their journal records are on disk. The
+// ops are queued even if journaling fails, but would then be lost in a crash.
+func (q *indexQueue) enqueue(ops ...indexOp) error {
+    if q == nil {
+        return nil
+    }
+    var lines bytes.Buffer
+    for _, op := range ops {
+        if err := writeJournalRecord(&lines, op); err != nil {
+            return fmt.Errorf("failed to journal index update: %w", err)
+        }
+    }
+    q.mu.Lock()
+    if len(q.pending) == 0 && q.inFlight == 0 {
+        q.idle = make(chan struct{})
+    }
+    q.pending = append(q.pending, ops...)
+    _, err := q.journal.Write(lines.Bytes())
+    q.mu.Unlock()
+
+    select {
+    case q.wake <- struct{}{}:
+    default:
+    }
+    if err == nil {
+        // Outside q.mu, so concurrent puts share the wait instead of queueing for it
+        err = q.journal.Sync()
+    }
+    if err != nil {
+        return fmt.Errorf("failed to journal index update: %w", err)
+    }
+    return nil
+}
+
+// writeJournalRecord appends the journal record of op to buf: "-<id>" for a
+// delete, and for an update "+<id>", a tab and the metadata of its document as
+// JSON. The document is rebuilt from the blob on replay, but its metadata may
+// be nowhere else: a deduplicated put only changes the index.
+func writeJournalRecord(buf *bytes.Buffer, op indexOp) error {
+    if op.doc == nil {
+        fmt.Fprintf(buf, "-%s\n", op.blobID)
+        return nil
+    }
+    meta, err := json.Marshal(docMeta(*op.doc))
+    if err != nil {
+        return err
+    }
+    fmt.Fprintf(buf, "+%s\t%s\n", op.blobID, meta)
+    return nil
+}
+
+// docMeta returns the metadata doc was built from, as far as it is indexed.
+func docMeta(doc IndexDocument) BlobType {
+    meta := BlobType{
+        Name:          doc.Name,
+        URI:           doc.URI,
+        Owner:         doc.Owner,
+        Language:      doc.Language,
+        MIMEType:      doc.MIMEType,
+        IngestionTime: doc.IngestionTime,
+        LegalHold:     doc.LegalHold,
+    }
+    if doc.ExpiresAt != nil {
+        meta.ExpiresAt = *doc.ExpiresAt
+    }
+    if doc.RetainUntil != nil {
+        meta.RetainUntil = *doc.RetainUntil
+    }
+    return meta
+}
+
+// run is the worker loop: wait for work, linger briefly to fill the batch, commit.
+func (q *indexQueue) run(s *LocalStore) {
+    defer close(q.done)
+    for {
+        select {
+        case <-q.stop:
+            q.drain(s)
+            return
+        case <-q.wake:
+        }
+        linger := time.NewTimer(indexLinger)
+    wait:
+        for !q.ready() {
+            select {
+            case <-q.wake:
+            case <-linger.C:
+                break wait
+            case <-q.stop:
+                break wait
+            }
+        }
+        linger.Stop()
+        q.drain(s)
+    }
+}
+
+// ready reports whether a batch should be committed right away.
+func (q *indexQueue) ready() bool {
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    return q.urgent || len(q.pending) >= indexBatchSize
+}
+
+// drain commits pending ops in batches until the queue is empty.
+func (q *indexQueue) drain(s *LocalStore) {
+    for {
+        q.mu.Lock()
+        n := len(q.pending)
+        if n == 0 {
+            q.mu.Unlock()
+            return
+        }
+        if n > indexBatchSize {
+            n = indexBatchSize
+            // Do not split a group
+            for i := 0; i < n; i++ {
+                if g := q.pending[i].group; i+g > n {
+                    n = i + g
+                }
+            }
+        }
+        ops := q.pending[:n:n]
+        q.pending = q.pending[n:]
+        q.inFlight = n
+        q.mu.Unlock()
+
+        err := s.applyIndexOps(ops)
+
+        q.mu.Lock()
+        q.inFlight = 0
+        if err != nil {
+            q.lastErr = err
+            s.logf("Warning: failed to index batch of %d blobs: %v", len(ops), err)
+        }
+        if len(q.pending) == 0 {
+            q.urgent = false
+            q.journal.Truncate(0)
+            close(q.idle)
+        }
+        q.mu.Unlock()
+    }
+}
+
+// applyIndexOps writes ops to the live index in one Bleve batch and records
+// which blobs failed. The returned error is that of the batch commit.
+func (s *LocalStore) applyIndexOps(ops []indexOp) error {
+    s.lock.RLock()
+    if s.index == nil {
+        s.lock.RUnlock()
+        return nil
+    }
+    failed := map[string]error{}
+    batch := s.index.NewBatch()
+    for _, op := range ops {
+        if op.doc == nil {
+            batch.Delete(op.blobID)
+            continue
+        }
+        if err := batch.Index(op.blobID, *op.doc); err != nil {
+            failed[op.blobID] = err
+        }
+    }
+    err := s.index.Batch(batch)
+    s.lock.RUnlock()
+
+    ok := make([]string, 0, len(ops))
+    for _, op := range ops {
+        if err != nil {
+            failed[op.blobID] = err
+        } else if _, bad := failed[op.blobID]; !bad {
+            ok = append(ok, op.blobID)
+        }
+    }
+    s.recordIndexResults(ok, failed)
+    return err
+}
+
+// Flush commits all queued index updates now and waits until they are searchable.
+// It returns the error of the last failed batch since the previous Flush, if any.
+func (s *LocalStore) Flush() error {
+    return s.FlushContext(context.Background())
+}
+
+// FlushContext is Flush with a context that bounds the wait.
+func (s *LocalStore) FlushContext(ctx context.Context) error {
+    if err := s.checkOpen(); err != nil {
+        return err
+    }
+    q := s.queue
+    if q == nil {
+        return nil
+    }
+    if err := s.flushQueue(ctx); err != nil {
+        return err
+    }
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    err := q.lastErr
+    q.lastErr = nil
+    return err
+}
+
+// flushQueue asks the worker to commit without lingering and waits for it.
+func (s *LocalStore) flushQueue(ctx context.Context) error {
+    q := s.queue
+    if q == nil {
+        return nil
+    }
+    q.mu.Lock()
+    q.urgent = true
+    q.mu.Unlock()
+    select {
+    case q.wake <- struct{}{}:
+    default:
+    }
+    return s.WaitIndexed(ctx)
+}
+
+// discard drops pending ops and their journal, for when the index they target
+// is going away. Waiters return at once unless a batch is in flight, whose
+// drain then releases them.
+func (q *indexQueue) discard() {
+    if q == nil {
+        return
+    }
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    if len(q.pending) > 0 && q.inFlight == 0 {
+        close(q.idle)
+    }
+    q.pending = nil
+    q.urgent = false
+    if err := q.journal.Truncate(0); err != nil {
+        q.lastErr = err
+    }
+}
+
+// WaitIndexed blocks until the background worker has committed everything queued
+// so far, without hurrying it, or until ctx is done.
+func (s *LocalStore) WaitIndexed(ctx context.Context) error {
+    if err := s.checkOpen(); err != nil {
+        return err
+    }
+    q := s.queue
+    if q == nil {
+        return nil
+    }
+    q.mu.Lock()
+    idle := q.idle
+    q.mu.Unlock()
+    select {
+    case <-idle:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// replayIndexQueue applies index work journaled by a previous process that did
+// not get to commit it, then clears the journal.
+func (s *LocalStore) replayIndexQueue(ctx context.Context) error {
+    p := filepath.Join(s.path, indexQueueFile)
+    f, err := os.Open(p)
+    if os.IsNotExist(err) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    // Only the last op per blob matters
+    last := map[string]bool{}
+    journaled := map[string]*BlobType{} // Metadata of the last update, if recorded
+    var order []string
+    sc := bufio.NewScanner(f)
+    sc.Buffer(nil, 1<<20)
+    for sc.Scan() {
+        line := sc.Text()
+        if len(line) < 2 {
+            continue
+        }
+        // Journals written before metadata was recorded hold the id only
+        id, record, _ := strings.Cut(line[1:], "\t")
+        if _, seen := last[id]; !seen {
+            order = append(order, id)
+        }
+        last[id] = line[0] == '+'
+        journaled[id] = nil
+        var meta BlobType
+        if last[id] && record != "" && json.Unmarshal([]byte(record), &meta) == nil {
+            journaled[id] = &meta
+        }
+    }
+    f.Close()
+    if err := sc.Err(); err != nil {
+        return err
+    }
+    if len(order) == 0 {
+        return nil
+    }
+
+    ops := make([]indexOp, 0, len(order))
+    for _, id := range order {
+        if err := ctx.Err(); err != nil {
+            // The journal is kept, so the next open replays it
+            return err
+        }
+        op := indexOp{blobID: id}
+        if last[id] {
+            data, meta, err := s.readBlob(ctx, id)
+            if err == nil {
+                if m := journaled[id]; m != nil {
+                    m.Fingerprint = meta.Fingerprint
+                    meta = *m
+                }
+                doc := s.createIndexDocument(id, data, meta)
+                op.doc = &doc
+            }
+        }
+        ops = append(ops, op)
+    }
+    for len(ops) > 0 {
+        n := min(len(ops), indexBatchSize)
+        if err := s.applyIndexOps(ops[:n]); err != nil {
+            return fmt.Errorf("failed to replay index queue: %w", err)
+        }
+        ops = ops[n:]
+    }
+    return os.Truncate(p, 0)
+}
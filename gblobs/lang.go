@@ -0,0 +1,127 @@
+package gblobs
+
+import (
+    "strings"
+    "unicode"
+
+    // Language analyzers used by the per-language content fields
+    _ "github.com/blevesearch/bleve/v2/analysis/lang/ar"
+    _ "github.com/blevesearch/bleve/v2/analysis/lang/cjk"
+    _ "github.com/blevesearch/bleve/v2/analysis/lang/de"
+    _ "github.com/blevesearch/bleve/v2/analysis/lang/en"
+    _ "github.com/blevesearch/bleve/v2/analysis/lang/es"
+    _ "github.com/blevesearch/bleve/v2/analysis/lang/fr"
+    _ "github.com/blevesearch/bleve/v2/analysis/lang/hi"
+    _ "github.com/blevesearch/bleve/v2/analysis/lang/it"
+    _ "github.com/blevesearch/bleve/v2/analysis/lang/nl"
+    _ "github.com/blevesearch/bleve/v2/analysis/lang/pt"
+    _ "github.com/blevesearch/bleve/v2/analysis/lang/ru"
+    _ "github.com/blevesearch/bleve/v2/analysis/lang/sv"
+)
+
+// supportedLanguages are the languages that get their own stemmed content field,
+// lang.<code>, analyzed by the Bleve analyzer of the same name.
+var supportedLanguages = []string{"ar", "cjk", "de", "en", "es", "fr", "hi", "it", "nl", "pt", "ru", "sv"}
+
+// languageStopwords are frequent, fairly distinctive words used to tell Latin-script languages apart.
+var languageStopwords = map[string][]string{
+    "en": {"the", "and", "of", "to", "is", "that", "with", "for", "this", "are", "was", "it", "on"},
+    "de": {"der", "die", "und", "das", "ist", "nicht", "mit", "ein", "eine", "auf", "für", "ich", "sich", "auch"},
+    "fr": {"le", "la", "les", "et", "est", "une", "des", "pour", "dans", "pas", "qui", "sur", "avec"},
+    "es": {"el", "los", "las", "y", "es", "una", "por", "para", "con", "del", "se", "como", "pero"},
+    "it": {"il", "lo", "gli", "di", "che", "è", "una", "per", "con", "non", "sono", "della", "anche"},
+    "nl": {"de", "het", "een", "van", "en", "niet", "dat", "met", "voor", "zijn", "ook", "op", "maar"},
+    "pt": {"o", "os", "um", "uma", "não", "para", "com", "do", "da", "é", "em", "mas", "são"},
+    "sv": {"och", "att", "det", "som", "är", "på", "för", "med", "inte", "jag", "har", "av", "till"},
+}
+
+// normalizeLanguage maps a language tag such as "de-AT" or "zh" to one of
+// supportedLanguages, or returns "" if there is no analyzer for it.
+func normalizeLanguage(tag string) string {
+    code := strings.ToLower(tag)
+    if i := strings.IndexAny(code, "-_"); i >= 0 {
+        code = code[:i]
+    }
+    switch code {
+    case "zh", "ja", "ko":
+        return "cjk"
+    }
+    for _, l := range supportedLanguages {
+        if l == code {
+            return code
+        }
+    }
+    return ""
+}
+
+// detectLanguage guesses the language of text: by script for non-Latin writing
+// systems and by stopword frequency for Latin script. It returns "" when unsure.
+func detectLanguage(text string) string {
+    var latin, cyrillic, arabic, cjk, devanagari, seen int
+    for _, r := range text {
+        if seen >= 4000 {
+            break
+        }
+        if !unicode.IsLetter(r) {
+            continue
+        }
+        seen++
+        switch {
+        case unicode.Is(unicode.Latin, r):
+            latin++
+        case unicode.Is(unicode.Cyrillic, r):
+            cyrillic++
+        case unicode.Is(unicode.Arabic, r):
+            arabic++
+        case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+            cjk++
+        case unicode.Is(unicode.Devanagari, r):
+            devanagari++
+        }
+    }
+    if seen == 0 {
+        return ""
+    }
+    switch half := seen / 2; {
+    case cjk > half:
+        return "cjk"
+    case cyrillic > half:
+        return "ru"
+    case arabic > half:
+        return "ar"
+    case devanagari > half:
+        return "hi"
+    case latin <= half:
+        return ""
+    }
+
+    words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+        return !unicode.IsLetter(r)
+    })
+    if len(words) > 1000 {
+        words = words[:1000]
+    }
+    counts := map[string]int{}
+    for _, w := range words {
+        for lang, stop := range languageStopwords {
+            for _, s := range stop {
+                if w == s {
+                    counts[lang]++
+                }
+            }
+        }
+    }
+    best, bestCount, tie := "", 0, false
+    for lang, c := range counts {
+        switch {
+        case c > bestCount:
+            best, bestCount, tie = lang, c, false
+        case c == bestCount:
+            tie = true
+        }
+    }
+    if bestCount < 2 || tie {
+        return ""
+    }
+    return best
+}
@@ -0,0 +1,191 @@
+package gblobs
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+
+    "github.com/blevesearch/bleve/v2"
+    bolt "go.etcd.io/bbolt"
+)
+
+const (
+    // storeLockFile is locked shared by every open store, and exclusively by
+    // PurgeStore while it removes everything.
+    storeLockFile = "gblobs.lock"
+    // writeLockFile is locked exclusively by the one store open for writing.
+    writeLockFile = "gblobs.write.lock"
+
+    // The search index can only be open in one process while it is written to.
+    // A writer waits this long for read-only stores to finish their search...
+    writeIndexTimeout = "5s"
+    // ...and a read-only store this long for a writer to go away. It only
+    // waits when a writer opens the store during the call: while one is open,
+    // searching fails right away with ErrIndexInUse.
+    readIndexTimeout = "100ms"
+)
+
+// errLockBusy is returned by flock when another process holds a conflicting lock.
+var errLockBusy = errors.New("lock is held by another process")
+
+// ErrIndexInUse is returned by the searches of a ReadOnly store while the store
+// is open for writing, whose search index only the writer can use. It wraps
+// ErrIndexUnavailable.
+var ErrIndexInUse = fmt.Errorf("%w: the store is open for writing elsewhere, which holds the index; search through that store or retry once it is closed", ErrIndexUnavailable)
+
+// lockFile opens (creating if needed) and locks the named file of the store.
+func (s *LocalStore) lockFile(name string, exclusive bool) (*os.File, error) {
+    p := filepath.Join(s.path, name)
+    f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE, 0o644)
+    if err != nil {
+        if s.ReadOnly && errors.Is(err, os.ErrPermission) {
+            // A read-only user of a store they cannot write to takes no lock
+            return nil, nil
+        }
+        return nil, err
+    }
+    if err := flock(f, exclusive); err != nil {
+        f.Close()
+        if errors.Is(err, errLockBusy) {
+            return nil, fmt.Errorf("%w: %s: %w", ErrStoreLocked, p, err)
+        }
+        return nil, err
+    }
+    return f, nil
+}
+
+// lockStore takes the locks of an open store: the shared store lock and,
+// unless ReadOnly, the exclusive write lock.
+func (s *LocalStore) lockStore() error {
+    f, err := s.lockFile(storeLockFile, false)
+    if err != nil {
+        return err
+    }
+    s.storeLock = f
+    if s.ReadOnly {
+        return nil
+    }
+    f, err = s.lockFile(writeLockFile, true)
+    if err != nil {
+        return err
+    }
+    s.writeLock = f
+    return nil
+}
+
+// unlockStore releases the locks taken by lockStore.
+func (s *LocalStore) unlockStore() {
+    for _, f := range []*os.File{s.writeLock, s.storeLock} {
+        if f != nil {
+            funlock(f)
+            f.Close()
+        }
+    }
+    s.storeLock, s.writeLock = nil, nil
+}
+
+// lockExclusive turns the shared store lock into an exclusive one, failing with
+// ErrStoreLocked while other stores are open on the same path. unlockExclusive
+// turns it back.
+func (s *LocalStore) lockExclusive() error {
+    if s.storeLock == nil {
+        return nil
+    }
+    if err := flock(s.storeLock, true); err != nil {
+        // A failed conversion may have dropped the shared lock
+        s.unlockExclusive()
+        if errors.Is(err, errLockBusy) {
+            return fmt.Errorf("%w: the store is open in another process", ErrStoreLocked)
+        }
+        return err
+    }
+    return nil
+}
+
+func (s *LocalStore) unlockExclusive() {
+    if s.storeLock != nil {
+        if err := flock(s.storeLock, false); err != nil {
+            s.logf("Warning: failed to restore shared store lock: %v", err)
+        }
+    }
+}
+
+// openIndex opens the search index at path for writing, or for reading only.
+func openIndex(path string, readOnly bool) (bleve.Index, error) {
+    config := map[string]interface{}{"bolt_timeout": writeIndexTimeout}
+    if readOnly {
+        config = map[string]interface{}{"read_only": true, "bolt_timeout": readIndexTimeout}
+    }
+    index, err := bleve.OpenUsing(path, config)
+    if errors.Is(err, bolt.ErrTimeout) {
+        return nil, fmt.Errorf("%w: search index is in use by another process: %w", ErrStoreLocked, err)
+    }
+    return index, err
+}
+
+// useIndex makes s.index available until release is called; a Reindex swap
+// or Close waits for it. A writer keeps its index open; a ReadOnly store opens
+// it for the duration of the call only, so that a writer can still open the
+// store in the meantime.
+func (s *LocalStore) useIndex() (release func(), err error) {
+    s.indexMu.RLock()
+    if !s.ReadOnly {
+        if s.index == nil {
+            s.indexMu.RUnlock()
+            return nil, ErrIndexUnavailable
+        }
+        return s.indexMu.RUnlock, nil
+    }
+
+    s.readersMu.Lock()
+    defer s.readersMu.Unlock()
+    if s.readers == 0 {
+        if s.writerOpen() {
+            s.indexMu.RUnlock()
+            return nil, ErrIndexInUse
+        }
+        index, err := openIndex(s.indexPath, true)
+        if errors.Is(err, ErrStoreLocked) {
+            // A writer opened the store since writerOpen looked
+            s.indexMu.RUnlock()
+            return nil, fmt.Errorf("%w: %w", ErrIndexInUse, err)
+        }
+        if err != nil {
+            s.indexMu.RUnlock()
+            return nil, fmt.Errorf("%w: %w", ErrIndexUnavailable, err)
+        }
+        s.lock.Lock()
+        s.index = index
+        s.lock.Unlock()
+    }
+    s.readers++
+    return func() {
+        defer s.indexMu.RUnlock()
+        s.readersMu.Lock()
+        defer s.readersMu.Unlock()
+        s.readers--
+        if s.readers == 0 {
+            s.lock.Lock()
+            if err := s.closeIndex(); err != nil {
+                s.logf("Warning: failed to close search index: %v", err)
+            }
+            s.lock.Unlock()
+        }
+    }, nil
+}
+
+// writerOpen reports whether a store is open for writing on the same path, by
+// probing its write lock without taking it.
+func (s *LocalStore) writerOpen() bool {
+    f, err := os.Open(filepath.Join(s.path, writeLockFile))
+    if err != nil {
+        return false
+    }
+    defer f.Close()
+    if err := flock(f, false); err != nil {
+        return errors.Is(err, errLockBusy)
+    }
+    funlock(f)
+    return false
+}
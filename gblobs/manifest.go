@@ -0,0 +1,202 @@
+package gblobs
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+// IndexMappingVersion identifies the field layout produced by createIndexMapping.
+// Bump it whenever analyzers, indexed fields or the text extractors change, so
+// that existing stores notice their index is stale on OpenStore.
+const IndexMappingVersion = 7
+
+// manifestFile is the name of the store manifest inside the store directory.
+const manifestFile = "gblobs.json"
+
+// ErrIndexOutdated is returned by OpenStore when the search index was built with
+// an older mapping (or is missing) and MappingPolicy is MappingRefuse. It wraps
+// ErrIndexUnavailable.
+var ErrIndexOutdated = fmt.Errorf("%w: search index is outdated", ErrIndexUnavailable)
+
+// MappingPolicy selects what OpenStore does when the index mapping version
+// recorded in the manifest differs from IndexMappingVersion.
+type MappingPolicy int
+
+const (
+    // MappingRefuse makes OpenStore fail with ErrIndexOutdated (the default).
+    MappingRefuse MappingPolicy = iota
+    // MappingRebuild opens the store and rebuilds the index in the background;
+    // Close cancels a rebuild that has not finished.
+    MappingRebuild
+    // MappingIgnore opens the store with the existing index as is.
+    MappingIgnore
+)
+
+// storeManifest records store-wide settings at <store>/gblobs.json.
+type storeManifest struct {
+    FormatVersion  int       `json:"formatVersion"`
+    MappingVersion int       `json:"mappingVersion"` // 0 means the index is missing blobs
+    CreatedAt      time.Time `json:"createdAt"`
+    Retention      []RetentionRule `json:"retention,omitempty"` // See SetRetentionRules
+    WORM           bool      `json:"worm,omitempty"`      // Write-once store, see LocalStore.WORM
+}
+
+// newManifest returns the manifest for a freshly created store.
+func newManifest() storeManifest {
+    return storeManifest{
+        FormatVersion:  1,
+        MappingVersion: IndexMappingVersion,
+        CreatedAt:      NowUTC(),
+    }
+}
+
+// loadManifest reads the store manifest. Stores created before manifests existed
+// have none; ok is false in that case.
+func loadManifest(storePath string) (m storeManifest, ok bool, err error) {
+    b, err := os.ReadFile(filepath.Join(storePath, manifestFile))
+    if os.IsNotExist(err) {
+        return storeManifest{}, false, nil
+    }
+    if err != nil {
+        return storeManifest{}, false, err
+    }
+    if err := json.Unmarshal(b, &m); err != nil {
+        return storeManifest{}, false, fmt.Errorf("invalid store manifest: %w", err)
+    }
+    return m, true, nil
+}
+
+// saveManifest atomically writes the store manifest.
+func saveManifest(storePath string, m storeManifest) error {
+    b, err := json.MarshalIndent(m, "", "  ")
+    if err != nil {
+        return err
+    }
+    p := filepath.Join(storePath, manifestFile)
+    tmp := p + ".tmp"
+    if err := os.WriteFile(tmp, b, 0o600); err != nil {
+        return err
+    }
+    return os.Rename(tmp, p)
+}
+
+// setMappingVersion records the mapping version of the live index in the manifest.
+func (s *LocalStore) setMappingVersion(v int) error {
+    s.manifest.MappingVersion = v
+    return saveManifest(s.path, s.manifest)
+}
+
+// checkMapping applies MappingPolicy after OpenStore has opened the index.
+// indexCreated tells whether the index had to be created from scratch. It
+// reports whether the index is to be rebuilt; the caller starts that with
+// startRebuild once the indexer runs.
+func (s *LocalStore) checkMapping(ctx context.Context, indexCreated bool) (rebuild bool, err error) {
+    m, ok, err := loadManifest(s.path)
+    if err != nil {
+        return false, err
+    }
+    if !ok {
+        // Pre-manifest store: its index (if any) was built with the first mapping
+        m = newManifest()
+        m.MappingVersion = 1
+    }
+    s.manifest = m
+    if err := s.checkWORM(); err != nil {
+        return false, err
+    }
+    if indexCreated {
+        ids, err := s.listBlobIDs(ctx)
+        if err != nil {
+            return false, err
+        }
+        if len(ids) > 0 {
+            s.manifest.MappingVersion = 0
+        } else {
+            s.manifest.MappingVersion = IndexMappingVersion
+        }
+    }
+    if !ok || indexCreated {
+        if err := saveManifest(s.path, s.manifest); err != nil {
+            return false, err
+        }
+    }
+
+    if s.manifest.MappingVersion == IndexMappingVersion {
+        return false, nil
+    }
+    switch s.MappingPolicy {
+    case MappingRebuild:
+        return true, nil
+    case MappingIgnore:
+        return false, nil
+    default:
+        return false, s.outdatedError()
+    }
+}
+
+// startRebuild runs Reindex in the background until it is done or
+// stopRebuild cancels it.
+func (s *LocalStore) startRebuild() {
+    ctx, cancel := context.WithCancel(context.Background())
+    done := make(chan struct{})
+    s.rebuildCancel, s.rebuildDone = cancel, done
+    go func() {
+        defer close(done)
+        if err := s.Reindex(ctx, nil); err != nil && ctx.Err() == nil {
+            s.logf("Warning: background reindex failed: %v", err)
+        }
+    }()
+}
+
+// stopRebuild cancels the Reindex started by startRebuild and waits for it;
+// the temporary index of a cancelled rebuild is removed.
+func (s *LocalStore) stopRebuild() {
+    if s.rebuildCancel == nil {
+        return
+    }
+    s.rebuildCancel()
+    <-s.rebuildDone
+    s.rebuildCancel, s.rebuildDone = nil, nil
+}
+
+// outdatedError explains why the index is outdated, wrapping ErrIndexOutdated.
+func (s *LocalStore) outdatedError() error {
+    if s.manifest.MappingVersion == 0 {
+        return fmt.Errorf("%w: the index does not cover the stored blobs; run `gblobs reindex` to rebuild it", ErrIndexOutdated)
+    }
+    return fmt.Errorf("%w: index has mapping version %d, this version of gblobs needs %d; run `gblobs reindex` to rebuild it",
+        ErrIndexOutdated, s.manifest.MappingVersion, IndexMappingVersion)
+}
+
+// openReadOnly finishes OpenStore for a ReadOnly store: it checks the mapping
+// version without writing anything, and leaves the index closed until used.
+// A read-only store cannot rebuild its index, so MappingRebuild acts like
+// MappingRefuse.
+func (s *LocalStore) openReadOnly() error {
+    m, ok, err := loadManifest(s.path)
+    if err != nil {
+        return err
+    }
+    if !ok {
+        m = newManifest()
+        m.MappingVersion = 1
+    }
+    if _, err := os.Stat(s.indexPath); err != nil {
+        m.MappingVersion = 0
+    }
+    s.manifest = m
+    if err := s.checkWORM(); err != nil {
+        return err
+    }
+    if err := s.loadIndexFailures(); err != nil {
+        return err
+    }
+    if m.MappingVersion == IndexMappingVersion || s.MappingPolicy == MappingIgnore {
+        return nil
+    }
+    return s.outdatedError()
+}
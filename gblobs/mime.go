@@ -0,0 +1,223 @@
+package gblobs
+
+import (
+    "archive/zip"
+    "bytes"
+    "io"
+    "mime"
+    "net/http"
+    "path/filepath"
+    "strings"
+
+    "github.com/blevesearch/bleve/v2"
+    "github.com/blevesearch/bleve/v2/search/query"
+)
+
+// magicSignature maps a byte prefix at a fixed offset to a media type.
+type magicSignature struct {
+    offset int
+    prefix string
+    mime   string
+}
+
+// magicSignatures are checked in order before falling back to net/http sniffing.
+var magicSignatures = []magicSignature{
+    {0, "\x89PNG\r\n\x1a\n", "image/png"},
+    {0, "\xff\xd8\xff", "image/jpeg"},
+    {0, "GIF87a", "image/gif"},
+    {0, "GIF89a", "image/gif"},
+    {0, "II*\x00", "image/tiff"},
+    {0, "MM\x00*", "image/tiff"},
+    {0, "\x00\x00\x01\x00", "image/x-icon"},
+    {0, "%PDF-", "application/pdf"},
+    {0, "\x1f\x8b", "application/gzip"},
+    {0, "BZh", "application/x-bzip2"},
+    {0, "\xfd7zXZ\x00", "application/x-xz"},
+    {0, "7z\xbc\xaf\x27\x1c", "application/x-7z-compressed"},
+    {0, "\x28\xb5\x2f\xfd", "application/zstd"},
+    {0, "Rar!\x1a\x07", "application/vnd.rar"},
+    {257, "ustar", "application/x-tar"},
+    {0, "ID3", "audio/mpeg"},
+    {0, "fLaC", "audio/flac"},
+    {0, "OggS", "audio/ogg"},
+    {0, "\x00asm", "application/wasm"},
+    {0, "\x7fELF", "application/x-executable"},
+    {0, "SQLite format 3\x00", "application/vnd.sqlite3"},
+    {0, "{\\rtf", "application/rtf"},
+}
+
+// extensionTypes covers common extensions so detection does not depend on the
+// host's mime.types; anything else goes through mime.TypeByExtension.
+var extensionTypes = map[string]string{
+    ".txt": "text/plain", ".log": "text/plain", ".md": "text/markdown",
+    ".csv": "text/csv", ".tsv": "text/tab-separated-values",
+    ".html": "text/html", ".htm": "text/html", ".css": "text/css",
+    ".js": "text/javascript", ".json": "application/json", ".xml": "application/xml",
+    ".yml": "application/yaml", ".yaml": "application/yaml", ".toml": "application/toml",
+    ".go": "text/x-go", ".py": "text/x-python", ".java": "text/x-java",
+    ".c": "text/x-c", ".h": "text/x-c", ".cpp": "text/x-c++", ".sh": "application/x-sh",
+    ".sql": "application/sql", ".svg": "image/svg+xml", ".eml": "message/rfc822",
+    ".ini": "text/plain", ".conf": "text/plain", ".cfg": "text/plain",
+    ".webp": "image/webp", ".mp4": "video/mp4", ".mp3": "audio/mpeg", ".wav": "audio/wav",
+    ".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+    ".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+    ".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+    ".odt": "application/vnd.oasis.opendocument.text",
+    ".ods": "application/vnd.oasis.opendocument.spreadsheet",
+    ".odp": "application/vnd.oasis.opendocument.presentation",
+}
+
+// DetectMIMEType guesses the media type of a blob. Binary formats are recognized
+// by their magic bytes; for text and unrecognized data the extension of name
+// decides when it is known. The result has no parameters (no "; charset=...")
+// and is never empty: unknown binary data is "application/octet-stream".
+func DetectMIMEType(data []byte, name string) string {
+    if mt := sniffMagic(data); mt != "" {
+        return mt
+    }
+    sniffed, _, err := mime.ParseMediaType(http.DetectContentType(data))
+    if err != nil {
+        sniffed = "application/octet-stream"
+    }
+
+    // Structured binary formats that net/http recognizes reliably
+    if sniffed != "application/octet-stream" && !strings.HasPrefix(sniffed, "text/") {
+        return sniffed
+    }
+    if mt := typeByExtension(name); mt != "" {
+        return mt
+    }
+    if sniffed == "application/octet-stream" {
+        if _, ok := decodeText(data); ok {
+            sniffed = "text/plain"
+        }
+    }
+    if sniffed == "text/plain" && looksLikeEmail(data) {
+        return "message/rfc822"
+    }
+    return sniffed
+}
+
+// normalizeMIMEType reduces a caller-supplied media type to the form
+// DetectMIMEType returns, e.g. "Text/Plain; charset=utf-8" to "text/plain", so
+// that MIME filters and retention rules match it.
+func normalizeMIMEType(mt string) string {
+    // ParseMediaType returns the type even when only a parameter is malformed
+    if parsed, _, _ := mime.ParseMediaType(mt); parsed != "" {
+        return parsed
+    }
+    return strings.ToLower(strings.TrimSpace(mt))
+}
+
+// typeByExtension returns the media type for the extension of name, or "".
+func typeByExtension(name string) string {
+    ext := strings.ToLower(filepath.Ext(name))
+    if ext == "" {
+        return ""
+    }
+    if mt, ok := extensionTypes[ext]; ok {
+        return mt
+    }
+    if mt, _, err := mime.ParseMediaType(mime.TypeByExtension(ext)); err == nil {
+        return mt
+    }
+    return ""
+}
+
+// sniffMagic matches magicSignatures and the container formats that need a closer look.
+func sniffMagic(data []byte) string {
+    for _, sig := range magicSignatures {
+        if len(data) >= sig.offset+len(sig.prefix) && string(data[sig.offset:sig.offset+len(sig.prefix)]) == sig.prefix {
+            return sig.mime
+        }
+    }
+    switch {
+    case len(data) >= 12 && string(data[:4]) == "RIFF":
+        switch string(data[8:12]) {
+        case "WEBP":
+            return "image/webp"
+        case "WAVE":
+            return "audio/wav"
+        case "AVI ":
+            return "video/x-msvideo"
+        }
+    case len(data) >= 12 && string(data[4:8]) == "ftyp":
+        switch string(data[8:12]) {
+        case "heic", "heix", "mif1":
+            return "image/heic"
+        case "qt  ":
+            return "video/quicktime"
+        case "M4A ":
+            return "audio/mp4"
+        }
+        return "video/mp4"
+    case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+        return sniffZip(data)
+    }
+    return ""
+}
+
+// sniffZip tells OOXML, OpenDocument, EPUB and Java archives apart from plain zip files.
+func sniffZip(data []byte) string {
+    zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+    if err != nil {
+        return "application/zip"
+    }
+    for _, f := range zr.File {
+        switch {
+        case f.Name == "mimetype":
+            // OpenDocument and EPUB store their type as the first, uncompressed entry
+            if rc, err := f.Open(); err == nil {
+                b, _ := io.ReadAll(io.LimitReader(rc, 128))
+                rc.Close()
+                if mt := strings.TrimSpace(string(b)); mt != "" {
+                    return mt
+                }
+            }
+        case strings.HasPrefix(f.Name, "word/"):
+            return extensionTypes[".docx"]
+        case strings.HasPrefix(f.Name, "xl/"):
+            return extensionTypes[".xlsx"]
+        case strings.HasPrefix(f.Name, "ppt/"):
+            return extensionTypes[".pptx"]
+        case f.Name == "META-INF/MANIFEST.MF":
+            return "application/java-archive"
+        }
+    }
+    return "application/zip"
+}
+
+// looksLikeEmail reports whether data starts with RFC 5322 headers typical of a message.
+func looksLikeEmail(data []byte) bool {
+    head := data
+    if len(head) > 1024 {
+        head = head[:1024]
+    }
+    end := bytes.Index(head, []byte("\n\n"))
+    if end < 0 {
+        end = bytes.Index(head, []byte("\r\n\r\n"))
+    }
+    if end < 0 {
+        return false
+    }
+    hits := 0
+    for _, h := range []string{"from:", "to:", "subject:", "date:", "received:", "message-id:", "mime-version:"} {
+        if bytes.Contains(bytes.ToLower(head[:end]), []byte("\n"+h)) || bytes.HasPrefix(bytes.ToLower(head), []byte(h)) {
+            hits++
+        }
+    }
+    return hits >= 2
+}
+
+// mimeTypeQuery matches the mimeType field exactly, or as a wildcard pattern such as "image/*".
+func mimeTypeQuery(pattern string) query.Query {
+    pattern = strings.ToLower(pattern)
+    if strings.ContainsAny(pattern, "*?") {
+        q := bleve.NewWildcardQuery(pattern)
+        q.SetField("mimeType")
+        return q
+    }
+    q := bleve.NewTermQuery(pattern)
+    q.SetField("mimeType")
+    return q
+}
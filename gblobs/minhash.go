@@ -0,0 +1,161 @@
+package gblobs
+
+import (
+    "encoding/binary"
+    "fmt"
+    "hash/fnv"
+    "strings"
+    "unicode"
+)
+
+const (
+    // minHashSize is the number of hash functions, and values, in a fingerprint.
+    minHashSize = 64
+    // lshBands × lshRows = minHashSize. Blobs share an LSH bucket when one band
+    // of their fingerprints is identical, which is likely from about 50% similarity on.
+    lshBands = 16
+    lshRows  = 4
+    // shingleWords is the number of consecutive words hashed together for text.
+    shingleWords = 3
+
+    // Content-defined chunking of binary data: chunk boundaries follow the bytes,
+    // not offsets, so an edited header does not shift every later chunk.
+    chunkMin  = 64
+    chunkMax  = 4096
+    chunkBits = 9 // about 512 byte chunks on average
+)
+
+var (
+    minHashSeeds [minHashSize]uint64
+    gearTable    [256]uint64
+)
+
+func init() {
+    x := uint64(0x9e3779b97f4a7c15)
+    for i := range minHashSeeds {
+        x = splitmix64(x)
+        minHashSeeds[i] = x
+    }
+    for i := range gearTable {
+        x = splitmix64(x)
+        gearTable[i] = x
+    }
+}
+
+// splitmix64 is a fast, well-mixing 64-bit hash step.
+func splitmix64(x uint64) uint64 {
+    x += 0x9e3779b97f4a7c15
+    x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+    x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+    return x ^ (x >> 31)
+}
+
+// computeFingerprint returns the MinHash signature of a blob: of its word
+// shingles when it has text (body, as extracted for the index), else of its
+// content-defined chunks. It is nil for empty blobs.
+func computeFingerprint(data []byte, body string) []uint32 {
+    var features []uint64
+    if strings.TrimSpace(body) != "" {
+        features = textFeatures(body)
+    } else {
+        features = binaryFeatures(data)
+    }
+    if len(features) == 0 {
+        return nil
+    }
+    sig := make([]uint32, minHashSize)
+    for i, seed := range minHashSeeds {
+        lo := ^uint64(0)
+        for _, f := range features {
+            if h := splitmix64(f ^ seed); h < lo {
+                lo = h
+            }
+        }
+        sig[i] = uint32(lo >> 32)
+    }
+    return sig
+}
+
+// textFeatures hashes overlapping runs of shingleWords lowercased words, so
+// whitespace, punctuation and case do not matter.
+func textFeatures(text string) []uint64 {
+    words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+    })
+    n := shingleWords
+    if len(words) < n {
+        n = len(words)
+    }
+    seen := map[uint64]struct{}{}
+    features := make([]uint64, 0, len(words))
+    for i := 0; i+n <= len(words) && n > 0; i++ {
+        h := fnv.New64a()
+        for _, w := range words[i : i+n] {
+            h.Write([]byte(w))
+            h.Write([]byte{0})
+        }
+        f := h.Sum64()
+        if _, dup := seen[f]; !dup {
+            seen[f] = struct{}{}
+            features = append(features, f)
+        }
+    }
+    return features
+}
+
+// binaryFeatures splits data at content-defined boundaries (a gear rolling
+// hash) and hashes each chunk.
+func binaryFeatures(data []byte) []uint64 {
+    var features []uint64
+    var h uint64
+    start := 0
+    for i, b := range data {
+        h = (h << 1) + gearTable[b]
+        size := i - start + 1
+        if size >= chunkMin && (h>>(64-chunkBits) == 0 || size >= chunkMax) {
+            features = append(features, hashBytes(data[start:i+1]))
+            start = i + 1
+        }
+    }
+    if start < len(data) {
+        features = append(features, hashBytes(data[start:]))
+    }
+    return features
+}
+
+func hashBytes(b []byte) uint64 {
+    h := fnv.New64a()
+    h.Write(b)
+    return h.Sum64()
+}
+
+// lshKeys returns the LSH bucket keys of a fingerprint, one per band.
+func lshKeys(sig []uint32) []string {
+    if len(sig) != minHashSize {
+        return nil
+    }
+    keys := make([]string, lshBands)
+    buf := make([]byte, 4*lshRows)
+    for b := 0; b < lshBands; b++ {
+        for r := 0; r < lshRows; r++ {
+            binary.LittleEndian.PutUint32(buf[4*r:], sig[b*lshRows+r])
+        }
+        keys[b] = fmt.Sprintf("%02d%016x", b, hashBytes(buf))
+    }
+    return keys
+}
+
+// fingerprintSimilarity estimates the Jaccard similarity of two blobs as the
+// share of equal MinHash values.
+func fingerprintSimilarity(a, b []uint32) float64 {
+    if len(a) != minHashSize || len(b) != minHashSize {
+        return 0
+    }
+    same := 0
+    for i := range a {
+        if a[i] == b[i] {
+            same++
+        }
+    }
+    return float64(same) / minHashSize
+}
@@ -0,0 +1,204 @@
+package gblobs
+
+import (
+    "context"
+    "fmt"
+    "sort"
+
+    "github.com/blevesearch/bleve/v2"
+)
+
+// NearDuplicate is one blob of a NearDuplicateCluster.
+type NearDuplicate struct {
+    BlobID     string
+    Metadata   BlobType
+    Similarity float64 // Estimated similarity to the first blob of the cluster
+}
+
+// NearDuplicateCluster groups blobs whose content is nearly identical.
+type NearDuplicateCluster struct {
+    Blobs     []NearDuplicate // Largest blob first
+    TotalSize int64           // Combined length of all blobs in the cluster
+}
+
+// NearDuplicates reports clusters of blobs whose estimated similarity is at
+// least threshold (0 < threshold <= 1), largest clusters by TotalSize first.
+// Candidates come from the LSH buckets kept in the search index; each pair is
+// then checked by comparing MinHash fingerprints. Text blobs are compared by
+// their words, so whitespace and small edits such as timestamps barely matter;
+// binary blobs by content-defined chunks, so a changed header does.
+func (s *LocalStore) NearDuplicates(threshold float64) ([]NearDuplicateCluster, error) {
+    return s.NearDuplicatesContext(context.Background(), threshold)
+}
+
+// NearDuplicatesContext is NearDuplicates with a context that can cancel the
+// bucket scan and the pairwise comparison.
+func (s *LocalStore) NearDuplicatesContext(ctx context.Context, threshold float64) ([]NearDuplicateCluster, error) {
+    if threshold <= 0 || threshold > 1 {
+        return nil, fmt.Errorf("threshold must be in (0, 1], got %v", threshold)
+    }
+    if err := s.checkOpen(); err != nil {
+        return nil, err
+    }
+    release, err := s.useIndex()
+    if err != nil {
+        return nil, err
+    }
+    defer release()
+    if err := s.flushQueue(ctx); err != nil {
+        return nil, err
+    }
+
+    buckets, err := s.lshBuckets(ctx)
+    if err != nil {
+        return nil, err
+    }
+
+    fingerprints := map[string][]uint32{}
+    metas := map[string]BlobType{}
+    rules, now := s.RetentionRules(), NowUTC()
+    fingerprint := func(blobID string) []uint32 {
+        if fp, ok := fingerprints[blobID]; ok {
+            return fp
+        }
+        s.lock.RLock()
+        meta, err := s.readMeta(blobID)
+        s.lock.RUnlock()
+        // Expired blobs and version histories get no fingerprint, so they join no cluster
+        var fp []uint32
+        if at := expiresAt(meta, rules); err == nil && !isVersionHistory(meta) && (at.IsZero() || at.After(now) || s.lockReason(meta, now) != "") {
+            metas[blobID] = meta
+            fp = meta.Fingerprint
+            if fp == nil {
+                // Stored before fingerprints were recorded
+                if data, _, err := s.GetBlobContext(ctx, blobID); err == nil {
+                    fp = computeFingerprint(data, s.extractBodyText(data, meta))
+                }
+            }
+        }
+        fingerprints[blobID] = fp
+        return fp
+    }
+
+    // Union-find over blobs that pass the similarity check
+    parent := map[string]string{}
+    var find func(string) string
+    find = func(id string) string {
+        p, ok := parent[id]
+        if !ok || p == id {
+            parent[id] = id
+            return id
+        }
+        root := find(p)
+        parent[id] = root
+        return root
+    }
+    checked := map[[2]string]bool{}
+    for _, members := range buckets {
+        if err := ctx.Err(); err != nil {
+            return nil, err
+        }
+        for i := 0; i < len(members); i++ {
+            for j := i + 1; j < len(members); j++ {
+                a, b := members[i], members[j]
+                if a > b {
+                    a, b = b, a
+                }
+                if checked[[2]string{a, b}] {
+                    continue
+                }
+                checked[[2]string{a, b}] = true
+                if find(a) == find(b) {
+                    continue
+                }
+                if fingerprintSimilarity(fingerprint(a), fingerprint(b)) >= threshold {
+                    parent[find(a)] = find(b)
+                }
+            }
+        }
+    }
+
+    groups := map[string][]string{}
+    for id := range parent {
+        root := find(id)
+        groups[root] = append(groups[root], id)
+    }
+    clusters := make([]NearDuplicateCluster, 0, len(groups))
+    for _, ids := range groups {
+        if len(ids) < 2 {
+            continue
+        }
+        sort.Slice(ids, func(i, j int) bool {
+            li, lj := metas[ids[i]].Length, metas[ids[j]].Length
+            if li != lj {
+                return li > lj
+            }
+            return ids[i] < ids[j]
+        })
+        var c NearDuplicateCluster
+        for _, id := range ids {
+            c.Blobs = append(c.Blobs, NearDuplicate{
+                BlobID:     id,
+                Metadata:   metas[id],
+                Similarity: fingerprintSimilarity(fingerprint(ids[0]), fingerprint(id)),
+            })
+            c.TotalSize += metas[id].Length
+        }
+        clusters = append(clusters, c)
+    }
+    sort.Slice(clusters, func(i, j int) bool {
+        if clusters[i].TotalSize != clusters[j].TotalSize {
+            return clusters[i].TotalSize > clusters[j].TotalSize
+        }
+        return clusters[i].Blobs[0].BlobID < clusters[j].Blobs[0].BlobID
+    })
+    return clusters, nil
+}
+
+// lshBuckets returns the blob IDs of every LSH bucket that holds more than one blob.
+func (s *LocalStore) lshBuckets(ctx context.Context) ([][]string, error) {
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+
+    dict, err := s.index.FieldDict("lsh")
+    if err != nil {
+        return nil, err
+    }
+    type bucket struct {
+        key   string
+        count int
+    }
+    var shared []bucket
+    for {
+        entry, err := dict.Next()
+        if err != nil {
+            dict.Close()
+            return nil, err
+        }
+        if entry == nil {
+            break
+        }
+        if entry.Count > 1 {
+            shared = append(shared, bucket{entry.Term, int(entry.Count)})
+        }
+    }
+    if err := dict.Close(); err != nil {
+        return nil, err
+    }
+
+    buckets := make([][]string, 0, len(shared))
+    for _, b := range shared {
+        q := bleve.NewTermQuery(b.key)
+        q.SetField("lsh")
+        res, err := s.index.SearchInContext(ctx, bleve.NewSearchRequestOptions(q, b.count, 0, false))
+        if err != nil {
+            return nil, fmt.Errorf("search failed: %w", err)
+        }
+        ids := make([]string, 0, len(res.Hits))
+        for _, hit := range res.Hits {
+            ids = append(ids, hit.ID)
+        }
+        buckets = append(buckets, ids)
+    }
+    return buckets, nil
+}
@@ -0,0 +1,384 @@
+package gblobs
+
+import (
+    "context"
+    "fmt"
+    "io/fs"
+    "maps"
+    "os"
+    "path"
+    "path/filepath"
+    "slices"
+    "strings"
+    "time"
+)
+
+// putDirChunk bounds how many files, and how many bytes, PutDir holds in
+// memory and hands to PutBlobs at once.
+const (
+    putDirChunkFiles = 256
+    putDirChunkBytes = 64 << 20
+)
+
+// PutDirOptions controls which files PutDir ingests.
+type PutDirOptions struct {
+    // Include and Exclude are path.Match globs. A glob with a slash is matched
+    // against the path relative to the root (e.g. "docs/*.md"), any other glob
+    // against the base name (e.g. "*.go"). A file is ingested if it matches an
+    // Include glob (or Include is empty) and no Exclude glob; directories that
+    // match an Exclude glob are not entered.
+    Include []string
+    Exclude []string
+    FollowSymlinks bool // Ingest the targets of symlinks to files; symlinks to directories are always skipped
+    SpecialFiles   bool // Read devices, named pipes and sockets like regular files
+    DryRun         bool // Only report what would be stored
+    // Snapshot also stores the directory structure as trees (see Tree), with
+    // modes and modification times; symlinks that are not followed are kept as
+    // symlinks. The root's tree ID is in PutDirResult.TreeID.
+    Snapshot bool
+    Meta           BlobType // Template for the metadata of every file; Name and URI are set per file
+}
+
+// PutDirFile is the outcome for one file found by PutDir.
+type PutDirFile struct {
+    Path         string // Relative to the root, with forward slashes
+    BlobID       string
+    Size         int64
+    Mode         fs.FileMode // Of the file, or of a followed symlink's target
+    ModTime      time.Time
+    Deduplicated bool  // The content was stored already (or earlier in the same run)
+    Err          error // Why the file could not be read or stored
+}
+
+// PutDirResult summarizes a PutDir run.
+type PutDirResult struct {
+    Files        []PutDirFile // In walk order
+    New          int          // Files whose content was new to the store
+    Deduplicated int          // Files whose content was stored already
+    Failed       int
+    Skipped      int   // Symlinks, special files and files excluded by the globs
+    NewBytes     int64 // Size of the new content
+    SavedBytes   int64 // Size of the deduplicated files, which took no extra space
+    TreeID       string // Snapshot of the root directory, with Snapshot
+}
+
+// PutDir ingests every regular file under root, see PutDirContext.
+func (s *LocalStore) PutDir(root string, opts PutDirOptions) (PutDirResult, error) {
+    return s.PutDirContext(context.Background(), root, opts)
+}
+
+// PutDirContext walks root recursively and ingests the files selected by opts
+// with PutBlobs, a chunk of files at a time. Each blob is named by its path
+// relative to root and gets a file:// URI with its absolute path. Files that
+// cannot be read or stored are reported in the result and do not stop the walk.
+// The store's own directory is skipped if it lies under root.
+func (s *LocalStore) PutDirContext(ctx context.Context, root string, opts PutDirOptions) (PutDirResult, error) {
+    var res PutDirResult
+    if err := s.checkOpen(); err != nil {
+        return res, err
+    }
+    if !opts.DryRun {
+        if err := s.checkWritable(); err != nil {
+            return res, err
+        }
+    }
+    if err := checkGlobs(opts.Include, opts.Exclude); err != nil {
+        return res, err
+    }
+    absRoot, err := filepath.Abs(root)
+    if err != nil {
+        return res, err
+    }
+    storePath, _ := filepath.Abs(s.path)
+
+    var chunk []int // Indexes into res.Files of files read but not yet stored
+    var chunkBytes int64
+    var data [][]byte
+    seen := map[string]bool{} // Blob IDs a dry run would have stored
+    var snap *snapshotDirs
+    if opts.Snapshot {
+        snap = &snapshotDirs{dirs: map[string]TreeEntry{}, links: map[string]TreeEntry{}}
+    }
+    flush := func() error {
+        if len(chunk) == 0 {
+            return nil
+        }
+        err := s.putDirChunk(ctx, absRoot, opts, &res, chunk, data, seen)
+        clear(data) // Let the file contents go
+        chunk, data, chunkBytes = chunk[:0], data[:0], 0
+        return err
+    }
+
+    err = filepath.WalkDir(absRoot, func(p string, d fs.DirEntry, err error) error {
+        if ctxErr := ctx.Err(); ctxErr != nil {
+            return ctxErr
+        }
+        rel, relErr := filepath.Rel(absRoot, p)
+        if relErr != nil {
+            return relErr
+        }
+        rel = filepath.ToSlash(rel)
+        if err != nil {
+            if p == absRoot {
+                return err
+            }
+            // An unreadable directory or file: report it and carry on
+            res.Files = append(res.Files, PutDirFile{Path: rel, Err: err})
+            res.Failed++
+            return nil
+        }
+        if d.IsDir() {
+            if p != absRoot && (p == storePath || matchAny(opts.Exclude, rel)) {
+                return filepath.SkipDir
+            }
+            if snap != nil {
+                fi, err := d.Info()
+                if err != nil {
+                    // Gone since it was listed; the snapshot would miss it
+                    res.Files = append(res.Files, PutDirFile{Path: rel, Err: err})
+                    res.Failed++
+                    return nil
+                }
+                snap.dirs[rel] = TreeEntry{Mode: fi.Mode() & (fs.ModeDir | treeModeBits), ModTime: fi.ModTime()}
+            }
+            return nil
+        }
+        if !opts.selects(rel) {
+            res.Skipped++
+            return nil
+        }
+        fi, err := d.Info()
+        if err != nil {
+            res.Files = append(res.Files, PutDirFile{Path: rel, Err: err})
+            res.Failed++
+            return nil
+        }
+        if fi.Mode()&fs.ModeSymlink != 0 {
+            target, err := os.Stat(p)
+            if !opts.FollowSymlinks || err != nil || target.IsDir() {
+                if snap == nil {
+                    res.Skipped++
+                    return nil
+                }
+                // A snapshot keeps the link itself
+                dest, err := os.Readlink(p)
+                if err != nil {
+                    res.Files = append(res.Files, PutDirFile{Path: rel, Err: err})
+                    res.Failed++
+                    return nil
+                }
+                snap.links[rel] = TreeEntry{Mode: fs.ModeSymlink | fi.Mode().Perm(), ModTime: fi.ModTime(), Target: dest}
+                return nil
+            }
+            fi = target
+        }
+        if !fi.Mode().IsRegular() && !opts.SpecialFiles {
+            res.Skipped++
+            return nil
+        }
+        res.Files = append(res.Files, PutDirFile{Path: rel, Mode: fi.Mode(), ModTime: fi.ModTime()})
+        chunk = append(chunk, len(res.Files)-1)
+        data = append(data, nil)
+        chunkBytes += fi.Size()
+        if len(chunk) >= putDirChunkFiles || chunkBytes >= putDirChunkBytes {
+            return flush()
+        }
+        return nil
+    })
+    if err == nil {
+        err = flush()
+    }
+    if err == nil && snap != nil {
+        res.TreeID, err = s.putSnapshot(ctx, absRoot, opts, &res, snap)
+    }
+    return res, err
+}
+
+// snapshotDirs collects what PutDir needs for a snapshot besides the files:
+// directories and symlinks, by path relative to the root.
+type snapshotDirs struct {
+    dirs  map[string]TreeEntry
+    links map[string]TreeEntry
+}
+
+// putSnapshot stores one tree per directory walked by PutDir, deepest first,
+// and returns the ID of the root's tree. A dry run only computes the IDs.
+func (s *LocalStore) putSnapshot(ctx context.Context, absRoot string, opts PutDirOptions, res *PutDirResult, snap *snapshotDirs) (string, error) {
+    if res.Failed > 0 {
+        for _, f := range res.Files {
+            if f.Err != nil {
+                return "", fmt.Errorf("no snapshot, %d files failed: %s: %w", res.Failed, f.Path, f.Err)
+            }
+        }
+    }
+    trees := map[string]*Tree{}
+    for rel := range snap.dirs {
+        trees[rel] = &Tree{}
+    }
+    add := func(rel string, e TreeEntry) error {
+        e.Name = path.Base(rel)
+        t := trees[path.Dir(rel)]
+        if t == nil {
+            return fmt.Errorf("no snapshot: directory of %s was not walked", rel)
+        }
+        t.Entries = append(t.Entries, e)
+        return nil
+    }
+    for _, f := range res.Files {
+        // Special files read like regular files are restored as regular files
+        if err := add(f.Path, TreeEntry{Mode: f.Mode & treeModeBits, ModTime: f.ModTime, Size: f.Size, BlobID: f.BlobID}); err != nil {
+            return "", err
+        }
+    }
+    for rel, e := range snap.links {
+        if err := add(rel, e); err != nil {
+            return "", err
+        }
+    }
+
+    dirs := slices.Collect(maps.Keys(snap.dirs))
+    depth := func(rel string) int {
+        if rel == "." {
+            return -1
+        }
+        return strings.Count(rel, "/")
+    }
+    // Subtrees before their parents, so the root comes last
+    slices.SortFunc(dirs, func(a, b string) int { return depth(b) - depth(a) })
+    var items []PutItem
+    var treeID string
+    for _, rel := range dirs {
+        data, err := encodeTree(*trees[rel])
+        if err != nil {
+            return "", err
+        }
+        treeID = GenerateBlobID(data)
+        meta := opts.Meta
+        meta.Name = rel + "/"
+        meta.URI = "file://" + filepath.ToSlash(filepath.Join(absRoot, filepath.FromSlash(rel)))
+        meta.MIMEType = TreeMIMEType
+        if meta.IngestionTime.IsZero() {
+            meta.IngestionTime = NowUTC()
+        }
+        items = append(items, PutItem{Data: data, Meta: meta})
+        if rel != "." {
+            e := snap.dirs[rel]
+            e.TreeID = treeID
+            if err := add(rel, e); err != nil {
+                return "", err
+            }
+        }
+    }
+    if opts.DryRun {
+        return treeID, nil
+    }
+    results, err := s.PutBlobsContext(ctx, items)
+    if err != nil {
+        return "", err
+    }
+    for _, r := range results {
+        if r.Err != nil {
+            return "", r.Err
+        }
+    }
+    return treeID, nil
+}
+
+// putDirChunk reads the files of one chunk in parallel and stores them in one
+// batch, or checks which ones are stored already on a dry run.
+func (s *LocalStore) putDirChunk(ctx context.Context, absRoot string, opts PutDirOptions, res *PutDirResult, chunk []int, data [][]byte, seen map[string]bool) error {
+    files := make([]*PutDirFile, len(chunk))
+    for i, n := range chunk {
+        files[i] = &res.Files[n]
+    }
+    s.forEach(ctx, len(files), func(i int) {
+        f := files[i]
+        data[i], f.Err = readFileContext(ctx, filepath.Join(absRoot, filepath.FromSlash(f.Path)))
+        f.Size = int64(len(data[i]))
+        if f.Err == nil {
+            f.BlobID = GenerateBlobID(data[i])
+        }
+    }, func(i int, err error) {
+        files[i].Err = err
+    })
+
+    var items []PutItem
+    var stored []*PutDirFile
+    for i, f := range files {
+        if f.Err != nil {
+            continue
+        }
+        if opts.DryRun {
+            exists, err := s.ExistsBlobContext(ctx, f.BlobID)
+            if err != nil {
+                f.Err = err
+                continue
+            }
+            f.Deduplicated = exists || seen[f.BlobID]
+            seen[f.BlobID] = true
+            continue
+        }
+        meta := opts.Meta
+        meta.Name = f.Path
+        meta.URI = "file://" + filepath.ToSlash(filepath.Join(absRoot, filepath.FromSlash(f.Path)))
+        if meta.IngestionTime.IsZero() {
+            meta.IngestionTime = NowUTC()
+        }
+        items = append(items, PutItem{Data: data[i], Meta: meta})
+        stored = append(stored, f)
+    }
+    if len(items) > 0 {
+        results, err := s.PutBlobsContext(ctx, items)
+        if results == nil {
+            return err
+        }
+        for i, r := range results {
+            stored[i].Deduplicated, stored[i].Err = r.Deduplicated, r.Err
+        }
+    }
+    for _, f := range files {
+        switch {
+        case f.Err != nil:
+            res.Failed++
+        case f.Deduplicated:
+            res.Deduplicated++
+            res.SavedBytes += f.Size
+        default:
+            res.New++
+            res.NewBytes += f.Size
+        }
+    }
+    return ctx.Err()
+}
+
+// selects reports whether the file at rel passes the Include and Exclude globs.
+func (o PutDirOptions) selects(rel string) bool {
+    if matchAny(o.Exclude, rel) {
+        return false
+    }
+    return len(o.Include) == 0 || matchAny(o.Include, rel)
+}
+
+// matchAny reports whether rel matches one of globs (see PutDirOptions).
+func matchAny(globs []string, rel string) bool {
+    for _, g := range globs {
+        name := path.Base(rel)
+        if strings.Contains(g, "/") {
+            name = rel
+        }
+        if ok, _ := path.Match(g, name); ok {
+            return true
+        }
+    }
+    return false
+}
+
+// checkGlobs returns an error for the first malformed glob.
+func checkGlobs(globs ...[]string) error {
+    for _, g := range slices.Concat(globs...) {
+        if _, err := path.Match(g, ""); err != nil {
+            return fmt.Errorf("invalid glob %q: %w", g, err)
+        }
+    }
+    return nil
+}
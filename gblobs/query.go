@@ -0,0 +1,85 @@
+package gblobs
+
+import (
+    "fmt"
+    "strings"
+
+    "github.com/blevesearch/bleve/v2"
+    "github.com/blevesearch/bleve/v2/search/query"
+)
+
+// buildSearchQuery turns a SearchRequest into a Bleve query: the text query for
+// Mode, widened to the stemmed field of Language and narrowed to MIMEType.
+func buildSearchQuery(req SearchRequest) (query.Query, error) {
+    q, err := modeQuery(req.Mode, req.Query, req.Field, req.Fuzziness)
+    if err != nil {
+        return nil, err
+    }
+    if req.Language != "" {
+        lang := normalizeLanguage(req.Language)
+        if lang == "" {
+            return nil, fmt.Errorf("unsupported search language %q (supported: %s)",
+                req.Language, strings.Join(supportedLanguages, ", "))
+        }
+        // Also match the stemmed per-language field, e.g. "häuser" finds "Haus";
+        // only analyzed modes make sense there
+        switch req.Mode {
+        case SearchQueryString, SearchMatch, SearchFuzzy:
+            lq := bleve.NewMatchQuery(req.Query)
+            lq.SetField("lang." + lang)
+            q = bleve.NewDisjunctionQuery(q, lq)
+        case SearchPhrase:
+            lq := bleve.NewMatchPhraseQuery(req.Query)
+            lq.SetField("lang." + lang)
+            q = bleve.NewDisjunctionQuery(q, lq)
+        }
+    }
+    if req.MIMEType != "" {
+        q = bleve.NewConjunctionQuery(q, mimeTypeQuery(req.MIMEType))
+    }
+    return q, nil
+}
+
+// modeQuery builds the query for one SearchMode. Term-level modes (prefix,
+// wildcard, regexp) see indexed terms, which text fields store lowercased.
+func modeQuery(mode SearchMode, text, field string, fuzziness int) (query.Query, error) {
+    switch mode {
+    case SearchQueryString:
+        if field != "" {
+            return nil, fmt.Errorf("field %q cannot be used with query-string search; write %s:<term> in the query instead", field, field)
+        }
+        return bleve.NewQueryStringQuery(text), nil
+    case SearchMatch:
+        q := bleve.NewMatchQuery(text)
+        q.SetField(field)
+        return q, nil
+    case SearchPhrase:
+        q := bleve.NewMatchPhraseQuery(text)
+        q.SetField(field)
+        return q, nil
+    case SearchFuzzy:
+        if fuzziness == 0 {
+            fuzziness = 1
+        }
+        if fuzziness < 1 || fuzziness > 2 {
+            return nil, fmt.Errorf("fuzziness must be 1 or 2, got %d", fuzziness)
+        }
+        q := bleve.NewMatchQuery(text)
+        q.SetFuzziness(fuzziness)
+        q.SetField(field)
+        return q, nil
+    case SearchPrefix:
+        q := bleve.NewPrefixQuery(strings.ToLower(text))
+        q.SetField(field)
+        return q, nil
+    case SearchWildcard:
+        q := bleve.NewWildcardQuery(strings.ToLower(text))
+        q.SetField(field)
+        return q, nil
+    case SearchRegexp:
+        q := bleve.NewRegexpQuery(text)
+        q.SetField(field)
+        return q, nil
+    }
+    return nil, fmt.Errorf("unknown search mode %q (use match, phrase, fuzzy, prefix, wildcard or regexp)", mode)
+}
@@ -0,0 +1,402 @@
+package gblobs
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/fs"
+    "net/url"
+    "os"
+    "path/filepath"
+    "regexp"
+    "slices"
+    "strings"
+    "time"
+)
+
+// Refs live in <store>/refs/<name>, one blob ID per file, and their history in
+// <store>/logs/refs/<escaped name>, one JSON RefLogEntry per line.
+const (
+    refsDir    = "refs"
+    refLogsDir = "logs"
+)
+
+// Ref is a name pointing at a blob or tree ID.
+type Ref struct {
+    Name string
+    ID   string
+}
+
+// RefLogEntry is one change of a ref. Old is empty when the ref was created
+// and New when it was deleted.
+type RefLogEntry struct {
+    Old  string    `json:"old"`
+    New  string    `json:"new"`
+    Time time.Time `json:"time"`
+}
+
+// refComponent is one slash-separated part of a ref name.
+var refComponent = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]*$`)
+
+// ValidateRefName checks that name can be used as a ref: slash-separated parts
+// of letters, digits, '.', '_' and '-', not starting with '.' or '-'. Names
+// that look like blob IDs, or end in the store's own ".blob", ".meta" or ".tmp", are
+// not allowed. A leading "refs/" is not part of the name.
+func ValidateRefName(name string) error {
+    name = strings.TrimPrefix(name, refsDir+"/")
+    switch filepath.Ext(name) {
+    case ".blob", ".meta", ".tmp":
+        return fmt.Errorf("%w: %q", ErrInvalidRef, name)
+    }
+    if len(name) > 255 || ValidateBlobID(strings.ToLower(name)) == nil {
+        return fmt.Errorf("%w: %q", ErrInvalidRef, name)
+    }
+    for _, part := range strings.Split(name, "/") {
+        if !refComponent.MatchString(part) {
+            return fmt.Errorf("%w: %q", ErrInvalidRef, name)
+        }
+    }
+    return nil
+}
+
+// refName validates name and strips a leading "refs/".
+func refName(name string) (string, error) {
+    if err := ValidateRefName(name); err != nil {
+        return "", err
+    }
+    return strings.TrimPrefix(name, refsDir+"/"), nil
+}
+
+func (s *LocalStore) refPath(name string) string {
+    return filepath.Join(s.path, refsDir, filepath.FromSlash(name))
+}
+
+// refLogPath escapes the slashes in name, so that the logs of "release" and
+// "release/1.2" can both exist after one of them was deleted.
+func (s *LocalStore) refLogPath(name string) string {
+    return filepath.Join(s.path, refLogsDir, refsDir, url.PathEscape(name))
+}
+
+// resolveID returns id if it is a blob ID, or else the ID the ref named id
+// points at.
+func (s *LocalStore) resolveID(ctx context.Context, id string) (string, error) {
+    if ValidateBlobID(id) == nil {
+        return id, nil
+    }
+    if err := ctx.Err(); err != nil {
+        return "", err
+    }
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+    return s.resolveIDLocked(id)
+}
+
+// resolveIDLocked is resolveID for callers that hold s.lock.
+func (s *LocalStore) resolveIDLocked(id string) (string, error) {
+    if ValidateBlobID(id) == nil {
+        return id, nil
+    }
+    name, err := refName(id)
+    if err != nil {
+        // Report it as the blob ID it most likely was meant to be
+        return "", ValidateBlobID(id)
+    }
+    blobID, err := s.readRef(name)
+    if errors.Is(err, ErrNotFound) {
+        // Most likely a mistyped blob ID, or a ref that is gone; it is both
+        return "", fmt.Errorf("%w: %q, and %w", ErrInvalidID, id, err)
+    }
+    return blobID, err
+}
+
+// GetRef returns the ID ref name points at, see GetRefContext.
+func (s *LocalStore) GetRef(name string) (string, error) {
+    return s.GetRefContext(context.Background(), name)
+}
+
+// GetRefContext returns the ID ref name points at, or ErrNotFound.
+func (s *LocalStore) GetRefContext(ctx context.Context, name string) (string, error) {
+    if err := s.checkOpen(); err != nil {
+        return "", err
+    }
+    name, err := refName(name)
+    if err != nil {
+        return "", err
+    }
+    if err := ctx.Err(); err != nil {
+        return "", err
+    }
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+    return s.readRef(name)
+}
+
+func (s *LocalStore) readRef(name string) (string, error) {
+    p := s.refPath(name)
+    data, err := os.ReadFile(p)
+    if err != nil {
+        // Also not a ref: a directory of refs, or a path under another ref
+        fi, statErr := os.Stat(p)
+        if errors.Is(err, fs.ErrNotExist) || (statErr == nil && fi.IsDir()) || s.refParent(name) != "" {
+            return "", fmt.Errorf("%w: ref %s", ErrNotFound, name)
+        }
+        return "", err
+    }
+    id := strings.TrimSpace(string(data))
+    if err := ValidateBlobID(id); err != nil {
+        return "", fmt.Errorf("%w: ref %s: %w", ErrCorrupt, name, err)
+    }
+    return id, nil
+}
+
+// refParent returns the ref that name would lie under, if there is one.
+func (s *LocalStore) refParent(name string) string {
+    parts := strings.Split(name, "/")
+    for i := 1; i < len(parts); i++ {
+        parent := strings.Join(parts[:i], "/")
+        if fi, err := os.Stat(s.refPath(parent)); err == nil && !fi.IsDir() {
+            return parent
+        }
+    }
+    return ""
+}
+
+// SetRef points ref name at id, creating it if needed, see SetRefContext.
+func (s *LocalStore) SetRef(name, id string) error {
+    return s.SetRefContext(context.Background(), name, id)
+}
+
+// SetRefContext points ref name at id whatever it pointed at before. id must
+// be a stored blob.
+func (s *LocalStore) SetRefContext(ctx context.Context, name, id string) error {
+    return s.updateRef(ctx, name, nil, id)
+}
+
+// UpdateRef changes ref name from oldID to newID, see UpdateRefContext.
+func (s *LocalStore) UpdateRef(name, oldID, newID string) error {
+    return s.UpdateRefContext(context.Background(), name, oldID, newID)
+}
+
+// UpdateRefContext atomically changes ref name from oldID to newID: it fails
+// with ErrRefConflict, changing nothing, unless the ref currently points at
+// oldID. An empty oldID means the ref must not exist yet, an empty newID
+// deletes it. newID must be a stored blob.
+func (s *LocalStore) UpdateRefContext(ctx context.Context, name, oldID, newID string) error {
+    return s.updateRef(ctx, name, &oldID, newID)
+}
+
+// DeleteRef removes ref name, see DeleteRefContext.
+func (s *LocalStore) DeleteRef(name string) error {
+    return s.DeleteRefContext(context.Background(), name)
+}
+
+// DeleteRefContext removes ref name; its history is kept. Deleting a ref that
+// does not exist fails with ErrNotFound.
+func (s *LocalStore) DeleteRefContext(ctx context.Context, name string) error {
+    return s.updateRef(ctx, name, nil, "")
+}
+
+// updateRef does the work of SetRef, UpdateRef and DeleteRef; a nil oldID
+// skips the compare.
+func (s *LocalStore) updateRef(ctx context.Context, name string, oldID *string, newID string) error {
+    if err := s.checkOpen(); err != nil {
+        return err
+    }
+    if err := s.checkWritable(); err != nil {
+        return err
+    }
+    name, err := refName(name)
+    if err != nil {
+        return err
+    }
+    if newID != "" {
+        if err := ValidateBlobID(newID); err != nil {
+            return err
+        }
+        if ok, err := s.ExistsBlobContext(ctx, newID); err != nil {
+            return err
+        } else if !ok {
+            return blobError(ErrNotFound, newID, nil)
+        }
+    }
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+    s.refsMu.Lock()
+    defer s.refsMu.Unlock()
+    cur, err := s.readRef(name)
+    if errors.Is(err, ErrNotFound) {
+        cur, err = "", nil
+    }
+    if err != nil {
+        return err
+    }
+    switch {
+    case oldID != nil && *oldID != cur:
+        return fmt.Errorf("%w: ref %s points at %q, not %q", ErrRefConflict, name, cur, *oldID)
+    case oldID == nil && newID == "" && cur == "":
+        return fmt.Errorf("%w: ref %s", ErrNotFound, name)
+    case cur == newID:
+        return nil
+    }
+
+    p := s.refPath(name)
+    if newID == "" {
+        if err := os.Remove(p); err != nil {
+            return err
+        }
+        removeEmptyDirs(filepath.Dir(p), filepath.Join(s.path, refsDir))
+    } else {
+        if err := s.checkRefPath(name); err != nil {
+            return err
+        }
+        if err := EnsureDir(filepath.Dir(p)); err != nil {
+            return err
+        }
+        if err := writeFileAtomic(ctx, p, []byte(newID+"\n"), 0o644); err != nil {
+            return err
+        }
+    }
+    return s.appendRefLog(name, RefLogEntry{Old: cur, New: newID, Time: NowUTC()})
+}
+
+// checkRefPath fails with ErrRefConflict if name is a directory of other refs
+// ("release" while "release/1.2" exists) or lies under another ref.
+func (s *LocalStore) checkRefPath(name string) error {
+    if fi, err := os.Stat(s.refPath(name)); err == nil && fi.IsDir() {
+        return fmt.Errorf("%w: ref %s: refs exist under %s/", ErrRefConflict, name, name)
+    }
+    if parent := s.refParent(name); parent != "" {
+        return fmt.Errorf("%w: ref %s: ref %s exists", ErrRefConflict, name, parent)
+    }
+    return nil
+}
+
+// removeEmptyDirs removes dir and its parents up to, but not including, top
+// as long as they are empty.
+func removeEmptyDirs(dir, top string) {
+    for dir != top && strings.HasPrefix(dir, top) {
+        if os.Remove(dir) != nil {
+            return
+        }
+        dir = filepath.Dir(dir)
+    }
+}
+
+func (s *LocalStore) appendRefLog(name string, e RefLogEntry) error {
+    p := s.refLogPath(name)
+    if err := EnsureDir(filepath.Dir(p)); err != nil {
+        return err
+    }
+    line, err := json.Marshal(e)
+    if err != nil {
+        return err
+    }
+    f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+    if err != nil {
+        return err
+    }
+    if _, err := f.Write(append(line, '\n')); err != nil {
+        f.Close()
+        return err
+    }
+    return f.Close()
+}
+
+// ListRefs returns the refs whose names start with prefix, see ListRefsContext.
+func (s *LocalStore) ListRefs(prefix string) ([]Ref, error) {
+    return s.ListRefsContext(context.Background(), prefix)
+}
+
+// ListRefsContext returns the refs whose names start with prefix (all refs if
+// it is empty), sorted by name.
+func (s *LocalStore) ListRefsContext(ctx context.Context, prefix string) ([]Ref, error) {
+    if err := s.checkOpen(); err != nil {
+        return nil, err
+    }
+    prefix = strings.TrimPrefix(prefix, refsDir+"/")
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+    top := filepath.Join(s.path, refsDir)
+    var refs []Ref
+    err := filepath.WalkDir(top, func(p string, d fs.DirEntry, err error) error {
+        if errors.Is(err, fs.ErrNotExist) && p == top {
+            return filepath.SkipDir
+        }
+        if err != nil {
+            return err
+        }
+        if err := ctx.Err(); err != nil {
+            return err
+        }
+        if d.IsDir() {
+            return nil
+        }
+        rel, err := filepath.Rel(top, p)
+        if err != nil {
+            return err
+        }
+        name := filepath.ToSlash(rel)
+        if !strings.HasPrefix(name, prefix) || ValidateRefName(name) != nil {
+            return nil // Not asked for, or a temporary file
+        }
+        id, err := s.readRef(name)
+        if err != nil {
+            return err
+        }
+        refs = append(refs, Ref{Name: name, ID: id})
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    slices.SortFunc(refs, func(a, b Ref) int { return strings.Compare(a.Name, b.Name) })
+    return refs, nil
+}
+
+// RefLog returns the history of ref name, see RefLogContext.
+func (s *LocalStore) RefLog(name string) ([]RefLogEntry, error) {
+    return s.RefLogContext(context.Background(), name)
+}
+
+// RefLogContext returns every change of ref name, oldest first, including
+// changes from before it was last deleted. It fails with ErrNotFound if the
+// ref never existed.
+func (s *LocalStore) RefLogContext(ctx context.Context, name string) ([]RefLogEntry, error) {
+    if err := s.checkOpen(); err != nil {
+        return nil, err
+    }
+    name, err := refName(name)
+    if err != nil {
+        return nil, err
+    }
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+    f, err := os.Open(s.refLogPath(name))
+    if errors.Is(err, fs.ErrNotExist) {
+        return nil, fmt.Errorf("%w: ref %s", ErrNotFound, name)
+    }
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    var log []RefLogEntry
+    sc := bufio.NewScanner(f)
+    for sc.Scan() {
+        if err := ctx.Err(); err != nil {
+            return nil, err
+        }
+        var e RefLogEntry
+        if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+            // A line cut short by a crash; the ref file itself is authoritative
+            continue
+        }
+        log = append(log, e)
+    }
+    return log, sc.Err()
+}
@@ -0,0 +1,202 @@
+package gblobs
+
+import (
+    "context"
+    "fmt"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "github.com/blevesearch/bleve/v2"
+)
+
+// reindexBatchSize is the number of documents committed per Bleve batch while rebuilding.
+const reindexBatchSize = 500
+
+// Reindex rebuilds the search index from the blobs and metadata on disk.
+// The new index is built next to the live one (index.bleve.tmp) in batches and
+// swapped in once complete, so searches keep working while it runs. Blobs put
+// or deleted during the rebuild are replayed into the new index before the swap.
+// Blobs that cannot be indexed are recorded in the failed list (see IndexHealth).
+// progress, if non-nil, is called after every batch and once at the end.
+func (s *LocalStore) Reindex(ctx context.Context, progress func(ReindexProgress)) error {
+    if err := s.checkOpen(); err != nil {
+        return err
+    }
+    if err := s.checkWritable(); err != nil {
+        return err
+    }
+
+    // Collect changes before listing, so a blob put after the walk passed its
+    // directory is replayed rather than lost
+    s.lock.Lock()
+    s.reindexLog = map[string]struct{}{}
+    s.lock.Unlock()
+    swapped := false
+    defer func() {
+        if swapped {
+            return
+        }
+        s.lock.Lock()
+        s.reindexLog = nil
+        s.lock.Unlock()
+    }()
+
+    ids, err := s.listBlobIDs(ctx)
+    if err != nil {
+        return err
+    }
+
+    tmpPath := s.indexPath + ".tmp"
+    if err := os.RemoveAll(tmpPath); err != nil {
+        return err
+    }
+    newIndex, err := bleve.New(tmpPath, s.createIndexMapping())
+    if err != nil {
+        return fmt.Errorf("failed to create search index: %w", err)
+    }
+    defer func() {
+        if swapped {
+            return
+        }
+        newIndex.Close()
+        os.RemoveAll(tmpPath)
+    }()
+
+    p := ReindexProgress{Total: len(ids)}
+    report := func() {
+        if progress != nil {
+            progress(p)
+        }
+    }
+
+    failed := map[string]error{}
+    batch := newIndex.NewBatch()
+    for _, blobID := range ids {
+        if err := ctx.Err(); err != nil {
+            return err
+        }
+        data, meta, err := s.GetBlobContext(ctx, blobID)
+        if err != nil {
+            failed[blobID] = err
+            p.Failed++
+            continue
+        }
+        if err := batch.Index(blobID, s.createIndexDocument(blobID, data, meta)); err != nil {
+            failed[blobID] = err
+            p.Failed++
+            continue
+        }
+        if batch.Size() >= reindexBatchSize {
+            if err := newIndex.Batch(batch); err != nil {
+                return fmt.Errorf("failed to write index batch: %w", err)
+            }
+            p.Indexed += batch.Size()
+            batch.Reset()
+            report()
+        }
+    }
+    if batch.Size() > 0 {
+        if err := newIndex.Batch(batch); err != nil {
+            return fmt.Errorf("failed to write index batch: %w", err)
+        }
+        p.Indexed += batch.Size()
+    }
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+
+    // Record failures once the swap below has released the lock, so OnIndexError may use the store
+    rebuilt := false
+    defer func() {
+        if rebuilt {
+            s.resetIndexFailures(failed)
+        }
+    }()
+
+    // Swap: block writers, replay what changed meanwhile, then move the new index in place.
+    s.indexMu.Lock()
+    defer s.indexMu.Unlock()
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    for blobID := range s.reindexLog {
+        delete(failed, blobID)
+        // Past the point of no return: finish the swap even if ctx is cancelled now
+        data, meta, err := s.readBlob(context.Background(), blobID)
+        if err != nil {
+            newIndex.Delete(blobID)
+            continue
+        }
+        if err := newIndex.Index(blobID, s.createIndexDocument(blobID, data, meta)); err != nil {
+            failed[blobID] = err
+        }
+    }
+    s.reindexLog = nil
+    swapped = true
+
+    if err := newIndex.Close(); err != nil {
+        os.RemoveAll(tmpPath)
+        return fmt.Errorf("failed to close rebuilt index: %w", err)
+    }
+    if err := s.closeIndex(); err != nil {
+        return fmt.Errorf("failed to close search index: %w", err)
+    }
+    oldPath := s.indexPath + ".old"
+    os.RemoveAll(oldPath)
+    if err := os.Rename(s.indexPath, oldPath); err != nil && !os.IsNotExist(err) {
+        return err
+    }
+    if err := os.Rename(tmpPath, s.indexPath); err != nil {
+        return err
+    }
+    os.RemoveAll(oldPath)
+
+    index, err := openIndex(s.indexPath, false)
+    if err != nil {
+        return fmt.Errorf("failed to open search index: %w", err)
+    }
+    s.index = index
+    if err := s.setMappingVersion(IndexMappingVersion); err != nil {
+        return err
+    }
+    // The rebuilt index covers everything except what failed this time
+    rebuilt = true
+    report()
+    return nil
+}
+
+// listBlobIDs walks the store and returns the ids of all blob files, skipping index directories.
+func (s *LocalStore) listBlobIDs(ctx context.Context) ([]string, error) {
+    var ids []string
+    err := filepath.WalkDir(s.path, func(path string, d fs.DirEntry, err error) error {
+        if err != nil {
+            return err
+        }
+        if err := ctx.Err(); err != nil {
+            return err
+        }
+        if d.IsDir() {
+            if strings.HasPrefix(d.Name(), "index.bleve") {
+                return filepath.SkipDir
+            }
+            return nil
+        }
+        if filepath.Ext(d.Name()) != ".blob" {
+            return nil
+        }
+        rel, err := filepath.Rel(s.path, path)
+        if err != nil {
+            return err
+        }
+        // Skip stray files that no blob id maps to
+        if id := BlobPathToID(rel); ValidateBlobID(id) == nil && BlobIDToPath(id) == filepath.ToSlash(rel) {
+            ids = append(ids, id)
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return ids, nil
+}
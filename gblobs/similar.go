@@ -0,0 +1,142 @@
+package gblobs
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "math"
+    "sort"
+
+    "github.com/blevesearch/bleve/v2"
+    "github.com/blevesearch/bleve/v2/search/query"
+)
+
+// similarTerms is how many of a blob's highest TF-IDF terms make up a SimilarTo query.
+const similarTerms = 25
+
+// weightedTerm is an analyzed term of a blob and its TF-IDF weight.
+type weightedTerm struct {
+    term   string
+    weight float64
+}
+
+// SimilarTo finds blobs whose content resembles that of blobID ("more like
+// this"). The blob's text is analyzed like the content field, its terms are
+// weighted by TF-IDF against the index, and the top terms are searched as a
+// boosted disjunction. The blob itself is not among the results; neither are
+// exact duplicates, which share its ID. limit defaults to 10.
+func (s *LocalStore) SimilarTo(blobID string, limit int) ([]SearchResult, error) {
+    return s.SimilarToContext(context.Background(), blobID, limit)
+}
+
+// SimilarToContext is SimilarTo with a context passed on to the index.
+func (s *LocalStore) SimilarToContext(ctx context.Context, blobID string, limit int) ([]SearchResult, error) {
+    if err := s.checkOpen(); err != nil {
+        return nil, err
+    }
+    if err := ValidateBlobID(blobID); err != nil {
+        return nil, err
+    }
+    release, err := s.useIndex()
+    if err != nil {
+        return nil, err
+    }
+    defer release()
+    if limit <= 0 {
+        limit = 10
+    }
+    data, meta, err := s.GetBlobContext(ctx, blobID)
+    if err != nil {
+        return nil, err
+    }
+
+    // Make blobs put so far part of the statistics and results
+    if err := s.flushQueue(ctx); err != nil {
+        return nil, err
+    }
+
+    terms, err := s.topTerms(ctx, s.createIndexDocument(blobID, data, meta).Content, similarTerms)
+    if err != nil {
+        return nil, err
+    }
+    if len(terms) == 0 {
+        return []SearchResult{}, nil
+    }
+
+    should := make([]query.Query, 0, len(terms))
+    for _, t := range terms {
+        tq := bleve.NewTermQuery(t.term)
+        tq.SetField("content")
+        tq.SetBoost(t.weight)
+        should = append(should, tq)
+    }
+    q := bleve.NewBooleanQuery()
+    q.AddMust(bleve.NewDisjunctionQuery(should...))
+    q.AddMustNot(bleve.NewDocIDQuery([]string{blobID}))
+    q.AddMustNot(s.expiredQuery(NowUTC()), mimeTypeQuery(VersionsMIMEType))
+
+    searchResult, err := s.index.SearchInContext(ctx, bleve.NewSearchRequestOptions(q, limit, 0, false))
+    if err != nil {
+        return nil, fmt.Errorf("search failed: %w", err)
+    }
+    return s.searchResults(ctx, searchResult)
+}
+
+// topTerms analyzes text with the content field's analyzer and returns up to n
+// terms with the highest TF-IDF. Terms that occur in one document only (this
+// one, typically) cannot match anything else and are skipped.
+func (s *LocalStore) topTerms(ctx context.Context, text string, n int) ([]weightedTerm, error) {
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+
+    m := s.index.Mapping()
+    analyzer := m.AnalyzerNamed(m.AnalyzerNameForPath("content"))
+    if analyzer == nil {
+        return nil, errors.New("no analyzer for content field")
+    }
+    tf := map[string]int{}
+    for _, tok := range analyzer.Analyze([]byte(text)) {
+        if len(tok.Term) > 1 {
+            tf[string(tok.Term)]++
+        }
+    }
+
+    idx, err := s.index.Advanced()
+    if err != nil {
+        return nil, err
+    }
+    reader, err := idx.Reader()
+    if err != nil {
+        return nil, err
+    }
+    defer reader.Close()
+    docs, err := reader.DocCount()
+    if err != nil {
+        return nil, err
+    }
+
+    terms := make([]weightedTerm, 0, len(tf))
+    for term, freq := range tf {
+        tfr, err := reader.TermFieldReader(ctx, []byte(term), "content", false, false, false)
+        if err != nil {
+            return nil, err
+        }
+        df := tfr.Count()
+        tfr.Close()
+        if df <= 1 {
+            continue
+        }
+        idf := math.Log(1 + float64(docs)/float64(df))
+        terms = append(terms, weightedTerm{term: term, weight: (1 + math.Log(float64(freq))) * idf})
+    }
+    sort.Slice(terms, func(i, j int) bool {
+        if terms[i].weight != terms[j].weight {
+            return terms[i].weight > terms[j].weight
+        }
+        return terms[i].term < terms[j].term
+    })
+    if len(terms) > n {
+        terms = terms[:n]
+    }
+    return terms, nil
+}
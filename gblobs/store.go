@@ -1,6 +1,7 @@
 package gblobs
 
 import (
+    "context"
     "errors"
     "os"
     "sync"
@@ -16,11 +17,63 @@ import (
 
 // LocalStore provides blob storage with full-text search capabilities
 type LocalStore struct {
+    // MappingPolicy decides what OpenStore does with an outdated search index
+    MappingPolicy MappingPolicy
+    // ReadOnly opens the store for reading only; it can be open while another
+    // process writes to the store, and writes fail with ErrReadOnly
+    ReadOnly bool
+    // BatchWorkers is the number of goroutines PutBlobs, GetBlobs and DeleteBlobs
+    // use; 0 means GOMAXPROCS
+    BatchWorkers int
+    // Logger receives warnings such as failed background index commits; nil logs to stderr
+    Logger Logger
+    // OnIndexError, if set, is called from the indexing goroutine for every blob
+    // whose index update failed
+    OnIndexError func(blobID string, err error)
+    // ExpireInterval, if positive, makes a writable store run Expire in the
+    // background this often while it is open
+    ExpireInterval time.Duration
+    // WORM makes CreateStore create a write-once store: no blob can be deleted,
+    // the store cannot be purged, and stored metadata cannot be changed.
+    // OpenStore sets it to whether the opened store is write-once.
+    WORM bool
+
     path      string
     key       []byte
     lock      sync.RWMutex
     index     bleve.Index  // Bleve full-text search index
+    indexMu   sync.RWMutex // Held for reading while s.index is in use, see useIndex; taken before s.lock
     indexPath string       // Path to the search index
+    manifest  storeManifest // Store-wide settings from gblobs.json
+    queue     *indexQueue   // Batches index updates in the background
+
+    failedMu sync.Mutex
+    failed   map[string]IndexFailure // Blobs whose last index update failed, from index.failed
+
+    // blobLocks serialize puts, gets and deletes of the same blob; s.lock is
+    // only held for reading by them
+    blobLocks blobLocks
+
+    // reindexLog collects blob IDs touched while Reindex builds a new
+    // index, so those changes can be replayed before the swap.
+    reindexMu  sync.Mutex
+    reindexLog map[string]struct{}
+
+    storeLock *os.File // Shared lock on gblobs.lock, held while open
+    writeLock *os.File // Exclusive lock on gblobs.write.lock, unless ReadOnly
+
+    readersMu sync.Mutex
+    readers   int // Calls using the index of a ReadOnly store, see useIndex
+
+    refsMu sync.Mutex // Serializes ref updates, see UpdateRef
+
+    auditMu sync.Mutex // Serializes appends to the audit log
+
+    sweeperStop chan struct{} // Closed to stop the sweeper, see startSweeper
+    sweeperDone chan struct{}
+
+    rebuildCancel context.CancelFunc // Cancels the background Reindex of MappingRebuild, see startRebuild
+    rebuildDone   chan struct{}
 }
 
 
@@ -29,6 +82,14 @@ type LocalStore struct {
 // - MaxCountPerLevel: per-level max file/directory count
 // - AverageCountPerLevel: average per level
 func (s *LocalStore) Stats() (StoreStats, error) {
+    return s.StatsContext(context.Background())
+}
+
+// StatsContext is Stats with a context that can cancel the walk.
+func (s *LocalStore) StatsContext(ctx context.Context) (StoreStats, error) {
+    if err := s.checkOpen(); err != nil {
+        return StoreStats{}, err
+    }
     // Traverse recursively to count .blob files and per-directory file counts
     type levelStats struct {
         counts []int
@@ -46,6 +107,9 @@ func (s *LocalStore) Stats() (StoreStats, error) {
         }
         cnt := 0
         for _, fi := range fis {
+            if err := ctx.Err(); err != nil {
+                return err
+            }
             full := filepath.Join(dir, fi.Name())
             if fi.IsDir() {
                 cnt++ // Count directories at this level
@@ -130,9 +194,31 @@ func (s *LocalStore) createIndexMapping() mapping.IndexMapping {
     docMapping.AddFieldMappingsAt("name", textFieldMapping)
     docMapping.AddFieldMappingsAt("uri", keywordFieldMapping)
     docMapping.AddFieldMappingsAt("owner", keywordFieldMapping)
+    docMapping.AddFieldMappingsAt("mimeType", keywordFieldMapping)
     docMapping.AddFieldMappingsAt("blobId", keywordFieldMapping)
     docMapping.AddFieldMappingsAt("ingestionTime", dateFieldMapping)
+    docMapping.AddFieldMappingsAt("expiresAt", dateFieldMapping)
+    docMapping.AddFieldMappingsAt("retainUntil", dateFieldMapping)
+    docMapping.AddFieldMappingsAt("legalHold", bleve.NewBooleanFieldMapping())
     docMapping.AddFieldMappingsAt("length", numericFieldMapping)
+    docMapping.AddFieldMappingsAt("language", keywordFieldMapping)
+
+    // LSH buckets of the MinHash fingerprint, for near-duplicate detection
+    lshFieldMapping := bleve.NewKeywordFieldMapping()
+    lshFieldMapping.Store = false
+    lshFieldMapping.IncludeInAll = false
+    docMapping.AddFieldMappingsAt("lsh", lshFieldMapping)
+
+    // Per-language content fields (lang.de, lang.ru, ...) with stemming analyzers
+    langMapping := bleve.NewDocumentMapping()
+    for _, lang := range supportedLanguages {
+        langFieldMapping := bleve.NewTextFieldMapping()
+        langFieldMapping.Analyzer = lang
+        langFieldMapping.Store = false
+        langFieldMapping.IncludeInAll = false
+        langMapping.AddFieldMappingsAt(lang, langFieldMapping)
+    }
+    docMapping.AddSubDocumentMapping("lang", langMapping)
 
     // Index mapping
     indexMapping := bleve.NewIndexMapping()
@@ -150,6 +236,31 @@ func (s *LocalStore) closeIndex() error {
     return nil
 }
 
+// Close commits pending index work, stops the background indexer and closes
+// the search index, releasing its files. Afterwards every method fails with
+// ErrStoreClosed until the store is created or opened again, possibly at
+// another path. Closing a store that is not open does nothing.
+func (s *LocalStore) Close() error {
+    s.stopRebuild()
+    s.stopSweeper()
+    s.stopIndexer()
+
+    s.indexMu.Lock()
+    defer s.indexMu.Unlock()
+    s.lock.Lock()
+    defer s.lock.Unlock()
+    err := s.closeIndex()
+    s.unlockStore()
+    s.path, s.indexPath, s.key, s.manifest = "", "", nil, storeManifest{}
+    s.failedMu.Lock()
+    s.failed = nil
+    s.failedMu.Unlock()
+    if err != nil {
+        return fmt.Errorf("failed to close search index: %w", err)
+    }
+    return nil
+}
+
 // isTextContent uses heuristics to determine if data contains text
 func (s *LocalStore) isTextContent(data []byte, meta BlobType) bool {
     if len(data) == 0 {
@@ -170,28 +281,20 @@ func (s *LocalStore) isTextContent(data []byte, meta BlobType) bool {
         return true
     }
 
-    // Heuristic: if most bytes are printable ASCII, treat as text
-    textBytes := 0
-    sampleSize := len(data)
-    if sampleSize > 1024 {
-        sampleSize = 1024 // Sample first 1KB
-    }
-
-    for i := 0; i < sampleSize; i++ {
-        b := data[i]
-        if (b >= 32 && b <= 126) || b == 9 || b == 10 || b == 13 {
-            textBytes++
-        }
-    }
-
-    return float64(textBytes)/float64(sampleSize) > 0.8
+    // Heuristic: if the sample decodes as mostly printable characters in some
+    // common encoding (UTF-8, UTF-16, Latin-1), treat as text
+    _, ok := decodeText(data)
+    return ok
 }
 
 // extractTextContent extracts searchable text from blob data
 func (s *LocalStore) extractTextContent(data []byte, meta BlobType) string {
-    var content strings.Builder
+    return s.metadataText(meta) + s.extractBodyText(data, meta)
+}
 
-    // Always include metadata as searchable text
+// metadataText returns the metadata that is always searchable as text
+func (s *LocalStore) metadataText(meta BlobType) string {
+    var content strings.Builder
     if meta.Name != "" {
         content.WriteString(meta.Name)
         content.WriteString(" ")
@@ -204,29 +307,90 @@ func (s *LocalStore) extractTextContent(data []byte, meta BlobType) string {
         content.WriteString(filepath.Base(meta.URI))
         content.WriteString(" ")
     }
+    return content.String()
+}
 
-    // If it's text content, include the actual data
-    if s.isTextContent(data, meta) {
-        content.WriteString(string(data))
+// extractBodyText returns the text of the blob data itself, or "" for binary data
+func (s *LocalStore) extractBodyText(data []byte, meta BlobType) string {
+    // Prefer a registered extractor for the blob's format; if there is none, or it
+    // cannot make sense of the data, include the data itself when it looks like text
+    if x := lookupTextExtractor(data, meta); x != nil {
+        if text, err := x.ExtractText(data, meta); err == nil {
+            return text
+        }
     }
-
-    return content.String()
+    if !s.isTextContent(data, meta) {
+        return ""
+    }
+    if text, ok := decodeText(data); ok {
+        return text
+    }
+    // Text by extension, but no encoding fits: index what is valid
+    return strings.ToValidUTF8(string(data), " ")
 }
 
 // createIndexDocument creates a document for the search index
 func (s *LocalStore) createIndexDocument(blobID string, data []byte, meta BlobType) IndexDocument {
-    content := s.extractTextContent(data, meta)
+    return s.indexDocument(blobID, data, s.extractBodyText(data, meta), meta)
+}
+
+// indexDocument builds the index document from the already extracted body text
+func (s *LocalStore) indexDocument(blobID string, data []byte, body string, meta BlobType) IndexDocument {
+    if isVersionHistory(meta) {
+        // Only counted, so IndexHealth still sees one document per blob
+        return IndexDocument{BlobID: blobID, MIMEType: VersionsMIMEType, IngestionTime: meta.IngestionTime, Length: int64(len(data))}
+    }
+    content := s.metadataText(meta) + body
+
+    // Also index the body under a language-specific field so it gets stemmed
+    lang := normalizeLanguage(meta.Language)
+    if lang == "" {
+        lang = detectLanguage(body)
+    }
+    var localized map[string]string
+    if lang != "" && body != "" {
+        localized = map[string]string{lang: body}
+    }
+
+    // Stores written before preparePut normalized it may hold any spelling
+    mimeType := normalizeMIMEType(meta.MIMEType)
+    if mimeType == "" {
+        mimeType = DetectMIMEType(data, meta.Name)
+    }
+
+    fingerprint := meta.Fingerprint
+    if fingerprint == nil {
+        fingerprint = computeFingerprint(data, body)
+    }
+
     return IndexDocument{
         BlobID:        blobID,
         Name:          meta.Name,
         URI:           meta.URI,
         Owner:         meta.Owner,
+        MIMEType:      mimeType,
         Content:       content,
+        Language:      lang,
+        Localized:     localized,
+        LSH:           lshKeys(fingerprint),
         IngestionTime: meta.IngestionTime,
+        ExpiresAt:     timeField(meta.ExpiresAt),
+        RetainUntil:   timeField(meta.RetainUntil),
+        LegalHold:     meta.LegalHold,
         Length:        int64(len(data)),
     }
 }
 
+// timeField leaves a zero time, such as the ExpiresAt of a blob that does not
+// expire, out of the index.
+func timeField(t time.Time) *time.Time {
+    if t.IsZero() {
+        return nil
+    }
+    t = t.UTC()
+    return &t
+}
+
 // Compile check
 var _ Store = (*LocalStore)(nil)
 
@@ -234,6 +398,26 @@ var _ Store = (*LocalStore)(nil)
 
 // CreateStore sets up directory and records key for new store
 func (s *LocalStore) CreateStore(path string, keyOpt ...string) error {
+    return s.CreateStoreContext(context.Background(), path, keyOpt...)
+}
+
+// CreateStoreContext is CreateStore with a context checked before any work.
+// A store that is already open is closed first.
+func (s *LocalStore) CreateStoreContext(ctx context.Context, path string, keyOpt ...string) error {
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+    if err := s.Close(); err != nil {
+        return err
+    }
+    if err := s.createStore(path, keyOpt...); err != nil {
+        s.Close()
+        return err
+    }
+    return nil
+}
+
+func (s *LocalStore) createStore(path string, keyOpt ...string) error {
     s.path = path
     if len(keyOpt) > 0 {
         s.key = KeyFromPassword(keyOpt[0])
@@ -241,10 +425,17 @@ func (s *LocalStore) CreateStore(path string, keyOpt ...string) error {
         s.key = nil
     }
 
+    if err := s.checkWritable(); err != nil {
+        return err
+    }
+
     // Try to create base directory
     if err := EnsureDir(path); err != nil {
         return err
     }
+    if err := s.lockStore(); err != nil {
+        return err
+    }
 
     // Create Bleve search index
     s.indexPath = filepath.Join(path, "index.bleve")
@@ -255,11 +446,48 @@ func (s *LocalStore) CreateStore(path string, keyOpt ...string) error {
     }
     s.index = index
 
+    s.manifest = newManifest()
+    s.manifest.WORM = s.WORM
+    // Creating the store again over a write-once one keeps it write-once
+    if err := s.checkWORM(); err != nil {
+        return err
+    }
+    if err := saveManifest(path, s.manifest); err != nil {
+        return err
+    }
+    if err := s.loadIndexFailures(); err != nil {
+        return err
+    }
+    if err := s.startIndexer(); err != nil {
+        return err
+    }
+    s.startSweeper()
     return nil
 }
 
 // OpenStore loads existing store, with/without key
 func (s *LocalStore) OpenStore(path string, keyOpt ...string) error {
+    return s.OpenStoreContext(context.Background(), path, keyOpt...)
+}
+
+// OpenStoreContext is OpenStore with a context that can cancel replaying the
+// index queue and checking the index against the blobs on disk. A store that is
+// already open is closed first.
+func (s *LocalStore) OpenStoreContext(ctx context.Context, path string, keyOpt ...string) error {
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+    if err := s.Close(); err != nil {
+        return err
+    }
+    if err := s.openStore(ctx, path, keyOpt...); err != nil {
+        s.Close()
+        return err
+    }
+    return nil
+}
+
+func (s *LocalStore) openStore(ctx context.Context, path string, keyOpt ...string) error {
     s.path = path
     if len(keyOpt) > 0 {
         s.key = KeyFromPassword(keyOpt[0])
@@ -270,17 +498,31 @@ func (s *LocalStore) OpenStore(path string, keyOpt ...string) error {
     // Check base directory exists
     info, err := os.Stat(path)
     if err != nil {
+        if os.IsNotExist(err) {
+            return fmt.Errorf("%w: store %s: %w", ErrNotFound, path, err)
+        }
         return err
     }
     if !info.IsDir() {
         return errors.New("store path exists but is not a directory")
     }
+    if err := s.lockStore(); err != nil {
+        return err
+    }
 
     // Open existing Bleve search index
     s.indexPath = filepath.Join(path, "index.bleve")
+    if s.ReadOnly {
+        return s.openReadOnly()
+    }
+    if _, err := os.Stat(s.indexPath); os.IsNotExist(err) {
+        // A Reindex interrupted mid-swap leaves the previous index behind
+        os.Rename(s.indexPath+".old", s.indexPath)
+    }
+    indexCreated := false
     if _, err := os.Stat(s.indexPath); err == nil {
         // Index exists, open it
-        index, err := bleve.Open(s.indexPath)
+        index, err := openIndex(s.indexPath, false)
         if err != nil {
             return fmt.Errorf("failed to open search index: %w", err)
         }
@@ -293,112 +535,253 @@ func (s *LocalStore) OpenStore(path string, keyOpt ...string) error {
             return fmt.Errorf("failed to create search index: %w", err)
         }
         s.index = index
+        indexCreated = true
     }
 
+    // Apply index updates a previous process queued but never committed
+    if err := s.loadIndexFailures(); err != nil {
+        return err
+    }
+    if err := s.replayIndexQueue(ctx); err != nil {
+        return err
+    }
+
+    // Make sure the index was built with the current mapping
+    rebuild, err := s.checkMapping(ctx, indexCreated)
+    if err != nil {
+        return err
+    }
+    if err := s.startIndexer(); err != nil {
+        return err
+    }
+    if rebuild {
+        s.startRebuild()
+    }
+    s.startSweeper()
     return nil
 }
 
 // PutBlob stores data in compressed (and optionally encrypted) form, avoids duplicates
 func (s *LocalStore) PutBlob(data []byte, meta BlobType) (string, error) {
+    return s.PutBlobContext(context.Background(), data, meta)
+}
+
+// PutBlobContext is PutBlob with a context checked around text extraction and
+// while writing; a cancelled write leaves no partial blob behind.
+func (s *LocalStore) PutBlobContext(ctx context.Context, data []byte, meta BlobType) (string, error) {
+    if err := s.checkOpen(); err != nil {
+        return "", err
+    }
+    if err := s.checkWritable(); err != nil {
+        return "", err
+    }
+    if err := ctx.Err(); err != nil {
+        return "", err
+    }
+    p, err := s.preparePut(ctx, data, meta, EnsureDir)
+    if err != nil {
+        return "", err
+    }
+
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+    bl := s.blobLocks.of(p.blobID)
+    bl.Lock()
+    defer bl.Unlock()
+    if _, err := s.writePrepared(ctx, &p); err != nil {
+        return "", err
+    }
+    if s.queue != nil {
+        if err := s.enqueueIndex(p.blobID, p.doc); err != nil {
+            return "", err
+        }
+    }
+    return p.blobID, nil
+}
+
+// preparedPut is a blob ready to be written by writePrepared.
+type preparedPut struct {
+    blobID   string
+    fullPath string
+    data     []byte
+    meta     BlobType
+    content  []byte // Compressed and encrypted data; nil if the blob was stored already
+    doc      IndexDocument
+}
+
+// preparePut does the CPU-heavy part of a put, which needs no lock: text
+// extraction, fingerprinting, compression and encryption. ensureDir creates
+// the blob's directory.
+func (s *LocalStore) preparePut(ctx context.Context, data []byte, meta BlobType, ensureDir func(string) error) (preparedPut, error) {
     blobID := GenerateBlobID(data)
     relPath := BlobIDToPath(blobID)
     fullPath := filepath.Join(s.path, relPath)
-    dir := filepath.Dir(fullPath)
-    if err := EnsureDir(dir); err != nil {
-        return "", err
+    if err := ensureDir(filepath.Dir(fullPath)); err != nil {
+        return preparedPut{}, err
     }
-    s.lock.Lock()
-    defer s.lock.Unlock()
-
-    // Check if blob already exists (deduplication)
-    alreadyExists := false
-    if _, err := os.Stat(fullPath); err == nil {
-        alreadyExists = true
+    if meta.MIMEType == "" {
+        meta.MIMEType = DetectMIMEType(data, meta.Name)
+    } else {
+        meta.MIMEType = normalizeMIMEType(meta.MIMEType)
     }
+    body := s.extractBodyText(data, meta)
+    if meta.Fingerprint == nil {
+        meta.Fingerprint = computeFingerprint(data, body)
+    }
+    meta.BlobHash = blobID
+    meta.Length = int64(len(data))
 
-    // If blob doesn't exist, create it
-    if !alreadyExists {
-        // Compress, encrypt
-        content := data
+    // Compress and encrypt unless the blob is stored already (deduplication)
+    var content []byte
+    if _, err := os.Stat(fullPath); err == nil {
+        // Blob exists, but we still need to set metadata for indexing
+        if meta.IngestionTime.IsZero() {
+            meta.IngestionTime = time.Now().UTC()
+        }
+    } else {
+        meta.IngestionTime = meta.IngestionTime.UTC()
         var err error
-        content, err = CompressBlob(content)
+        content, err = CompressBlob(data)
         if err != nil {
-            return "", err
+            return preparedPut{}, err
         }
         content, err = EncryptBlob(content, s.key)
         if err != nil {
-            return "", err
-        }
-        // Write to file
-        if err := os.WriteFile(fullPath, content, 0o600); err != nil {
-            return "", err
-        }
-        // Metadata file
-        meta.BlobHash = blobID
-        meta.Length = int64(len(data))
-        metaFile := fullPath + ".meta"
-        meta.IngestionTime = meta.IngestionTime.UTC()
-        mb, err := json.MarshalIndent(meta, "", "  ")
-        if err != nil {
-            return "", err
-        }
-        if err := os.WriteFile(metaFile, mb, 0o600); err != nil {
-            return "", err
-        }
-    } else {
-        // Blob exists, but we still need to set metadata for indexing
-        meta.BlobHash = blobID
-        meta.Length = int64(len(data))
-        if meta.IngestionTime.IsZero() {
-            meta.IngestionTime = time.Now().UTC()
+            return preparedPut{}, err
         }
     }
+    // Always index the content (for new blobs or when metadata changes); the
+    // background indexer commits it in batches
+    doc := s.indexDocument(blobID, data, body, meta)
+    if err := ctx.Err(); err != nil {
+        return preparedPut{}, err
+    }
+    return preparedPut{blobID: blobID, fullPath: fullPath, data: data, meta: meta, content: content, doc: doc}, nil
+}
 
-    // Always index the content (for new blobs or when metadata changes)
-    if s.index != nil {
-        doc := s.createIndexDocument(blobID, data, meta)
-        if err := s.index.Index(blobID, doc); err != nil {
-            // Log error but don't fail the blob storage operation
-            fmt.Printf("Warning: failed to index blob %s: %v\n", blobID, err)
+// writePrepared writes p unless the blob is stored already, and reports whether
+// it was. The caller holds s.lock for reading and the blob's lock.
+func (s *LocalStore) writePrepared(ctx context.Context, p *preparedPut) (deduplicated bool, err error) {
+    // Another put may have stored the blob in the meantime. The metadata goes
+    // first, so a blob file is never without it.
+    if _, err := os.Stat(p.fullPath); err == nil {
+        s.keepProtectedMeta(p)
+        s.noteChange(p.blobID)
+        return true, nil
+    }
+    if p.content == nil {
+        // Deleted since preparePut found it; rare enough to compress under the lock
+        if p.content, err = CompressBlob(p.data); err != nil {
+            return false, err
+        }
+        if p.content, err = EncryptBlob(p.content, s.key); err != nil {
+            return false, err
         }
     }
-
-    return blobID, nil
+    mb, err := json.MarshalIndent(p.meta, "", "  ")
+    if err != nil {
+        return false, err
+    }
+    if err := writeFileAtomic(ctx, p.fullPath+".meta", mb, 0o600); err != nil {
+        return false, err
+    }
+    if err := writeFileAtomic(ctx, p.fullPath, p.content, 0o600); err != nil {
+        os.Remove(p.fullPath + ".meta")
+        return false, err
+    }
+    s.noteChange(p.blobID)
+    return false, nil
 }
 
-// GetBlob loads the blob (decrypt, decompress) and returns with metadata
+// GetBlob loads the blob (decrypt, decompress) and returns with metadata.
+// blobID may also be the name of a ref pointing at the blob.
 func (s *LocalStore) GetBlob(blobID string) ([]byte, BlobType, error) {
-    relPath := BlobIDToPath(blobID)
-    fullPath := filepath.Join(s.path, relPath)
+    return s.GetBlobContext(context.Background(), blobID)
+}
+
+// GetBlobContext is GetBlob with a context checked while reading the blob file.
+func (s *LocalStore) GetBlobContext(ctx context.Context, blobID string) ([]byte, BlobType, error) {
+    if err := s.checkOpen(); err != nil {
+        return nil, BlobType{}, err
+    }
+    blobID, err := s.resolveID(ctx, blobID)
+    if err != nil {
+        return nil, BlobType{}, err
+    }
     s.lock.RLock()
     defer s.lock.RUnlock()
-    encContent, err := os.ReadFile(fullPath)
+    bl := s.blobLocks.of(blobID)
+    bl.RLock()
+    defer bl.RUnlock()
+    return s.readBlob(ctx, blobID)
+}
+
+// readBlob does the work of GetBlob; the caller must hold s.lock and the blob's lock.
+func (s *LocalStore) readBlob(ctx context.Context, blobID string) ([]byte, BlobType, error) {
+    relPath := BlobIDToPath(blobID)
+    fullPath := filepath.Join(s.path, relPath)
+    encContent, err := readFileContext(ctx, fullPath)
     if err != nil {
+        if errors.Is(err, os.ErrNotExist) {
+            return nil, BlobType{}, blobError(ErrNotFound, blobID, err)
+        }
         return nil, BlobType{}, err
     }
     content, err := DecryptBlob(encContent, s.key)
     if err != nil {
-        return nil, BlobType{}, err
+        if errors.Is(err, errShortCiphertext) {
+            return nil, BlobType{}, blobError(ErrCorrupt, blobID, err)
+        }
+        return nil, BlobType{}, blobError(ErrWrongKey, blobID, err)
     }
     plain, err := DecompressBlob(content)
+    if err != nil {
+        return nil, BlobType{}, blobError(ErrCorrupt, blobID, err)
+    }
+    meta, err := s.readMeta(blobID)
     if err != nil {
         return nil, BlobType{}, err
     }
-    // Metadata
-    metaFile := fullPath + ".meta"
+    return plain, meta, nil
+}
+
+// readMeta reads only the metadata of a blob; the caller must hold s.lock.
+func (s *LocalStore) readMeta(blobID string) (BlobType, error) {
+    metaFile := filepath.Join(s.path, BlobIDToPath(blobID)) + ".meta"
     mb, err := os.ReadFile(metaFile)
     if err != nil {
-        return nil, BlobType{}, err
+        if errors.Is(err, os.ErrNotExist) {
+            // The blob may be gone too, else its metadata was lost
+            if _, serr := os.Stat(strings.TrimSuffix(metaFile, ".meta")); errors.Is(serr, os.ErrNotExist) {
+                return BlobType{}, blobError(ErrNotFound, blobID, err)
+            }
+            return BlobType{}, blobError(ErrCorrupt, blobID, err)
+        }
+        return BlobType{}, err
     }
     var meta BlobType
     if err := json.Unmarshal(mb, &meta); err != nil {
-        return nil, BlobType{}, err
+        return BlobType{}, blobError(ErrCorrupt, blobID, err)
     }
-    return plain, meta, nil
+    return meta, nil
 }
 
 // ExistsBlob returns true if the main blob file exists
 func (s *LocalStore) ExistsBlob(blobID string) (bool, error) {
+    return s.ExistsBlobContext(context.Background(), blobID)
+}
+
+// ExistsBlobContext is ExistsBlob with a context checked before the lookup.
+func (s *LocalStore) ExistsBlobContext(ctx context.Context, blobID string) (bool, error) {
+    if err := s.checkOpen(); err != nil {
+        return false, err
+    }
+    if err := ValidateBlobID(blobID); err != nil {
+        return false, err
+    }
+    if err := ctx.Err(); err != nil {
+        return false, err
+    }
     relPath := BlobIDToPath(blobID)
     fullPath := filepath.Join(s.path, relPath)
     _, err := os.Stat(fullPath)
@@ -413,30 +796,96 @@ func (s *LocalStore) ExistsBlob(blobID string) (bool, error) {
 
 // DeleteBlob removes both the blob file and its metadata
 func (s *LocalStore) DeleteBlob(blobID string) error {
-    // Remove from search index first
-    if s.index != nil {
-        if err := s.index.Delete(blobID); err != nil {
-            // Log error but continue with blob deletion
-            fmt.Printf("Warning: failed to remove blob %s from search index: %v\n", blobID, err)
-        }
+    return s.DeleteBlobContext(context.Background(), blobID)
+}
+
+// DeleteBlobContext is DeleteBlob with a context checked before anything is removed.
+func (s *LocalStore) DeleteBlobContext(ctx context.Context, blobID string) error {
+    if err := s.checkOpen(); err != nil {
+        return err
+    }
+    if err := s.checkWritable(); err != nil {
+        return err
+    }
+    if err := ValidateBlobID(blobID); err != nil {
+        return err
+    }
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+    bl := s.blobLocks.of(blobID)
+    bl.Lock()
+    defer bl.Unlock()
+    if err := s.checkDeletable(blobID); err != nil {
+        return err
     }
 
-    // Remove blob and metadata files
-    relPath := BlobIDToPath(blobID)
-    fullPath := filepath.Join(s.path, relPath)
-    metaFile := fullPath + ".meta"
-    // Remove both files; ignore their absence
-    _ = os.Remove(fullPath)
-    _ = os.Remove(metaFile)
+    if err := s.removeBlob(blobID); err != nil {
+        return err
+    }
+    // Only now, so a blob that could not be removed stays searchable
+    if s.queue != nil {
+        return s.enqueueDelete(blobID)
+    }
+    return nil
+}
+
+// removeBlob removes the blob and metadata files, ignoring their absence. The
+// caller holds s.lock for reading and the blob's lock.
+func (s *LocalStore) removeBlob(blobID string) error {
+    s.noteChange(blobID)
+    fullPath := filepath.Join(s.path, BlobIDToPath(blobID))
+    // Blob first, so the metadata of an existing blob is never missing
+    for _, p := range []string{fullPath, fullPath + ".meta"} {
+        if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+            return fmt.Errorf("failed to delete blob %s: %w", blobID, err)
+        }
+    }
     return nil
 }
 
 // PurgeStore removes all blobs and meta files from store (very destructive)
 func (s *LocalStore) PurgeStore() error {
+    return s.PurgeStoreContext(context.Background())
+}
+
+// PurgeStoreContext is PurgeStore with a context checked before anything is
+// removed; once purging has started it runs to completion.
+func (s *LocalStore) PurgeStoreContext(ctx context.Context) error {
+    if err := s.checkOpen(); err != nil {
+        return err
+    }
+    if err := s.checkWritable(); err != nil {
+        return err
+    }
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+    // Nobody else may have the store open while its files disappear
+    if err := s.lockExclusive(); err != nil {
+        return err
+    }
+    defer s.unlockExclusive()
+    if err := s.checkPurgeable(ctx); err != nil {
+        return err
+    }
+    // A rebuild must not swap its index into the purged store
+    s.stopRebuild()
+
+    // Pending index work is moot once everything is gone
+    s.queue.discard()
+    s.stopIndexer()
+
+    // Wait for searches using the index, and keep new ones out until it is replaced
+    s.indexMu.Lock()
+    defer s.indexMu.Unlock()
+
     // Close and remove search index first
     if err := s.closeIndex(); err != nil {
         // Log error but continue with purge
-        fmt.Printf("Warning: failed to close search index: %v\n", err)
+        s.logf("Warning: failed to close search index: %v", err)
     }
 
     // Remove all contents in store path; do not remove the root dir
@@ -445,6 +894,14 @@ func (s *LocalStore) PurgeStore() error {
         return err
     }
     for _, f := range d {
+        if f.Name() == storeLockFile || f.Name() == writeLockFile {
+            // Removing a held lock file would let the next process lock a new one
+            continue
+        }
+        if f.Name() == auditLogFile {
+            // The record of refused deletions outlives the blobs
+            continue
+        }
         err := os.RemoveAll(filepath.Join(s.path, f.Name()))
         if err != nil {
             return err
@@ -460,8 +917,18 @@ func (s *LocalStore) PurgeStore() error {
     }
     s.index = index
 
-    return nil
+    // The manifest was removed with everything else; the new index is current
+    s.manifest.MappingVersion = IndexMappingVersion
+    if err := saveManifest(s.path, s.manifest); err != nil {
+        return err
+    }
+    if err := s.loadIndexFailures(); err != nil {
+        return err
+    }
+
+    return s.startIndexer()
 }
+
 // Path returns the store's root path (for testing/internal use only).
 func (s *LocalStore) Path() string {
     return s.path
@@ -470,6 +937,14 @@ func (s *LocalStore) Path() string {
 // InspectStore traverses all paths and collects metadata of all blobs in the store.
 // Returns a slice of BlobType structs containing metadata for each blob.
 func (s *LocalStore) InspectStore() ([]BlobType, error) {
+    return s.InspectStoreContext(context.Background())
+}
+
+// InspectStoreContext is InspectStore with a context that can cancel the walk.
+func (s *LocalStore) InspectStoreContext(ctx context.Context) ([]BlobType, error) {
+    if err := s.checkOpen(); err != nil {
+        return nil, err
+    }
     var blobs []BlobType
     root := s.path
 
@@ -481,6 +956,9 @@ func (s *LocalStore) InspectStore() ([]BlobType, error) {
         }
 
         for _, fi := range fis {
+            if err := ctx.Err(); err != nil {
+                return err
+            }
             full := filepath.Join(dir, fi.Name())
             if fi.IsDir() {
                 err = visit(full)
@@ -515,19 +993,40 @@ func (s *LocalStore) InspectStore() ([]BlobType, error) {
 
 // Search performs a simple text search across all indexed blobs
 func (s *LocalStore) Search(query string) ([]SearchResult, error) {
+    return s.SearchContext(context.Background(), query)
+}
+
+// SearchContext is Search with a context passed on to the index.
+func (s *LocalStore) SearchContext(ctx context.Context, query string) ([]SearchResult, error) {
     req := SearchRequest{
         Query:     query,
         Limit:     50,
         Offset:    0,
         Highlight: true,
     }
-    return s.SearchWithOptions(req)
+    return s.SearchWithOptionsContext(ctx, req)
 }
 
 // SearchWithOptions performs a search with detailed configuration options
 func (s *LocalStore) SearchWithOptions(req SearchRequest) ([]SearchResult, error) {
-    if s.index == nil {
-        return nil, errors.New("search index not available")
+    return s.SearchWithOptionsContext(context.Background(), req)
+}
+
+// SearchWithOptionsContext is SearchWithOptions with a context passed on to
+// the index, so a slow search can be cancelled.
+func (s *LocalStore) SearchWithOptionsContext(ctx context.Context, req SearchRequest) ([]SearchResult, error) {
+    if err := s.checkOpen(); err != nil {
+        return nil, err
+    }
+    release, err := s.useIndex()
+    if err != nil {
+        return nil, err
+    }
+    defer release()
+
+    // Make blobs put so far searchable
+    if err := s.flushQueue(ctx); err != nil {
+        return nil, err
     }
 
     // Set default values
@@ -539,7 +1038,11 @@ func (s *LocalStore) SearchWithOptions(req SearchRequest) ([]SearchResult, error
     }
 
     // Build Bleve query
-    query := bleve.NewQueryStringQuery(req.Query)
+    query, err := buildSearchQuery(req)
+    if err != nil {
+        return nil, err
+    }
+    query = s.withoutHidden(query, NowUTC())
     searchRequest := bleve.NewSearchRequestOptions(query, req.Limit, req.Offset, false)
 
     // Configure highlighting
@@ -555,18 +1058,27 @@ func (s *LocalStore) SearchWithOptions(req SearchRequest) ([]SearchResult, error
     }
 
     // Execute search
-    searchResult, err := s.index.Search(searchRequest)
+    searchResult, err := s.index.SearchInContext(ctx, searchRequest)
     if err != nil {
         return nil, fmt.Errorf("search failed: %w", err)
     }
 
-    // Convert results
+    return s.searchResults(ctx, searchResult)
+}
+
+// searchResults converts Bleve hits to SearchResults with metadata from the blob store
+func (s *LocalStore) searchResults(ctx context.Context, searchResult *bleve.SearchResult) ([]SearchResult, error) {
     results := make([]SearchResult, 0, len(searchResult.Hits))
     for _, hit := range searchResult.Hits {
         blobID := hit.ID
 
         // Get metadata from blob store
-        _, meta, err := s.GetBlob(blobID)
+        s.lock.RLock()
+        meta, err := s.readMeta(blobID)
+        s.lock.RUnlock()
+        if err := ctx.Err(); err != nil {
+            return nil, err
+        }
         if err != nil {
             // Skip if blob no longer exists or can't be read
             continue
@@ -580,6 +1092,5 @@ func (s *LocalStore) SearchWithOptions(req SearchRequest) ([]SearchResult, error
         }
         results = append(results, result)
     }
-
     return results, nil
 }
@@ -0,0 +1,31 @@
+package gblobs
+
+import (
+    "strconv"
+    "sync"
+)
+
+// blobLockStripes is the number of locks blob IDs are spread over. Blobs whose
+// IDs share a first byte share a lock, which is rare enough not to matter.
+const blobLockStripes = 256
+
+// blobLocks serializes reads and writes of the same blob without serializing
+// unrelated ones. They are taken while holding s.lock for reading; s.lock
+// held for writing excludes every blob operation.
+type blobLocks [blobLockStripes]sync.RWMutex
+
+// of returns the lock for blobID, which must be valid.
+func (l *blobLocks) of(blobID string) *sync.RWMutex {
+    n, _ := strconv.ParseUint(blobID[:2], 16, 8)
+    return &l[n]
+}
+
+// noteChange records blobID for a running Reindex to replay; the caller holds
+// s.lock for reading.
+func (s *LocalStore) noteChange(blobID string) {
+    s.reindexMu.Lock()
+    if s.reindexLog != nil {
+        s.reindexLog[blobID] = struct{}{}
+    }
+    s.reindexMu.Unlock()
+}
@@ -0,0 +1,79 @@
+package gblobs
+
+import (
+    "context"
+    "sort"
+    "strings"
+)
+
+// Suggestion is an indexed term that completes a prefix.
+type Suggestion struct {
+    Term  string // Term as indexed (lowercase for text fields)
+    Count uint64 // Number of documents containing the term
+}
+
+// Suggest returns up to limit terms of field that start with prefix, most
+// frequent first, for autocomplete. field defaults to "name"; text fields such
+// as name and content are matched case-insensitively, keyword fields such as
+// owner exactly. Counts come from the index dictionary and may briefly include
+// deleted blobs until the index merges its segments. limit defaults to 10.
+func (s *LocalStore) Suggest(prefix string, field string, limit int) ([]Suggestion, error) {
+    return s.SuggestContext(context.Background(), prefix, field, limit)
+}
+
+// SuggestContext is Suggest with a context that can cancel the dictionary scan.
+func (s *LocalStore) SuggestContext(ctx context.Context, prefix string, field string, limit int) ([]Suggestion, error) {
+    if err := s.checkOpen(); err != nil {
+        return nil, err
+    }
+    release, err := s.useIndex()
+    if err != nil {
+        return nil, err
+    }
+    defer release()
+    if field == "" {
+        field = "name"
+    }
+    if limit <= 0 {
+        limit = 10
+    }
+    if err := s.flushQueue(ctx); err != nil {
+        return nil, err
+    }
+
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+
+    if s.index.Mapping().AnalyzerNameForPath(field) != "keyword" {
+        prefix = strings.ToLower(prefix)
+    }
+    dict, err := s.index.FieldDictPrefix(field, []byte(prefix))
+    if err != nil {
+        return nil, err
+    }
+    defer dict.Close()
+
+    var suggestions []Suggestion
+    for {
+        if err := ctx.Err(); err != nil {
+            return nil, err
+        }
+        entry, err := dict.Next()
+        if err != nil {
+            return nil, err
+        }
+        if entry == nil {
+            break
+        }
+        if entry.Count > 0 {
+            suggestions = append(suggestions, Suggestion{Term: entry.Term, Count: entry.Count})
+        }
+    }
+    sort.SliceStable(suggestions, func(i, j int) bool {
+        return suggestions[i].Count > suggestions[j].Count
+    })
+    if len(suggestions) > limit {
+        suggestions = suggestions[:limit]
+    }
+    return suggestions, nil
+}
@@ -0,0 +1,149 @@
+package gblobs
+
+import (
+    "bytes"
+    "strings"
+    "unicode"
+    "unicode/utf16"
+    "unicode/utf8"
+)
+
+// textSampleSize is how much of a blob is inspected to decide whether it is text.
+const textSampleSize = 4096
+
+// textThreshold is the share of printable characters a sample needs to count as text.
+const textThreshold = 0.8
+
+var (
+    bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+    bomUTF16LE = []byte{0xFF, 0xFE}
+    bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// decodeText detects the character encoding of data and returns it as UTF-8.
+// It understands UTF-8 (with or without BOM), UTF-16 in either byte order (with
+// a BOM, or ASCII-heavy text without one) and falls back to Latin-1 for 8-bit
+// text. ok is false when data does not look like text in any of these.
+func decodeText(data []byte) (text string, ok bool) {
+    switch {
+    case bytes.HasPrefix(data, bomUTF8):
+        data = data[len(bomUTF8):]
+    case bytes.HasPrefix(data, bomUTF16LE):
+        return decodeUTF16(data[2:], false), true
+    case bytes.HasPrefix(data, bomUTF16BE):
+        return decodeUTF16(data[2:], true), true
+    }
+    if len(data) == 0 {
+        return "", false
+    }
+
+    sample := data
+    if len(sample) > textSampleSize {
+        sample = trimPartialRune(sample[:textSampleSize])
+    }
+    if utf8.Valid(sample) {
+        if printableRatio(string(sample)) > textThreshold {
+            return strings.ToValidUTF8(string(data), "�"), true
+        }
+        if bigEndian, ok := guessUTF16(sample); ok {
+            return decodeUTF16(data, bigEndian), true
+        }
+        return "", false
+    }
+    if bigEndian, ok := guessUTF16(sample); ok {
+        return decodeUTF16(data, bigEndian), true
+    }
+    if latin1Ratio(sample) > textThreshold {
+        return decodeLatin1(data), true
+    }
+    return "", false
+}
+
+// trimPartialRune drops a UTF-8 sequence cut off at the end of b.
+func trimPartialRune(b []byte) []byte {
+    for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+        if utf8.RuneStart(b[i]) {
+            if !utf8.FullRune(b[i:]) {
+                return b[:i]
+            }
+            break
+        }
+    }
+    return b
+}
+
+// printableRatio returns the share of runes in s that are printable or whitespace.
+func printableRatio(s string) float64 {
+    total, printable := 0, 0
+    for _, r := range s {
+        total++
+        if r != utf8.RuneError && (unicode.IsPrint(r) || r == '\t' || r == '\n' || r == '\r') {
+            printable++
+        }
+    }
+    if total == 0 {
+        return 0
+    }
+    return float64(printable) / float64(total)
+}
+
+// latin1Ratio returns the share of bytes that are printable in ISO 8859-1.
+func latin1Ratio(b []byte) float64 {
+    printable := 0
+    for _, c := range b {
+        if (c >= 32 && c <= 126) || c == 9 || c == 10 || c == 13 || c >= 0xA0 {
+            printable++
+        }
+    }
+    return float64(printable) / float64(len(b))
+}
+
+// guessUTF16 recognizes BOM-less UTF-16 by the zero high bytes of ASCII-range
+// characters, which land on every other byte.
+func guessUTF16(b []byte) (bigEndian bool, ok bool) {
+    if len(b) < 4 {
+        return false, false
+    }
+    var even, odd int
+    for i, c := range b[:len(b)&^1] {
+        if c != 0 {
+            continue
+        }
+        if i%2 == 0 {
+            even++
+        } else {
+            odd++
+        }
+    }
+    half := float64(len(b) / 2)
+    switch {
+    case float64(odd) > 0.4*half && float64(even) < 0.05*half:
+        return false, true
+    case float64(even) > 0.4*half && float64(odd) < 0.05*half:
+        return true, true
+    }
+    return false, false
+}
+
+// decodeUTF16 transcodes UTF-16 to UTF-8; a trailing odd byte is ignored.
+func decodeUTF16(b []byte, bigEndian bool) string {
+    u := make([]uint16, len(b)/2)
+    for i := range u {
+        if bigEndian {
+            u[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+        } else {
+            u[i] = uint16(b[2*i+1])<<8 | uint16(b[2*i])
+        }
+    }
+    return string(utf16.Decode(u))
+}
+
+// decodeLatin1 transcodes ISO 8859-1 to UTF-8.
+func decodeLatin1(b []byte) string {
+    var sb strings.Builder
+    sb.Grow(len(b))
+    for _, c := range b {
+        sb.WriteRune(rune(c))
+    }
+    return sb.String()
+}
@@ -0,0 +1,230 @@
+package gblobs
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "slices"
+    "strings"
+    "time"
+)
+
+// TreeMIMEType is the media type of tree objects.
+const TreeMIMEType = "application/vnd.gblobs.tree+json"
+
+// Tree is a directory snapshot: the entries of one directory, with
+// subdirectories referring to trees of their own. Trees are stored as blobs
+// (JSON with entries sorted by name), so a tree ID is a blob ID and equal
+// directories share a tree.
+type Tree struct {
+    Entries []TreeEntry `json:"entries"`
+}
+
+// TreeEntry is a file, directory or symlink in a Tree.
+type TreeEntry struct {
+    Name    string      `json:"name"`             // Base name within the directory
+    Mode    fs.FileMode `json:"mode"`             // Type bits (dir, symlink or none) and permissions
+    ModTime time.Time   `json:"mtime"`
+    Size    int64       `json:"size,omitempty"`   // Length of a file's content
+    BlobID  string      `json:"blob,omitempty"`   // Content of a file
+    TreeID  string      `json:"tree,omitempty"`   // Tree of a directory
+    Target  string      `json:"target,omitempty"` // Target of a symlink
+}
+
+// IsDir reports whether e is a directory.
+func (e TreeEntry) IsDir() bool { return e.Mode.IsDir() }
+
+// IsSymlink reports whether e is a symlink.
+func (e TreeEntry) IsSymlink() bool { return e.Mode&fs.ModeSymlink != 0 }
+
+// treeModeBits are the mode bits a tree records besides the type.
+const treeModeBits = fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky
+
+// encodeTree checks tree and returns its canonical encoding.
+func encodeTree(tree Tree) ([]byte, error) {
+    entries := slices.Clone(tree.Entries)
+    slices.SortFunc(entries, func(a, b TreeEntry) int { return strings.Compare(a.Name, b.Name) })
+    for i, e := range entries {
+        if err := checkTreeEntry(e); err != nil {
+            return nil, err
+        }
+        if i > 0 && entries[i-1].Name == e.Name {
+            return nil, fmt.Errorf("tree entry %q appears twice", e.Name)
+        }
+        entries[i].ModTime = e.ModTime.UTC()
+    }
+    return json.Marshal(Tree{Entries: entries})
+}
+
+// checkTreeEntry rejects entries that cannot be restored safely.
+func checkTreeEntry(e TreeEntry) error {
+    if e.Name == "" || e.Name == "." || e.Name == ".." || strings.ContainsAny(e.Name, `/\`) {
+        return fmt.Errorf("invalid tree entry name %q", e.Name)
+    }
+    switch {
+    case e.IsDir():
+        return ValidateBlobID(e.TreeID)
+    case e.IsSymlink():
+        if e.Target == "" {
+            return fmt.Errorf("tree entry %q: symlink without target", e.Name)
+        }
+    case e.Mode.Type() == 0:
+        return ValidateBlobID(e.BlobID)
+    default:
+        return fmt.Errorf("tree entry %q: unsupported file type %v", e.Name, e.Mode.Type())
+    }
+    return nil
+}
+
+// PutTree stores tree as a tree object and returns its ID, see PutTreeContext.
+func (s *LocalStore) PutTree(tree Tree, meta BlobType) (string, error) {
+    return s.PutTreeContext(context.Background(), tree, meta)
+}
+
+// PutTreeContext stores tree like PutBlob with the TreeMIMEType. The blobs and
+// trees its entries refer to are not checked; store them first.
+func (s *LocalStore) PutTreeContext(ctx context.Context, tree Tree, meta BlobType) (string, error) {
+    data, err := encodeTree(tree)
+    if err != nil {
+        return "", err
+    }
+    meta.MIMEType = TreeMIMEType
+    return s.PutBlobContext(ctx, data, meta)
+}
+
+// GetTree reads a tree object, see GetTreeContext.
+func (s *LocalStore) GetTree(treeID string) (Tree, error) {
+    return s.GetTreeContext(context.Background(), treeID)
+}
+
+// GetTreeContext reads a tree object. It fails with ErrNotTree if the blob is
+// not a tree.
+func (s *LocalStore) GetTreeContext(ctx context.Context, treeID string) (Tree, error) {
+    data, meta, err := s.GetBlobContext(ctx, treeID)
+    if err != nil {
+        return Tree{}, err
+    }
+    if meta.MIMEType != TreeMIMEType {
+        return Tree{}, blobError(ErrNotTree, treeID, nil)
+    }
+    return decodeTree(treeID, data)
+}
+
+func decodeTree(treeID string, data []byte) (Tree, error) {
+    var tree Tree
+    if err := json.Unmarshal(data, &tree); err != nil {
+        return Tree{}, blobError(ErrCorrupt, treeID, err)
+    }
+    for i, e := range tree.Entries {
+        if err := checkTreeEntry(e); err != nil {
+            return Tree{}, blobError(ErrCorrupt, treeID, err)
+        }
+        // encodeTree sorts entries, so duplicates or disorder mean a crafted tree
+        if i > 0 && tree.Entries[i-1].Name >= e.Name {
+            return Tree{}, blobError(ErrCorrupt, treeID, fmt.Errorf("tree entry %q is duplicate or out of order", e.Name))
+        }
+    }
+    return tree, nil
+}
+
+// extractTreeText indexes a tree by the names of its entries.
+func extractTreeText(data []byte, meta BlobType) (string, error) {
+    tree, err := decodeTree(meta.BlobHash, data)
+    if err != nil {
+        return "", err
+    }
+    names := make([]string, len(tree.Entries))
+    for i, e := range tree.Entries {
+        names[i] = e.Name
+    }
+    return strings.Join(names, "\n"), nil
+}
+
+// RestoreTree recreates a snapshot in dest, see RestoreTreeContext.
+func (s *LocalStore) RestoreTree(treeID, dest string) error {
+    return s.RestoreTreeContext(context.Background(), treeID, dest)
+}
+
+// RestoreTreeContext recreates the directory snapshot treeID in dest, which
+// must not exist or be empty: files with their content, permissions and
+// modification times, directories with theirs, and symlinks. dest itself keeps
+// the permissions it is created with. If the restore fails, what has been
+// restored so far is left in place.
+func (s *LocalStore) RestoreTreeContext(ctx context.Context, treeID, dest string) error {
+    if err := s.checkOpen(); err != nil {
+        return err
+    }
+    if err := os.MkdirAll(dest, 0o755); err != nil {
+        return err
+    }
+    entries, err := os.ReadDir(dest)
+    if err != nil {
+        return err
+    }
+    if len(entries) > 0 {
+        return fmt.Errorf("restore into %s: %w: directory is not empty", dest, fs.ErrExist)
+    }
+    root, err := filepath.EvalSymlinks(dest)
+    if err != nil {
+        return err
+    }
+    return s.restoreTree(ctx, treeID, root, root)
+}
+
+// restoreTree restores treeID into dir, which must resolve to a directory
+// inside root.
+func (s *LocalStore) restoreTree(ctx context.Context, treeID, dir, root string) error {
+    tree, err := s.GetTreeContext(ctx, treeID)
+    if err != nil {
+        return err
+    }
+    // Directories are created by the restore itself, but refuse to follow one
+    // that was swapped for a symlink
+    resolved, err := filepath.EvalSymlinks(dir)
+    if err != nil {
+        return err
+    }
+    if rel, err := filepath.Rel(root, resolved); err != nil || !filepath.IsLocal(rel) {
+        return fmt.Errorf("restore %s: resolves to %s, outside %s", dir, resolved, root)
+    }
+    for _, e := range tree.Entries {
+        if err := ctx.Err(); err != nil {
+            return err
+        }
+        p := filepath.Join(dir, e.Name)
+        switch {
+        case e.IsDir():
+            // Writable until its entries are in place
+            if err := os.Mkdir(p, 0o700); err != nil {
+                return err
+            }
+            if err := s.restoreTree(ctx, e.TreeID, p, root); err != nil {
+                return err
+            }
+        case e.IsSymlink():
+            if err := os.Symlink(e.Target, p); err != nil {
+                return err
+            }
+            // The link's own times cannot be set portably
+            continue
+        default:
+            data, _, err := s.GetBlobContext(ctx, e.BlobID)
+            if err != nil {
+                return fmt.Errorf("restore %s: %w", p, err)
+            }
+            if err := createFileContext(ctx, p, data, 0o600); err != nil {
+                return err
+            }
+        }
+        if err := os.Chmod(p, e.Mode&treeModeBits); err != nil {
+            return err
+        }
+        if err := os.Chtimes(p, e.ModTime, e.ModTime); err != nil {
+            return err
+        }
+    }
+    return nil
+}
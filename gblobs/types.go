@@ -1,6 +1,9 @@
 package gblobs
 
-import "time"
+import (
+    "context"
+    "time"
+)
 
 // BlobType metadata for a blob
 type BlobType struct {
@@ -10,6 +13,12 @@ type BlobType struct {
     BlobHash     string    // Hash of content (blob id)
     IngestionTime time.Time // Time of ingestion
     Owner        string    // Optional owner identifier
+    Language     string    // Optional language tag (e.g. "de"); detected from content if empty
+    MIMEType     string    // Media type, e.g. "image/png"; sniffed on PutBlob if empty
+    Fingerprint  []uint32  // MinHash signature for near-duplicate detection; computed on PutBlob
+    ExpiresAt    time.Time // Optional time after which the blob expires (see Expire); zero means never
+    RetainUntil  time.Time // Blob cannot be deleted before this time (see SetRetentionLock)
+    LegalHold    bool      // Blob cannot be deleted until the hold is released (see SetLegalHold)
 }
 
 // Required blob store interface
@@ -18,10 +27,35 @@ type Store interface {
     PutBlob(data []byte, meta BlobType) (string, error)
     DeleteBlob(blobID string) error
     ExistsBlob(blobID string) (bool, error)
+    PutBlobs(items []PutItem) ([]PutResult, error)
+    GetBlobs(blobIDs []string) ([]GetResult, error)
+    DeleteBlobs(blobIDs []string) ([]DeleteResult, error)
+    PutDir(root string, opts PutDirOptions) (PutDirResult, error)
+    PutTree(tree Tree, meta BlobType) (string, error)
+    GetTree(treeID string) (Tree, error)
+    RestoreTree(treeID, dest string) error
+    DiffTrees(oldTreeID, newTreeID string) ([]TreeChange, error)
+
+    // Refs: names for blob and tree IDs
+    GetRef(name string) (string, error)
+    SetRef(name, id string) error
+    UpdateRef(name, oldID, newID string) error
+    DeleteRef(name string) error
+    ListRefs(prefix string) ([]Ref, error)
+    RefLog(name string) ([]RefLogEntry, error)
+
+    // Retention
+    RetentionRules() []RetentionRule
+    SetRetentionRules(rules []RetentionRule) error
+    Expire(dryRun bool) ([]ExpiredBlob, error)
+    SetRetentionLock(blobID string, until time.Time) error
+    SetLegalHold(blobID string, hold bool) error
+    AuditLog() ([]AuditEntry, error)
 
     OpenStore(path string, keyOpt ...string) error
     CreateStore(path string, keyOpt ...string) error
     PurgeStore() error
+    Close() error
 
     Stats() (StoreStats, error)
     InspectStore() ([]BlobType, error)
@@ -29,6 +63,50 @@ type Store interface {
     // Search functionality
     Search(query string) ([]SearchResult, error)
     SearchWithOptions(req SearchRequest) ([]SearchResult, error)
+    Reindex(ctx context.Context, progress func(ReindexProgress)) error
+    Flush() error
+    WaitIndexed(ctx context.Context) error
+    SimilarTo(blobID string, limit int) ([]SearchResult, error)
+    NearDuplicates(threshold float64) ([]NearDuplicateCluster, error)
+    Suggest(prefix string, field string, limit int) ([]Suggestion, error)
+    IndexHealth() (IndexHealth, error)
+    RepairIndex(ctx context.Context, progress func(ReindexProgress)) error
+
+    // Context-aware variants; the methods above call them with context.Background()
+    GetBlobContext(ctx context.Context, blobID string) ([]byte, BlobType, error)
+    PutBlobContext(ctx context.Context, data []byte, meta BlobType) (string, error)
+    DeleteBlobContext(ctx context.Context, blobID string) error
+    ExistsBlobContext(ctx context.Context, blobID string) (bool, error)
+    PutBlobsContext(ctx context.Context, items []PutItem) ([]PutResult, error)
+    GetBlobsContext(ctx context.Context, blobIDs []string) ([]GetResult, error)
+    DeleteBlobsContext(ctx context.Context, blobIDs []string) ([]DeleteResult, error)
+    PutDirContext(ctx context.Context, root string, opts PutDirOptions) (PutDirResult, error)
+    PutTreeContext(ctx context.Context, tree Tree, meta BlobType) (string, error)
+    GetTreeContext(ctx context.Context, treeID string) (Tree, error)
+    RestoreTreeContext(ctx context.Context, treeID, dest string) error
+    DiffTreesContext(ctx context.Context, oldTreeID, newTreeID string) ([]TreeChange, error)
+    GetRefContext(ctx context.Context, name string) (string, error)
+    SetRefContext(ctx context.Context, name, id string) error
+    UpdateRefContext(ctx context.Context, name, oldID, newID string) error
+    DeleteRefContext(ctx context.Context, name string) error
+    ListRefsContext(ctx context.Context, prefix string) ([]Ref, error)
+    RefLogContext(ctx context.Context, name string) ([]RefLogEntry, error)
+    ExpireContext(ctx context.Context, dryRun bool) ([]ExpiredBlob, error)
+    SetRetentionLockContext(ctx context.Context, blobID string, until time.Time) error
+    SetLegalHoldContext(ctx context.Context, blobID string, hold bool) error
+    AuditLogContext(ctx context.Context) ([]AuditEntry, error)
+    OpenStoreContext(ctx context.Context, path string, keyOpt ...string) error
+    CreateStoreContext(ctx context.Context, path string, keyOpt ...string) error
+    PurgeStoreContext(ctx context.Context) error
+    StatsContext(ctx context.Context) (StoreStats, error)
+    InspectStoreContext(ctx context.Context) ([]BlobType, error)
+    SearchContext(ctx context.Context, query string) ([]SearchResult, error)
+    SearchWithOptionsContext(ctx context.Context, req SearchRequest) ([]SearchResult, error)
+    FlushContext(ctx context.Context) error
+    SimilarToContext(ctx context.Context, blobID string, limit int) ([]SearchResult, error)
+    NearDuplicatesContext(ctx context.Context, threshold float64) ([]NearDuplicateCluster, error)
+    SuggestContext(ctx context.Context, prefix string, field string, limit int) ([]Suggestion, error)
+    IndexHealthContext(ctx context.Context) (IndexHealth, error)
 }
 
 // StoreStats summarizes the file organization in the blob store.
@@ -41,12 +119,30 @@ type StoreStats struct {
 // SearchRequest defines search parameters
 type SearchRequest struct {
     Query     string   // Search query string
+    Mode      SearchMode // How Query is interpreted; default is Bleve query-string syntax
+    Fuzziness int      // Edit distance for SearchFuzzy, 1 or 2 (default 1)
+    Field     string   // Field searched by Mode queries, e.g. "name"; default is all fields
     Limit     int      // Maximum number of results
     Offset    int      // Starting offset for pagination
     Fields    []string // Fields to return in results
     Highlight bool     // Whether to include highlighting
+    Language  string   // Optional language (e.g. "de") to also match stemmed content in
+    MIMEType  string   // Optional media type filter; may be a pattern like "image/*"
 }
 
+// SearchMode selects how SearchRequest.Query is turned into a query
+type SearchMode string
+
+const (
+    SearchQueryString SearchMode = ""         // Bleve query-string syntax, e.g. `owner:alice +report`
+    SearchMatch       SearchMode = "match"    // Analyzed words, any of them
+    SearchPhrase      SearchMode = "phrase"   // Analyzed words, adjacent and in order
+    SearchFuzzy       SearchMode = "fuzzy"    // Words within Fuzziness edits, for typos
+    SearchPrefix      SearchMode = "prefix"   // Terms starting with Query
+    SearchWildcard    SearchMode = "wildcard" // Terms matching a pattern with * and ?
+    SearchRegexp      SearchMode = "regexp"   // Terms matching a regular expression
+)
+
 // SearchResult represents a search hit
 type SearchResult struct {
     BlobID     string            // Blob identifier
@@ -55,14 +151,28 @@ type SearchResult struct {
     Highlights map[string][]string // Field -> highlighted fragments
 }
 
+// ReindexProgress reports how far a Reindex run has come
+type ReindexProgress struct {
+    Total   int // Number of blobs found in the store
+    Indexed int // Blobs written to the new index so far
+    Failed  int // Blobs that could not be read or indexed
+}
+
 // IndexDocument represents a document in the search index
 type IndexDocument struct {
     BlobID        string    `json:"blobId"`
     Name          string    `json:"name"`
     URI           string    `json:"uri"`
     Owner         string    `json:"owner"`
+    MIMEType      string    `json:"mimeType"`
     Content       string    `json:"content"`       // Extracted text content
+    Language      string    `json:"language"`      // Detected or given language code
+    Localized     map[string]string `json:"lang,omitempty"` // Language code -> content for stemmed fields
+    LSH           []string  `json:"lsh,omitempty"`  // LSH bucket keys of the fingerprint
     IngestionTime time.Time `json:"ingestionTime"`
+    ExpiresAt     *time.Time `json:"expiresAt,omitempty"` // nil if the blob does not expire by itself
+    RetainUntil   *time.Time `json:"retainUntil,omitempty"` // nil if the blob has no retention lock
+    LegalHold     bool      `json:"legalHold,omitempty"`
     Length        int64     `json:"length"`
 }
 // NowUTC returns the current time in UTC.
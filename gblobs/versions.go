@@ -0,0 +1,254 @@
+package gblobs
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net/url"
+    "path"
+    "slices"
+    "time"
+)
+
+// VersionsMIMEType is the media type of the blobs holding version histories.
+const VersionsMIMEType = "application/vnd.gblobs.versions+json"
+
+// isVersionHistory reports whether meta describes a history blob. Histories
+// are bookkeeping: searches leave them out and they never expire.
+func isVersionHistory(meta BlobType) bool {
+    return normalizeMIMEType(meta.MIMEType) == VersionsMIMEType
+}
+
+// versionsRefPrefix names the refs of version histories; the rest of the name
+// is the SHA-256 of the URI, as URIs contain characters refs cannot.
+const versionsRefPrefix = "versions/"
+
+// VersionedStore keeps a history of versions per URI on top of a Store, for
+// blobs that are saved again and again under the same name. Versions are
+// ordinary blobs, so an unchanged version costs nothing. The history of a URI
+// is a blob too, pointed at by the ref versions/<sha256 of the URI>, and
+// appending to it is a compare-and-swap of that ref, so several writers can
+// share a store.
+type VersionedStore struct {
+    Store Store
+    // KeepVersions is how many versions per URI are kept; older ones are
+    // dropped from the history when a version is added. 0 keeps all.
+    KeepVersions int
+    // DeletePruned also deletes the blobs of dropped versions unless a kept
+    // version of the same URI has the same content. Only set it if nothing
+    // else in the store can share content with the versions.
+    DeletePruned bool
+    // Logger receives blobs that could not be deleted once no history refers
+    // to them; nil logs through the LocalStore's Logger, or to stderr
+    Logger Logger
+}
+
+// Version describes one version of a URI.
+type Version struct {
+    Number int       `json:"n"` // 1 for the first version; numbers are not reused
+    BlobID string    `json:"blob"`
+    Time   time.Time `json:"time"`
+    Size   int64     `json:"size"`
+}
+
+// versionHistory is the content of a history blob.
+type versionHistory struct {
+    URI      string    `json:"uri"`
+    Versions []Version `json:"versions"` // Oldest first
+}
+
+// versionsRef returns the name of the ref holding the history of uri.
+func versionsRef(uri string) string {
+    sum := sha256.Sum256([]byte(uri))
+    return versionsRefPrefix + hex.EncodeToString(sum[:])
+}
+
+// PutVersion stores data as the next version of uri, see PutVersionContext.
+func (v *VersionedStore) PutVersion(uri string, data []byte) (Version, error) {
+    return v.PutVersionContext(context.Background(), uri, data)
+}
+
+// PutVersionContext stores data as the next version of uri and returns it. If
+// data is the same as the latest version, no version is added and the latest
+// is returned.
+func (v *VersionedStore) PutVersionContext(ctx context.Context, uri string, data []byte) (Version, error) {
+    name := uri
+    if u, err := url.Parse(uri); err == nil && u.Path != "" {
+        name = path.Base(u.Path)
+    }
+    blobID, err := v.Store.PutBlobContext(ctx, data, BlobType{Name: name, URI: uri, IngestionTime: NowUTC()})
+    if err != nil {
+        return Version{}, err
+    }
+    ref := versionsRef(uri)
+    for {
+        histID, hist, err := v.readHistory(ctx, uri)
+        if errors.Is(err, ErrNotFound) {
+            histID, hist, err = "", versionHistory{URI: uri}, nil
+        }
+        if err != nil {
+            return Version{}, err
+        }
+        next := Version{Number: 1, BlobID: blobID, Time: NowUTC(), Size: int64(len(data))}
+        if n := len(hist.Versions); n > 0 {
+            latest := hist.Versions[n-1]
+            if latest.BlobID == blobID {
+                return latest, nil
+            }
+            next.Number = latest.Number + 1
+        }
+        hist.Versions = append(hist.Versions, next)
+        var pruned []Version
+        if v.KeepVersions > 0 && len(hist.Versions) > v.KeepVersions {
+            cut := len(hist.Versions) - v.KeepVersions
+            pruned, hist.Versions = hist.Versions[:cut], hist.Versions[cut:]
+        }
+
+        enc, err := json.Marshal(hist)
+        if err != nil {
+            return Version{}, err
+        }
+        newID, err := v.Store.PutBlobContext(ctx, enc, BlobType{Name: name, URI: uri, MIMEType: VersionsMIMEType, IngestionTime: NowUTC()})
+        if err != nil {
+            return Version{}, err
+        }
+        err = v.Store.UpdateRefContext(ctx, ref, histID, newID)
+        if errors.Is(err, ErrRefConflict) {
+            // Another writer added a version meanwhile: start over from theirs
+            v.discard(ctx, newID)
+            continue
+        }
+        if err != nil {
+            return Version{}, err
+        }
+        if histID != "" {
+            v.discard(ctx, histID)
+        }
+        if v.DeletePruned {
+            v.deletePruned(ctx, pruned, hist.Versions)
+        }
+        return next, nil
+    }
+}
+
+// deletePruned deletes the blobs of pruned versions that no kept version uses.
+func (v *VersionedStore) deletePruned(ctx context.Context, pruned, kept []Version) {
+    for _, p := range pruned {
+        if !slices.ContainsFunc(kept, func(k Version) bool { return k.BlobID == p.BlobID }) {
+            v.discard(ctx, p.BlobID)
+        }
+    }
+}
+
+// discard deletes a blob no history refers to any more. The version it was
+// replaced for is stored by then, so a failure is logged rather than returned.
+// Blobs a LocalStore protects from deletion, as in a write-once store, are
+// kept without trying, which would only fill the audit log.
+func (v *VersionedStore) discard(ctx context.Context, blobID string) {
+    if ls, ok := v.Store.(*LocalStore); ok && ls.isProtected(blobID) {
+        return
+    }
+    if err := v.Store.DeleteBlobContext(ctx, blobID); err != nil && !errors.Is(err, ErrNotFound) {
+        v.logf("Warning: failed to delete blob %s dropped from a version history: %v", blobID, err)
+    }
+}
+
+func (v *VersionedStore) logf(format string, args ...any) {
+    switch ls, ok := v.Store.(*LocalStore); {
+    case v.Logger != nil:
+        v.Logger.Printf(format, args...)
+    case ok:
+        ls.logf(format, args...)
+    default:
+        log.Printf(format, args...)
+    }
+}
+
+// readHistory returns the history of uri and the ID of its blob, or
+// ErrNotFound if uri has no versions.
+func (v *VersionedStore) readHistory(ctx context.Context, uri string) (string, versionHistory, error) {
+    ref := versionsRef(uri)
+    for attempt := 0; ; attempt++ {
+        histID, err := v.Store.GetRefContext(ctx, ref)
+        if err != nil {
+            return "", versionHistory{}, err
+        }
+        data, _, err := v.Store.GetBlobContext(ctx, histID)
+        if errors.Is(err, ErrNotFound) && attempt < 3 {
+            // Replaced and deleted by a writer between the two reads
+            continue
+        }
+        if err != nil {
+            return "", versionHistory{}, err
+        }
+        var hist versionHistory
+        if err := json.Unmarshal(data, &hist); err != nil || hist.URI != uri {
+            return "", versionHistory{}, blobError(ErrCorrupt, histID, err)
+        }
+        return histID, hist, nil
+    }
+}
+
+// ListVersions returns the kept versions of uri, see ListVersionsContext.
+func (v *VersionedStore) ListVersions(uri string) ([]Version, error) {
+    return v.ListVersionsContext(context.Background(), uri)
+}
+
+// ListVersionsContext returns the kept versions of uri, oldest first, or
+// ErrNotFound if it has none.
+func (v *VersionedStore) ListVersionsContext(ctx context.Context, uri string) ([]Version, error) {
+    _, hist, err := v.readHistory(ctx, uri)
+    if err != nil {
+        return nil, err
+    }
+    return hist.Versions, nil
+}
+
+// GetVersion reads version n of uri, see GetVersionContext.
+func (v *VersionedStore) GetVersion(uri string, n int) ([]byte, Version, error) {
+    return v.GetVersionContext(context.Background(), uri, n)
+}
+
+// GetVersionContext reads version n of uri. It fails with ErrNotFound if
+// there is no such version, or it was dropped by KeepVersions.
+func (v *VersionedStore) GetVersionContext(ctx context.Context, uri string, n int) ([]byte, Version, error) {
+    _, hist, err := v.readHistory(ctx, uri)
+    if err != nil {
+        return nil, Version{}, err
+    }
+    i := slices.IndexFunc(hist.Versions, func(ver Version) bool { return ver.Number == n })
+    if i < 0 {
+        return nil, Version{}, fmt.Errorf("%w: version %d of %s", ErrNotFound, n, uri)
+    }
+    return v.readVersion(ctx, hist.Versions[i])
+}
+
+// GetLatest reads the latest version of uri, see GetLatestContext.
+func (v *VersionedStore) GetLatest(uri string) ([]byte, Version, error) {
+    return v.GetLatestContext(context.Background(), uri)
+}
+
+// GetLatestContext reads the latest version of uri, or fails with ErrNotFound
+// if it has none.
+func (v *VersionedStore) GetLatestContext(ctx context.Context, uri string) ([]byte, Version, error) {
+    _, hist, err := v.readHistory(ctx, uri)
+    if err != nil {
+        return nil, Version{}, err
+    }
+    if len(hist.Versions) == 0 {
+        return nil, Version{}, fmt.Errorf("%w: versions of %s", ErrNotFound, uri)
+    }
+    return v.readVersion(ctx, hist.Versions[len(hist.Versions)-1])
+}
+
+func (v *VersionedStore) readVersion(ctx context.Context, ver Version) ([]byte, Version, error) {
+    data, _, err := v.Store.GetBlobContext(ctx, ver.BlobID)
+    if err != nil {
+        return nil, Version{}, fmt.Errorf("version %d: %w", ver.Number, err)
+    }
+    return data, ver, nil
+}
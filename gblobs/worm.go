@@ -0,0 +1,298 @@
+package gblobs
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+// auditLogFile records every refused attempt to delete or change a protected
+// blob, one JSON AuditEntry per line. It survives PurgeStore.
+const auditLogFile = "gblobs.audit"
+
+// wormMarkerFile marks a write-once store besides its manifest, so that a lost
+// or replaced gblobs.json does not turn the protection off.
+const wormMarkerFile = "gblobs.worm"
+
+// AuditEntry is one refused attempt in the audit log.
+type AuditEntry struct {
+    Time   time.Time `json:"time"`
+    Op     string    `json:"op"`             // "delete", "purge", "shorten-retention" or "downgrade"
+    BlobID string    `json:"blob,omitempty"` // Empty for PurgeStore of a write-once store
+    Reason string    `json:"reason"`         // What protects the blob, e.g. "legal hold"
+}
+
+// lockReason says why a blob with meta cannot be deleted at now, or returns ""
+// if it can.
+func (s *LocalStore) lockReason(meta BlobType, now time.Time) string {
+    switch {
+    case s.WORM:
+        return "store is write-once"
+    case meta.LegalHold:
+        return "legal hold"
+    case meta.RetainUntil.After(now):
+        return "retained until " + meta.RetainUntil.UTC().Format(time.RFC3339)
+    }
+    return ""
+}
+
+// checkWORM settles whether the store is write-once once s.manifest is loaded:
+// it is if the manifest or the marker file says so. A manifest that lost the
+// flag is audited as a downgrade and fixed; a missing marker is created. A
+// ReadOnly store writes neither and leaves that to the next writer.
+func (s *LocalStore) checkWORM() error {
+    marker := filepath.Join(s.path, wormMarkerFile)
+    _, err := os.Stat(marker)
+    if err != nil && !errors.Is(err, fs.ErrNotExist) {
+        return err
+    }
+    marked := err == nil
+    switch {
+    case marked && !s.manifest.WORM:
+        s.manifest.WORM = true
+        if s.ReadOnly {
+            break
+        }
+        if err := s.appendAudit(AuditEntry{Time: NowUTC(), Op: "downgrade", Reason: "manifest does not record the store as write-once"}); err != nil {
+            s.logf("Warning: failed to write audit log: %v", err)
+        }
+        if err := saveManifest(s.path, s.manifest); err != nil {
+            return err
+        }
+    case s.manifest.WORM && !marked && !s.ReadOnly:
+        // Write-once stores created before the marker existed
+        if err := os.WriteFile(marker, []byte("write-once\n"), 0o444); err != nil {
+            return err
+        }
+    }
+    s.WORM = s.manifest.WORM
+    return nil
+}
+
+// deny records a refused attempt in the audit log and returns the error for it.
+func (s *LocalStore) deny(op, blobID, reason string) error {
+    if err := s.appendAudit(AuditEntry{Time: NowUTC(), Op: op, BlobID: blobID, Reason: reason}); err != nil {
+        s.logf("Warning: failed to write audit log: %v", err)
+    }
+    if blobID == "" {
+        return fmt.Errorf("%w: %s: %s", ErrRetentionLocked, op, reason)
+    }
+    return blobError(ErrRetentionLocked, blobID, errors.New(reason))
+}
+
+func (s *LocalStore) appendAudit(e AuditEntry) error {
+    line, err := json.Marshal(e)
+    if err != nil {
+        return err
+    }
+    s.auditMu.Lock()
+    defer s.auditMu.Unlock()
+    f, err := os.OpenFile(filepath.Join(s.path, auditLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+    if err != nil {
+        return err
+    }
+    if _, err := f.Write(append(line, '\n')); err != nil {
+        f.Close()
+        return err
+    }
+    return f.Close()
+}
+
+// checkDeletable returns ErrRetentionLocked, and audits the attempt, if
+// blobID cannot be deleted. The caller holds s.lock and the blob's lock.
+func (s *LocalStore) checkDeletable(blobID string) error {
+    if s.WORM {
+        return s.deny("delete", blobID, s.lockReason(BlobType{}, NowUTC()))
+    }
+    meta, err := s.readMeta(blobID)
+    if err != nil {
+        // Missing or unreadable metadata protects nothing
+        return nil
+    }
+    if reason := s.lockReason(meta, NowUTC()); reason != "" {
+        return s.deny("delete", blobID, reason)
+    }
+    return nil
+}
+
+// isProtected reports whether deleting blobID would be refused, without
+// auditing anything.
+func (s *LocalStore) isProtected(blobID string) bool {
+    if s.WORM {
+        return true
+    }
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+    meta, err := s.readMeta(blobID)
+    return err == nil && s.lockReason(meta, NowUTC()) != ""
+}
+
+// checkPurgeable returns ErrRetentionLocked, and audits the attempt, if the
+// store is write-once or holds a protected blob.
+func (s *LocalStore) checkPurgeable(ctx context.Context) error {
+    if s.WORM {
+        return s.deny("purge", "", s.lockReason(BlobType{}, NowUTC()))
+    }
+    ids, err := s.listBlobIDs(ctx)
+    if err != nil {
+        return err
+    }
+    now := NowUTC()
+    for _, id := range ids {
+        if err := ctx.Err(); err != nil {
+            return err
+        }
+        meta, err := s.readMeta(id)
+        if err != nil {
+            continue
+        }
+        if reason := s.lockReason(meta, now); reason != "" {
+            return s.deny("purge", id, reason)
+        }
+    }
+    return nil
+}
+
+// keepProtectedMeta keeps a put of the stored blob p from changing its
+// retention settings, and, if the blob is protected, its metadata: the put
+// then leaves the blob as it is, so storing the same content under another
+// name still succeeds. The caller holds s.lock and the blob's lock.
+func (s *LocalStore) keepProtectedMeta(p *preparedPut) {
+    stored, err := s.readMeta(p.blobID)
+    if err != nil {
+        return
+    }
+    // Only SetRetentionLock and SetLegalHold change these
+    p.doc.RetainUntil, p.doc.LegalHold = timeField(stored.RetainUntil), stored.LegalHold
+    if s.lockReason(stored, NowUTC()) == "" {
+        return
+    }
+    if !sameDescription(p.meta, stored) {
+        p.meta = stored
+        p.doc = s.indexDocument(p.blobID, p.data, s.extractBodyText(p.data, stored), stored)
+        return
+    }
+    p.doc.IngestionTime = stored.IngestionTime
+}
+
+// sameDescription reports whether a and b differ at most in what a put does
+// not describe: the ingestion time and the retention settings.
+func sameDescription(a, b BlobType) bool {
+    return a.Name == b.Name && a.URI == b.URI && a.Owner == b.Owner && a.Language == b.Language &&
+        a.MIMEType == b.MIMEType && a.ExpiresAt.Equal(b.ExpiresAt)
+}
+
+// SetRetentionLock protects a blob until a time, see SetRetentionLockContext.
+func (s *LocalStore) SetRetentionLock(blobID string, until time.Time) error {
+    return s.SetRetentionLockContext(context.Background(), blobID, until)
+}
+
+// SetRetentionLockContext makes blobID undeletable until the given time. A lock
+// can be extended but not shortened or removed while it lasts; trying fails
+// with ErrRetentionLocked and is recorded in the audit log. A lock can also be
+// set when storing a blob, with BlobType.RetainUntil.
+func (s *LocalStore) SetRetentionLockContext(ctx context.Context, blobID string, until time.Time) error {
+    return s.updateMeta(ctx, blobID, func(meta *BlobType) error {
+        if meta.RetainUntil.After(NowUTC()) && until.Before(meta.RetainUntil) {
+            return s.deny("shorten-retention", blobID, "retained until "+meta.RetainUntil.UTC().Format(time.RFC3339))
+        }
+        meta.RetainUntil = until.UTC()
+        return nil
+    })
+}
+
+// SetLegalHold places or releases a legal hold, see SetLegalHoldContext.
+func (s *LocalStore) SetLegalHold(blobID string, hold bool) error {
+    return s.SetLegalHoldContext(context.Background(), blobID, hold)
+}
+
+// SetLegalHoldContext places a legal hold on blobID, which makes it
+// undeletable with no end date, or releases it. Releasing a hold leaves any
+// retention lock in place.
+func (s *LocalStore) SetLegalHoldContext(ctx context.Context, blobID string, hold bool) error {
+    return s.updateMeta(ctx, blobID, func(meta *BlobType) error {
+        meta.LegalHold = hold
+        return nil
+    })
+}
+
+// updateMeta rewrites the metadata of blobID as changed by change and updates
+// its index entry.
+func (s *LocalStore) updateMeta(ctx context.Context, blobID string, change func(*BlobType) error) error {
+    if err := s.checkOpen(); err != nil {
+        return err
+    }
+    if err := s.checkWritable(); err != nil {
+        return err
+    }
+    if err := ValidateBlobID(blobID); err != nil {
+        return err
+    }
+    s.lock.RLock()
+    defer s.lock.RUnlock()
+    bl := s.blobLocks.of(blobID)
+    bl.Lock()
+    defer bl.Unlock()
+    data, meta, err := s.readBlob(ctx, blobID)
+    if err != nil {
+        return err
+    }
+    if err := change(&meta); err != nil {
+        return err
+    }
+    mb, err := json.MarshalIndent(meta, "", "  ")
+    if err != nil {
+        return err
+    }
+    fullPath := filepath.Join(s.path, BlobIDToPath(blobID))
+    if err := writeFileAtomic(ctx, fullPath+".meta", mb, 0o600); err != nil {
+        return err
+    }
+    s.noteChange(blobID)
+    if s.queue != nil {
+        return s.enqueueIndex(blobID, s.indexDocument(blobID, data, s.extractBodyText(data, meta), meta))
+    }
+    return nil
+}
+
+// AuditLog returns the audit log, see AuditLogContext.
+func (s *LocalStore) AuditLog() ([]AuditEntry, error) {
+    return s.AuditLogContext(context.Background())
+}
+
+// AuditLogContext returns every refused attempt to delete a protected blob,
+// purge a store holding one, or change its metadata or retention lock, oldest
+// first.
+func (s *LocalStore) AuditLogContext(ctx context.Context) ([]AuditEntry, error) {
+    if err := s.checkOpen(); err != nil {
+        return nil, err
+    }
+    f, err := os.Open(filepath.Join(s.path, auditLogFile))
+    if errors.Is(err, fs.ErrNotExist) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    var log []AuditEntry
+    sc := bufio.NewScanner(f)
+    for sc.Scan() {
+        if err := ctx.Err(); err != nil {
+            return nil, err
+        }
+        var e AuditEntry
+        if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+            // A line cut short by a crash
+            continue
+        }
+        log = append(log, e)
+    }
+    return log, sc.Err()
+}
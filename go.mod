@@ -4,6 +4,7 @@ go 1.24.3
 
 require (
 	github.com/blevesearch/bleve/v2 v2.5.3
+	go.etcd.io/bbolt v1.4.0
 )
 
 require (
@@ -30,6 +31,6 @@ require (
 	github.com/golang/snappy v0.0.4 // indirect
 	github.com/json-iterator/go v0.0.0-20171115153421-f7279a603ede // indirect
 	github.com/mschoch/smat v0.2.0 // indirect
-	go.etcd.io/bbolt v1.4.0 // indirect
 	golang.org/x/sys v0.29.0 // indirect
+	golang.org/x/text v0.8.0 // indirect
 )
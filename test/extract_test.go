@@ -0,0 +1,197 @@
+package test
+
+import (
+    "archive/zip"
+    "bytes"
+    "compress/zlib"
+    "fmt"
+    "strings"
+    "testing"
+    "time"
+    "github.com/example/gblobs/gblobs"
+)
+
+// extractViaSearch stores data under name and reports whether query finds it.
+func extractViaSearch(t *testing.T, name string, data []byte, query string) bool {
+    t.Helper()
+    store := quickStore(t, false)
+    id, err := store.PutBlob(data, gblobs.BlobType{Name: name, IngestionTime: time.Now().UTC()})
+    if err != nil {
+        t.Fatal(err)
+    }
+    results, err := store.Search(query)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, r := range results {
+        if r.BlobID == id {
+            return true
+        }
+    }
+    return false
+}
+
+func zipArchive(t *testing.T, files map[string]string) []byte {
+    t.Helper()
+    var buf bytes.Buffer
+    zw := zip.NewWriter(&buf)
+    for name, body := range files {
+        w, err := zw.Create(name)
+        if err != nil {
+            t.Fatal(err)
+        }
+        w.Write([]byte(body))
+    }
+    if err := zw.Close(); err != nil {
+        t.Fatal(err)
+    }
+    return buf.Bytes()
+}
+
+func TestExtractHTML(t *testing.T) {
+    page := []byte(`<!DOCTYPE html><html><head><title>Harbour guide</title>
+<style>.lighthouse { color: red }</style><script>var submarine = 1;</script></head>
+<body><!-- zeppelin --><p class="intro">Boats &amp; ferries</p><div>a &lt; b</div></body></html>`)
+    if !extractViaSearch(t, "guide.html", page, "ferries") {
+        t.Error("visible HTML text not indexed")
+    }
+    for _, hidden := range []string{"lighthouse", "submarine", "zeppelin", "intro"} {
+        if extractViaSearch(t, "guide.html", page, hidden) {
+            t.Errorf("markup term %q should not be indexed", hidden)
+        }
+    }
+}
+
+func TestExtractHTMLNonASCIIScript(t *testing.T) {
+    // Lowercasing shortens "İ", which must not shift where the script ends
+    page := []byte(`<html><body><script>var s = "` + strings.Repeat("İ", 20) + ` hidden lemur";</SCRIPT>` +
+        `<p>keep announcement</p></body></html>`)
+    if !extractViaSearch(t, "page.html", page, "announcement") {
+        t.Error("text after a script with non-ASCII content not indexed")
+    }
+    if extractViaSearch(t, "page.html", page, "lemur") {
+        t.Error("script content should not be indexed")
+    }
+}
+
+func TestExtractDOCXAndODT(t *testing.T) {
+    docx := zipArchive(t, map[string]string{
+        "[Content_Types].xml": `<Types/>`,
+        "word/document.xml": `<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+<w:body><w:p><w:r><w:t>Annual</w:t></w:r><w:r><w:t xml:space="preserve"> budget forecast</w:t></w:r></w:p></w:body></w:document>`,
+    })
+    if !extractViaSearch(t, "plan.docx", docx, "forecast") {
+        t.Error("DOCX text not indexed")
+    }
+    odt := zipArchive(t, map[string]string{
+        "mimetype": "application/vnd.oasis.opendocument.text",
+        "content.xml": `<office:document-content xmlns:office="urn:oasis:names:tc:opendocument:xmlns:office:1.0" xmlns:text="urn:oasis:names:tc:opendocument:xmlns:text:1.0">
+<office:body><office:text><text:h>Minutes</text:h><text:p>Volunteers<text:s/>planted oak trees</text:p></office:text></office:body></office:document-content>`,
+    })
+    if !extractViaSearch(t, "minutes.odt", odt, "volunteers") {
+        t.Error("ODT text not indexed")
+    }
+}
+
+func TestExtractPDF(t *testing.T) {
+    var stream bytes.Buffer
+    zw := zlib.NewWriter(&stream)
+    zw.Write([]byte("BT /F1 12 Tf 72 712 Td (Quarterly \\(draft\\) telescope report) Tj 0 -14 Td [(Astro)-300(nomy)] TJ ET"))
+    zw.Close()
+    var pdf bytes.Buffer
+    fmt.Fprintf(&pdf, "%%PDF-1.4\n4 0 obj\n<< /Length %d /Filter /FlateDecode >>\nstream\n", stream.Len())
+    pdf.Write(stream.Bytes())
+    pdf.WriteString("\nendstream\nendobj\n%%EOF\n")
+
+    if !extractViaSearch(t, "report.pdf", pdf.Bytes(), "telescope") {
+        t.Error("PDF text not indexed")
+    }
+    if !extractViaSearch(t, "report.pdf", pdf.Bytes(), "nomy") {
+        t.Error("TJ array text not indexed")
+    }
+}
+
+func TestExtractCapsDecompressedSize(t *testing.T) {
+    // Well past the extraction cap once decompressed, a few kilobytes stored
+    padding := strings.Repeat(" ", 40<<20)
+    docx := zipArchive(t, map[string]string{
+        "word/document.xml": `<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>` +
+            `<w:p><w:r><w:t>prologue</w:t></w:r></w:p>` + padding + `<w:p><w:r><w:t>epilogue</w:t></w:r></w:p></w:body></w:document>`,
+    })
+    if !extractViaSearch(t, "huge.docx", docx, "prologue") {
+        t.Error("text before the cap not indexed")
+    }
+    if extractViaSearch(t, "huge.docx", docx, "epilogue") {
+        t.Error("text past the cap should not be indexed")
+    }
+
+    var pdf bytes.Buffer
+    pdf.WriteString("%PDF-1.4\n")
+    for i, text := range []string{"BT (prologue) Tj ET" + padding, "BT (epilogue) Tj ET"} {
+        var stream bytes.Buffer
+        zw := zlib.NewWriter(&stream)
+        zw.Write([]byte(text))
+        zw.Close()
+        fmt.Fprintf(&pdf, "%d 0 obj\n<< /Length %d /Filter /FlateDecode >>\nstream\n", i+4, stream.Len())
+        pdf.Write(stream.Bytes())
+        pdf.WriteString("\nendstream\nendobj\n")
+    }
+    if !extractViaSearch(t, "huge.pdf", pdf.Bytes(), "prologue") {
+        t.Error("PDF text before the cap not indexed")
+    }
+    if extractViaSearch(t, "huge.pdf", pdf.Bytes(), "epilogue") {
+        t.Error("PDF text past the cap should not be indexed")
+    }
+}
+
+func TestExtractEML(t *testing.T) {
+    eml := strings.ReplaceAll(`From: Alice <alice@example.com>
+To: Bob <bob@example.com>
+Subject: =?UTF-8?Q?Caf=C3=A9_invoice?=
+MIME-Version: 1.0
+Content-Type: multipart/alternative; boundary="XYZ"
+
+--XYZ
+Content-Type: text/plain; charset=utf-8
+Content-Transfer-Encoding: quoted-printable
+
+Please pay the espresso machine invoice by Fri=
+day.
+--XYZ
+Content-Type: text/html; charset=utf-8
+Content-Transfer-Encoding: base64
+
+PHA+UGxlYXNlIHBheSB0aGUgPGI+Z3JpbmRlcjwvYj4gaW52b2ljZS48L3A+
+--XYZ--
+`, "\n", "\r\n")
+    if !extractViaSearch(t, "invoice.eml", []byte(eml), "Friday") {
+        t.Error("quoted-printable text part not indexed")
+    }
+    if !extractViaSearch(t, "invoice.eml", []byte(eml), "grinder") {
+        t.Error("base64 HTML part not indexed")
+    }
+    if !extractViaSearch(t, "invoice.eml", []byte(eml), "Café") {
+        t.Error("encoded subject not indexed")
+    }
+}
+
+func TestRegisterTextExtractor(t *testing.T) {
+    gblobs.RegisterTextExtractor(".rot13", gblobs.TextExtractorFunc(func(data []byte, meta gblobs.BlobType) (string, error) {
+        out := make([]byte, len(data))
+        for i, c := range data {
+            switch {
+            case c >= 'a' && c <= 'z':
+                c = 'a' + (c-'a'+13)%26
+            case c >= 'A' && c <= 'Z':
+                c = 'A' + (c-'A'+13)%26
+            }
+            out[i] = c
+        }
+        return string(out), nil
+    }))
+    defer gblobs.RegisterTextExtractor(".rot13", nil)
+
+    if !extractViaSearch(t, "secret.rot13", []byte("cnenxrrg"), "parakeet") {
+        t.Error("custom extractor output not indexed")
+    }
+}
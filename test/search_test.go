@@ -1,10 +1,16 @@
 package test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
 	"testing"
 	"time"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"github.com/example/gblobs/gblobs"
 )
 
@@ -155,4 +161,790 @@ func TestBasicSearch(t *testing.T) {
 			}
 		}
 	})
-}
\ No newline at end of file
+}
+func TestReindex(t *testing.T) {
+	store := &gblobs.LocalStore{}
+	if err := store.CreateStore(t.TempDir()); err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	docs := []string{
+		"Quarterly report on renewable energy",
+		"Notes about solar panels and wind turbines",
+		"Grocery list: apples, oranges, energy drinks",
+	}
+	for i, d := range docs {
+		meta := gblobs.BlobType{Name: "doc" + string(rune('a'+i)) + ".txt", IngestionTime: time.Now().UTC()}
+		if _, err := store.PutBlob([]byte(d), meta); err != nil {
+			t.Fatalf("Failed to put blob: %v", err)
+		}
+	}
+
+	var last gblobs.ReindexProgress
+	calls := 0
+	err := store.Reindex(context.Background(), func(p gblobs.ReindexProgress) {
+		last = p
+		calls++
+	})
+	if err != nil {
+		t.Fatalf("Reindex failed: %v", err)
+	}
+	if calls == 0 {
+		t.Error("Expected progress callback to be called")
+	}
+	if last.Total != 3 || last.Indexed != 3 || last.Failed != 0 {
+		t.Errorf("Unexpected final progress: %+v", last)
+	}
+
+	results, err := store.Search("energy")
+	if err != nil {
+		t.Fatalf("Search after reindex failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Errorf("Expected 2 results for 'energy' after reindex, got %d", len(results))
+	}
+	if _, err := os.Stat(filepath.Join(store.Path(), "index.bleve.tmp")); !os.IsNotExist(err) {
+		t.Error("Temporary index directory left behind")
+	}
+
+	t.Run("Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := store.Reindex(ctx, nil); err != context.Canceled {
+			t.Fatalf("Expected context.Canceled, got %v", err)
+		}
+		results, err := store.Search("energy")
+		if err != nil || len(results) != 2 {
+			t.Errorf("Live index should be untouched by a cancelled reindex, got %d results (%v)", len(results), err)
+		}
+	})
+}
+
+func TestOpenStoreOutdatedIndex(t *testing.T) {
+	src := &gblobs.LocalStore{}
+	if err := src.CreateStore(t.TempDir()); err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	meta := gblobs.BlobType{Name: "legacy.txt", IngestionTime: time.Now().UTC()}
+	if _, err := src.PutBlob([]byte("an old document about lighthouses"), meta); err != nil {
+		t.Fatalf("Failed to put blob: %v", err)
+	}
+
+	// Copy only blobs and metadata, as a store without manifest or index looks
+	legacy := t.TempDir()
+	copyBlobFiles(t, src.Path(), legacy)
+
+	refused := &gblobs.LocalStore{}
+	err := refused.OpenStore(legacy)
+	if !errors.Is(err, gblobs.ErrIndexOutdated) {
+		t.Fatalf("Expected ErrIndexOutdated, got %v", err)
+	}
+
+	store := &gblobs.LocalStore{MappingPolicy: gblobs.MappingIgnore}
+	if err := store.OpenStore(legacy); err != nil {
+		t.Fatalf("Open with MappingIgnore failed: %v", err)
+	}
+	results, err := store.Search("lighthouses")
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 0 {
+		t.Fatalf("Expected empty index before reindex, got %d results", len(results))
+	}
+	if err := store.Reindex(context.Background(), nil); err != nil {
+		t.Fatalf("Reindex failed: %v", err)
+	}
+	results, err = store.Search("lighthouses")
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 1 {
+		t.Errorf("Expected 1 result after reindex, got %d", len(results))
+	}
+}
+
+func TestOpenStoreRebuildInBackground(t *testing.T) {
+	src := &gblobs.LocalStore{}
+	if err := src.CreateStore(t.TempDir()); err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		meta := gblobs.BlobType{Name: fmt.Sprintf("log%d.txt", i), IngestionTime: time.Now().UTC()}
+		if _, err := src.PutBlob([]byte(fmt.Sprintf("keeper's log %d: the lamp of the lighthouse", i)), meta); err != nil {
+			t.Fatalf("Failed to put blob: %v", err)
+		}
+	}
+	legacy := t.TempDir()
+	copyBlobFiles(t, src.Path(), legacy)
+
+	// Closing right away cancels the rebuild and waits for it
+	store := &gblobs.LocalStore{MappingPolicy: gblobs.MappingRebuild}
+	if err := store.OpenStore(legacy); err != nil {
+		t.Fatalf("Open with MappingRebuild failed: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(legacy, "index.bleve.tmp")); !os.IsNotExist(err) {
+		t.Error("Temporary index directory left behind by a cancelled rebuild")
+	}
+
+	if err := store.OpenStore(legacy); err != nil {
+		t.Fatalf("Reopen with MappingRebuild failed: %v", err)
+	}
+	defer store.Close()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		results, err := store.Search("lighthouse")
+		if err == nil && len(results) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Background rebuild did not finish, last search: %d results, %v", len(results), err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestSearchNonLatinText(t *testing.T) {
+	store := &gblobs.LocalStore{}
+	if err := store.CreateStore(t.TempDir()); err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	put := func(name string, data []byte, lang string) string {
+		id, err := store.PutBlob(data, gblobs.BlobType{Name: name, Language: lang, IngestionTime: time.Now().UTC()})
+		if err != nil {
+			t.Fatalf("Failed to put %s: %v", name, err)
+		}
+		return id
+	}
+	ruID := put("letter", []byte("Я читаю интересные книги и журналы каждый вечер."), "")
+	zhID := put("capital", []byte("北京是中国的首都，也是一座历史悠久的城市。"), "")
+	deID := put("brief", []byte("Die alten Häuser in der Straße sind sehr schön und groß."), "")
+
+	// UTF-16LE with BOM must be transcoded before indexing
+	utf16 := []byte{0xFF, 0xFE}
+	for _, r := range "Grüße aus München" {
+		utf16 = append(utf16, byte(r), byte(r>>8))
+	}
+	u16ID := put("greeting", utf16, "")
+
+	cases := []struct {
+		query, lang, want string
+	}{
+		{"книги", "", ruID},
+		{"книга", "ru", ruID},
+		{"首都", "", zhID},
+		{"首都", "cjk", zhID},
+		{"Häuser", "", deID},
+		{"Haus", "de", deID},
+		{"München", "", u16ID},
+	}
+	for _, c := range cases {
+		results, err := store.SearchWithOptions(gblobs.SearchRequest{Query: c.query, Language: c.lang})
+		if err != nil {
+			t.Fatalf("Search %q (%s) failed: %v", c.query, c.lang, err)
+		}
+		found := false
+		for _, r := range results {
+			if r.BlobID == c.want {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Search %q (lang %q) did not find expected blob", c.query, c.lang)
+		}
+	}
+
+	if _, err := store.SearchWithOptions(gblobs.SearchRequest{Query: "x", Language: "tlh"}); err == nil {
+		t.Error("Expected error for unsupported language")
+	}
+}
+
+func TestSearchByMIMEType(t *testing.T) {
+	store := &gblobs.LocalStore{}
+	if err := store.CreateStore(t.TempDir()); err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	imgID, err := store.PutBlob(png, gblobs.BlobType{Name: "holiday.png", Owner: "carol"})
+	if err != nil {
+		t.Fatalf("Failed to put image: %v", err)
+	}
+	txtID, err := store.PutBlob([]byte("holiday plans"), gblobs.BlobType{Name: "holiday.txt", Owner: "carol"})
+	if err != nil {
+		t.Fatalf("Failed to put text: %v", err)
+	}
+	csvID, err := store.PutBlob([]byte("a,b\n1,2\n"), gblobs.BlobType{Name: "holiday", Owner: "carol", MIMEType: "text/csv"})
+	if err != nil {
+		t.Fatalf("Failed to put csv: %v", err)
+	}
+
+	_, meta, err := store.GetBlob(imgID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.MIMEType != "image/png" {
+		t.Errorf("Expected sniffed type image/png, got %q", meta.MIMEType)
+	}
+	_, meta, err = store.GetBlob(csvID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.MIMEType != "text/csv" {
+		t.Errorf("Caller-provided type should be kept, got %q", meta.MIMEType)
+	}
+
+	cases := map[string][]string{
+		"image/*":    {imgID},
+		"text/*":     {txtID, csvID},
+		"text/plain": {txtID},
+	}
+	for pattern, want := range cases {
+		results, err := store.SearchWithOptions(gblobs.SearchRequest{Query: "carol", MIMEType: pattern})
+		if err != nil {
+			t.Fatalf("Search with type %s failed: %v", pattern, err)
+		}
+		if len(results) != len(want) {
+			t.Errorf("Type %s: expected %d results, got %d", pattern, len(want), len(results))
+			continue
+		}
+		for _, id := range want {
+			found := false
+			for _, r := range results {
+				found = found || r.BlobID == id
+			}
+			if !found {
+				t.Errorf("Type %s: missing expected blob", pattern)
+			}
+		}
+	}
+}
+
+func TestMIMETypeOverrideNormalized(t *testing.T) {
+	store := &gblobs.LocalStore{}
+	if err := store.CreateStore(t.TempDir()); err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	id, err := store.PutBlob([]byte("quarterly memo"), gblobs.BlobType{Name: "memo", Owner: "dave", MIMEType: " Text/Plain; charset=UTF-8"})
+	if err != nil {
+		t.Fatalf("Failed to put blob: %v", err)
+	}
+	_, meta, err := store.GetBlob(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.MIMEType != "text/plain" {
+		t.Errorf("Expected normalized type text/plain, got %q", meta.MIMEType)
+	}
+	for _, pattern := range []string{"text/plain", "text/*"} {
+		results, err := store.SearchWithOptions(gblobs.SearchRequest{Query: "dave", MIMEType: pattern})
+		if err != nil {
+			t.Fatalf("Search with type %s failed: %v", pattern, err)
+		}
+		if len(results) != 1 || results[0].BlobID != id {
+			t.Errorf("Type %s: expected the overridden blob, got %d results", pattern, len(results))
+		}
+	}
+}
+
+// copyBlobFiles copies the .blob and .meta files of a store, leaving out the
+// index, manifest and queue journal.
+func copyBlobFiles(t *testing.T, from, to string) {
+	t.Helper()
+	err := filepath.Walk(from, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if fi.Name() == "index.bleve" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		ext := filepath.Ext(p)
+		if ext != ".blob" && ext != ".meta" {
+			return nil
+		}
+		rel, _ := filepath.Rel(from, p)
+		dst := filepath.Join(to, rel)
+		if err := gblobs.EnsureDir(filepath.Dir(dst)); err != nil {
+			return err
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(dst, b, 0o600)
+	})
+	if err != nil {
+		t.Fatalf("Failed to copy store: %v", err)
+	}
+}
+
+func TestIndexQueue(t *testing.T) {
+	store := &gblobs.LocalStore{}
+	if err := store.CreateStore(t.TempDir()); err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	var ids []string
+	for i := 0; i < 250; i++ {
+		meta := gblobs.BlobType{Name: fmt.Sprintf("bulk-%d.txt", i), IngestionTime: time.Now().UTC()}
+		id, err := store.PutBlob([]byte(fmt.Sprintf("bulk document number %d about zeppelins", i)), meta)
+		if err != nil {
+			t.Fatalf("Failed to put blob: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := store.WaitIndexed(ctx); err != nil {
+		t.Fatalf("WaitIndexed failed: %v", err)
+	}
+	results, err := store.SearchWithOptions(gblobs.SearchRequest{Query: "zeppelins", Limit: 1000})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != len(ids) {
+		t.Fatalf("Expected %d results, got %d", len(ids), len(results))
+	}
+
+	if err := store.DeleteBlob(ids[0]); err != nil {
+		t.Fatalf("Failed to delete blob: %v", err)
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	results, err = store.SearchWithOptions(gblobs.SearchRequest{Query: "zeppelins", Limit: 1000})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != len(ids)-1 {
+		t.Fatalf("Expected %d results after delete, got %d", len(ids)-1, len(results))
+	}
+
+	// The journal is cleared once everything is committed
+	journal, err := os.ReadFile(filepath.Join(store.Path(), "index.queue"))
+	if err != nil {
+		t.Fatalf("Failed to read queue journal: %v", err)
+	}
+	if len(journal) != 0 {
+		t.Errorf("Expected empty queue journal, got %q", journal)
+	}
+}
+
+func TestPurgeReleasesIndexWaiters(t *testing.T) {
+	store := &gblobs.LocalStore{}
+	if err := store.CreateStore(t.TempDir()); err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+	for i := 0; i < 50; i++ {
+		if _, err := store.PutBlob([]byte(fmt.Sprintf("doomed document %d", i)), gblobs.BlobType{}); err != nil {
+			t.Fatalf("Failed to put blob: %v", err)
+		}
+	}
+	// WaitIndexed does not hurry the worker, so the purge discards its ops
+	waited := make(chan error, 1)
+	go func() {
+		waited <- store.WaitIndexed(context.Background())
+	}()
+	time.Sleep(20 * time.Millisecond) // well within the worker's linger
+	purged := make(chan error, 1)
+	go func() {
+		purged <- store.PurgeStore()
+	}()
+	for _, c := range []struct {
+		name string
+		ch   chan error
+	}{{"PurgeStore", purged}, {"WaitIndexed", waited}} {
+		select {
+		case err := <-c.ch:
+			if err != nil && !errors.Is(err, gblobs.ErrStoreClosed) {
+				t.Errorf("%s failed: %v", c.name, err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s hangs after a purge discarded the index queue", c.name)
+		}
+	}
+	journal, err := os.ReadFile(filepath.Join(store.Path(), "index.queue"))
+	if err != nil {
+		t.Fatalf("Failed to read journal: %v", err)
+	}
+	if len(journal) != 0 {
+		t.Errorf("Expected an empty journal after a purge, got %d bytes", len(journal))
+	}
+}
+
+func TestIndexQueueReplay(t *testing.T) {
+	src := &gblobs.LocalStore{}
+	if err := src.CreateStore(t.TempDir()); err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	meta := gblobs.BlobType{Name: "crash.txt", IngestionTime: time.Now().UTC()}
+	id, err := src.PutBlob([]byte("written just before the crash about walruses"), meta)
+	if err != nil {
+		t.Fatalf("Failed to put blob: %v", err)
+	}
+
+	// A store whose process died after writing the blob but before indexing it
+	crashed := t.TempDir()
+	copyBlobFiles(t, src.Path(), crashed)
+	if err := os.WriteFile(filepath.Join(crashed, "index.queue"), []byte("+"+id+"\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write queue journal: %v", err)
+	}
+
+	store := &gblobs.LocalStore{MappingPolicy: gblobs.MappingIgnore}
+	if err := store.OpenStore(crashed); err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	results, err := store.Search("walruses")
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 1 || results[0].BlobID != id {
+		t.Fatalf("Expected replayed blob %s in results, got %+v", id, results)
+	}
+}
+
+func TestIndexQueueReplayKeepsJournaledMetadata(t *testing.T) {
+	src := &gblobs.LocalStore{}
+	if err := src.CreateStore(t.TempDir()); err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer src.Close()
+	data := []byte("minutes of the lighthouse keepers' meeting")
+	if _, err := src.PutBlob(data, gblobs.BlobType{Name: "draft.txt"}); err != nil {
+		t.Fatalf("Failed to put blob: %v", err)
+	}
+	if err := src.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	// A deduplicated put only changes the index, so its metadata lives in the journal
+	id, err := src.PutBlob(data, gblobs.BlobType{Name: "approved.txt", Owner: "harbourmaster"})
+	if err != nil {
+		t.Fatalf("Failed to put blob: %v", err)
+	}
+	journal, err := os.ReadFile(filepath.Join(src.Path(), "index.queue"))
+	if err != nil {
+		t.Fatalf("Failed to read journal: %v", err)
+	}
+	if len(journal) == 0 {
+		t.Skip("the index worker committed before the journal was read")
+	}
+
+	crashed := t.TempDir()
+	copyBlobFiles(t, src.Path(), crashed)
+	if err := os.WriteFile(filepath.Join(crashed, "index.queue"), journal, 0o600); err != nil {
+		t.Fatalf("Failed to write queue journal: %v", err)
+	}
+	store := &gblobs.LocalStore{MappingPolicy: gblobs.MappingIgnore}
+	if err := store.OpenStore(crashed); err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	defer store.Close()
+	results, err := store.Search("owner:harbourmaster")
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 1 || results[0].BlobID != id {
+		t.Fatalf("Expected the replayed metadata of %s, got %+v", id, results)
+	}
+}
+
+func TestIndexHealthAndRepair(t *testing.T) {
+	var reported []string
+	store := &gblobs.LocalStore{OnIndexError: func(blobID string, err error) {
+		reported = append(reported, blobID)
+	}}
+	if err := store.CreateStore(t.TempDir()); err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	if _, err := store.PutBlob([]byte("a healthy note about otters"), gblobs.BlobType{Name: "a.txt"}); err != nil {
+		t.Fatalf("Failed to put blob: %v", err)
+	}
+	broken, err := store.PutBlob([]byte("a damaged note about beavers"), gblobs.BlobType{Name: "b.txt"})
+	if err != nil {
+		t.Fatalf("Failed to put blob: %v", err)
+	}
+
+	if err := store.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	h, err := store.IndexHealth()
+	if err != nil {
+		t.Fatalf("IndexHealth failed: %v", err)
+	}
+	if !h.Healthy() {
+		t.Fatalf("Expected healthy index, got %+v", h)
+	}
+
+	// Corrupt one blob so the rebuild cannot read it
+	blobPath := filepath.Join(store.Path(), gblobs.BlobIDToPath(broken))
+	good, err := os.ReadFile(blobPath)
+	if err != nil {
+		t.Fatalf("Failed to read blob file: %v", err)
+	}
+	if err := os.WriteFile(blobPath, []byte("garbage"), 0o600); err != nil {
+		t.Fatalf("Failed to corrupt blob file: %v", err)
+	}
+	var last gblobs.ReindexProgress
+	if err := store.Reindex(context.Background(), func(p gblobs.ReindexProgress) { last = p }); err != nil {
+		t.Fatalf("Reindex failed: %v", err)
+	}
+	if last.Failed != 1 {
+		t.Fatalf("Expected 1 failed blob, got %+v", last)
+	}
+	if len(reported) != 1 || reported[0] != broken {
+		t.Errorf("Expected OnIndexError for %s, got %v", broken, reported)
+	}
+
+	h, err = store.IndexHealth()
+	if err != nil {
+		t.Fatalf("IndexHealth failed: %v", err)
+	}
+	if h.Healthy() || len(h.Failed) != 1 || h.Failed[0].BlobID != broken || h.Blobs != 2 || h.Indexed != 1 {
+		t.Fatalf("Unexpected health after failed reindex: %+v", h)
+	}
+	if b, err := os.ReadFile(filepath.Join(store.Path(), "index.failed")); err != nil || !strings.Contains(string(b), broken) {
+		t.Errorf("Expected %s in index.failed, got %q (%v)", broken, b, err)
+	}
+
+	// Fix the blob and retry only what failed
+	if err := os.WriteFile(blobPath, good, 0o600); err != nil {
+		t.Fatalf("Failed to restore blob file: %v", err)
+	}
+	if err := store.RepairIndex(context.Background(), func(p gblobs.ReindexProgress) { last = p }); err != nil {
+		t.Fatalf("RepairIndex failed: %v", err)
+	}
+	if last.Total != 1 || last.Indexed != 1 || last.Failed != 0 {
+		t.Errorf("Unexpected repair progress: %+v", last)
+	}
+	h, err = store.IndexHealth()
+	if err != nil {
+		t.Fatalf("IndexHealth failed: %v", err)
+	}
+	if !h.Healthy() {
+		t.Errorf("Expected healthy index after repair, got %+v", h)
+	}
+	results, err := store.Search("beavers")
+	if err != nil || len(results) != 1 {
+		t.Errorf("Expected repaired blob to be searchable, got %d results (%v)", len(results), err)
+	}
+	if _, err := os.Stat(filepath.Join(store.Path(), "index.failed")); !os.IsNotExist(err) {
+		t.Error("Expected index.failed to be removed once nothing has failed")
+	}
+}
+
+// demoSearchStore loads the corpus of demo/search_demo.sh and returns blob IDs by file name.
+func demoSearchStore(t *testing.T) (*gblobs.LocalStore, map[string]string) {
+	t.Helper()
+	store := &gblobs.LocalStore{}
+	if err := store.CreateStore(t.TempDir()); err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	docs := []struct{ name, owner, text string }{
+		{"ml_doc.txt", "alice", "This is a document about machine learning and artificial intelligence. It discusses neural networks, deep learning, and data science applications."},
+		{"meeting.txt", "bob", "Meeting notes from the project review: We discussed the new search feature implementation using Bleve full-text search library."},
+		{"recipe.txt", "alice", "Recipe for chocolate cake: Mix flour, sugar, eggs, and chocolate. Bake for 45 minutes at 350 degrees."},
+		{"shopping.txt", "charlie", "Shopping list: bread, milk, eggs, chocolate, apples, bananas"},
+		{"status.txt", "bob", "Project status: search feature is implemented and working well"},
+	}
+	ids := map[string]string{}
+	for _, d := range docs {
+		meta := gblobs.BlobType{Name: d.name, Owner: d.owner, IngestionTime: time.Now().UTC()}
+		id, err := store.PutBlob([]byte(d.text), meta)
+		if err != nil {
+			t.Fatalf("Failed to put %s: %v", d.name, err)
+		}
+		ids[d.name] = id
+	}
+	return store, ids
+}
+
+func TestSearchModes(t *testing.T) {
+	store, _ := demoSearchStore(t)
+
+	tests := []struct {
+		name string
+		req  gblobs.SearchRequest
+		want []string
+	}{
+		{"Match", gblobs.SearchRequest{Mode: gblobs.SearchMatch, Query: "neural cake"}, []string{"ml_doc.txt", "recipe.txt"}},
+		{"Phrase", gblobs.SearchRequest{Mode: gblobs.SearchPhrase, Query: "chocolate cake"}, []string{"recipe.txt"}},
+		{"PhraseOrder", gblobs.SearchRequest{Mode: gblobs.SearchPhrase, Query: "cake chocolate"}, nil},
+		{"Fuzzy", gblobs.SearchRequest{Mode: gblobs.SearchFuzzy, Query: "chocolat"}, []string{"recipe.txt", "shopping.txt"}},
+		{"FuzzyTwoEdits", gblobs.SearchRequest{Mode: gblobs.SearchFuzzy, Query: "mxchinx", Fuzziness: 2}, []string{"ml_doc.txt"}},
+		{"FuzzyTooFar", gblobs.SearchRequest{Mode: gblobs.SearchFuzzy, Query: "mxchinx", Fuzziness: 1}, nil},
+		{"Prefix", gblobs.SearchRequest{Mode: gblobs.SearchPrefix, Query: "choc"}, []string{"recipe.txt", "shopping.txt"}},
+		{"PrefixName", gblobs.SearchRequest{Mode: gblobs.SearchPrefix, Query: "Meet", Field: "name"}, []string{"meeting.txt"}},
+		{"Wildcard", gblobs.SearchRequest{Mode: gblobs.SearchWildcard, Query: "implement*"}, []string{"meeting.txt", "status.txt"}},
+		{"WildcardOwner", gblobs.SearchRequest{Mode: gblobs.SearchWildcard, Query: "b?b", Field: "owner"}, []string{"meeting.txt", "status.txt"}},
+		{"Regexp", gblobs.SearchRequest{Mode: gblobs.SearchRegexp, Query: "(bread|flour)"}, []string{"recipe.txt", "shopping.txt"}},
+		{"RegexpField", gblobs.SearchRequest{Mode: gblobs.SearchRegexp, Query: "neura.*", Field: "content"}, []string{"ml_doc.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := store.SearchWithOptions(tt.req)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			var got []string
+			for _, r := range results {
+				got = append(got, r.Metadata.Name)
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		bad := []gblobs.SearchRequest{
+			{Mode: "soundex", Query: "chocolate"},
+			{Mode: gblobs.SearchFuzzy, Query: "chocolate", Fuzziness: 3},
+			{Query: "chocolate", Field: "name"},
+		}
+		for _, req := range bad {
+			if _, err := store.SearchWithOptions(req); err == nil {
+				t.Errorf("Expected error for %+v", req)
+			}
+		}
+	})
+}
+
+func TestSimilarTo(t *testing.T) {
+	store, ids := demoSearchStore(t)
+
+	// A near-duplicate of the recipe that differs by a few words
+	variant := "Recipe for chocolate cake: Mix flour, sugar, butter, eggs, and dark chocolate. Bake for 40 minutes at 350 degrees."
+	variantID, err := store.PutBlob([]byte(variant), gblobs.BlobType{Name: "recipe-v2.txt", Owner: "dave"})
+	if err != nil {
+		t.Fatalf("Failed to put blob: %v", err)
+	}
+
+	results, err := store.SimilarTo(ids["recipe.txt"], 3)
+	if err != nil {
+		t.Fatalf("SimilarTo failed: %v", err)
+	}
+	if len(results) == 0 || results[0].BlobID != variantID {
+		t.Fatalf("Expected the recipe variant as best match, got %+v", results)
+	}
+	for _, r := range results {
+		if r.BlobID == ids["recipe.txt"] {
+			t.Error("SimilarTo should not return the blob itself")
+		}
+	}
+	if len(results) > 1 && results[1].Score >= results[0].Score {
+		t.Errorf("Expected results ordered by score, got %v then %v", results[0].Score, results[1].Score)
+	}
+
+	if _, err := store.SimilarTo(strings.Repeat("0", 64), 3); err == nil {
+		t.Error("Expected error for a missing blob")
+	}
+}
+
+func TestNearDuplicates(t *testing.T) {
+	store := &gblobs.LocalStore{}
+	if err := store.CreateStore(t.TempDir()); err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	// Two reports that differ only in their timestamp and whitespace
+	var report strings.Builder
+	for i := 0; i < 80; i++ {
+		fmt.Fprintf(&report, "Line %d of the quarterly report covers item %d in region %d.\n", i, i*7, i%5)
+	}
+	reportA := "Generated 2024-01-05 10:00:00\n" + report.String()
+	reportB := "Generated   2024-02-11 17:45:13\n\n" + strings.ReplaceAll(report.String(), " of ", "  of ")
+
+	// Two photos that differ only in their header
+	photo := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(photo)
+	photoA := append([]byte("\xff\xd8\xff\xe1EXIF camera=A date=2024:01:05"), photo...)
+	photoB := append([]byte("\xff\xd8\xff\xe1EXIF camera=B date=2024:02:11 edited"), photo...)
+
+	put := func(name string, data []byte) string {
+		id, err := store.PutBlob(data, gblobs.BlobType{Name: name})
+		if err != nil {
+			t.Fatalf("Failed to put %s: %v", name, err)
+		}
+		return id
+	}
+	ra, rb := put("report-a.txt", []byte(reportA)), put("report-b.txt", []byte(reportB))
+	pa, pb := put("photo-a.jpg", photoA), put("photo-b.jpg", photoB)
+	put("other.txt", []byte("An unrelated note about gardening, tomatoes and the weather this spring."))
+
+	clusters, err := store.NearDuplicates(0.8)
+	if err != nil {
+		t.Fatalf("NearDuplicates failed: %v", err)
+	}
+	if len(clusters) != 2 {
+		t.Fatalf("Expected 2 clusters, got %d: %+v", len(clusters), clusters)
+	}
+	// Larger cluster first
+	want := [][]string{{pa, pb}, {ra, rb}}
+	for i, c := range clusters {
+		var got []string
+		var size int64
+		for _, b := range c.Blobs {
+			got = append(got, b.BlobID)
+			size += b.Metadata.Length
+			if b.Similarity < 0.8 {
+				t.Errorf("Blob %s in cluster %d has similarity %.2f", b.BlobID, i, b.Similarity)
+			}
+		}
+		sort.Strings(got)
+		sort.Strings(want[i])
+		if strings.Join(got, ",") != strings.Join(want[i], ",") {
+			t.Errorf("Cluster %d: expected %v, got %v", i, want[i], got)
+		}
+		if c.TotalSize != size {
+			t.Errorf("Cluster %d: TotalSize %d, expected %d", i, c.TotalSize, size)
+		}
+	}
+
+	if _, err := store.NearDuplicates(0); err == nil {
+		t.Error("Expected error for threshold 0")
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	store, _ := demoSearchStore(t)
+
+	suggestions, err := store.Suggest("Me", "", 5)
+	if err != nil {
+		t.Fatalf("Suggest failed: %v", err)
+	}
+	if len(suggestions) != 1 || suggestions[0].Term != "meeting.txt" || suggestions[0].Count != 1 {
+		t.Errorf("Expected meeting.txt for name prefix 'Me', got %+v", suggestions)
+	}
+
+	// Most frequent first: "chocolate" is in two documents, other "c" terms in one
+	suggestions, err = store.Suggest("c", "content", 3)
+	if err != nil {
+		t.Fatalf("Suggest failed: %v", err)
+	}
+	if len(suggestions) != 3 {
+		t.Fatalf("Expected 3 suggestions, got %+v", suggestions)
+	}
+	if suggestions[0].Term != "chocolate" || suggestions[0].Count != 2 {
+		t.Errorf("Expected chocolate (2) first, got %+v", suggestions[0])
+	}
+	if suggestions[1].Count < suggestions[2].Count {
+		t.Errorf("Expected suggestions ordered by count, got %+v", suggestions)
+	}
+
+	// Keyword fields match exactly
+	suggestions, err = store.Suggest("b", "owner", 5)
+	if err != nil {
+		t.Fatalf("Suggest failed: %v", err)
+	}
+	if len(suggestions) != 1 || suggestions[0].Term != "bob" || suggestions[0].Count != 2 {
+		t.Errorf("Expected bob (2) for owner prefix 'b', got %+v", suggestions)
+	}
+}
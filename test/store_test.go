@@ -1,7 +1,13 @@
 package test
 
 import (
+    "bytes"
+    "context"
+    "errors"
     "os"
+    "strconv"
+    "sync"
+    "sync/atomic"
     "testing"
     "time"
     "strings"
@@ -23,6 +29,7 @@ func quickStore(t *testing.T, encrypted bool) *gblobs.LocalStore {
     if err != nil {
         t.Fatalf("fail create store: %v", err)
     }
+    t.Cleanup(func() { store.Close() })
     return store
 }
 
@@ -126,3 +133,1284 @@ func TestStatsVariousLevels(t *testing.T) {
         t.Error("stats missing levels/avgs")
     }
 }
+
+func TestContextCancellation(t *testing.T) {
+    store := quickStore(t, false)
+    id, err := store.PutBlob([]byte("kept safe from cancelled calls"), gblobs.BlobType{Name: "keep.txt"})
+    if err != nil {
+        t.Fatalf("PutBlob failed: %v", err)
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+
+    newData := []byte("never stored")
+    if _, err := store.PutBlobContext(ctx, newData, gblobs.BlobType{Name: "never.txt"}); !errors.Is(err, context.Canceled) {
+        t.Errorf("PutBlobContext: expected context.Canceled, got %v", err)
+    }
+    if exists, _ := store.ExistsBlob(gblobs.GenerateBlobID(newData)); exists {
+        t.Error("Cancelled PutBlobContext left a blob behind")
+    }
+    if _, _, err := store.GetBlobContext(ctx, id); !errors.Is(err, context.Canceled) {
+        t.Errorf("GetBlobContext: expected context.Canceled, got %v", err)
+    }
+    if err := store.DeleteBlobContext(ctx, id); !errors.Is(err, context.Canceled) {
+        t.Errorf("DeleteBlobContext: expected context.Canceled, got %v", err)
+    }
+    if _, err := store.ExistsBlobContext(ctx, id); !errors.Is(err, context.Canceled) {
+        t.Errorf("ExistsBlobContext: expected context.Canceled, got %v", err)
+    }
+    if _, err := store.StatsContext(ctx); !errors.Is(err, context.Canceled) {
+        t.Errorf("StatsContext: expected context.Canceled, got %v", err)
+    }
+    if _, err := store.InspectStoreContext(ctx); !errors.Is(err, context.Canceled) {
+        t.Errorf("InspectStoreContext: expected context.Canceled, got %v", err)
+    }
+    if _, err := store.SearchContext(ctx, "safe"); !errors.Is(err, context.Canceled) {
+        t.Errorf("SearchContext: expected context.Canceled, got %v", err)
+    }
+    if err := store.PurgeStoreContext(ctx); !errors.Is(err, context.Canceled) {
+        t.Errorf("PurgeStoreContext: expected context.Canceled, got %v", err)
+    }
+
+    // Nothing was changed by the cancelled calls
+    data, _, err := store.GetBlob(id)
+    if err != nil || string(data) != "kept safe from cancelled calls" {
+        t.Errorf("Blob changed by cancelled calls: %q, %v", data, err)
+    }
+    results, err := store.SearchContext(context.Background(), "safe")
+    if err != nil || len(results) != 1 {
+        t.Errorf("Expected 1 search result with a live context, got %d (%v)", len(results), err)
+    }
+}
+
+func TestSentinelErrors(t *testing.T) {
+    dir := t.TempDir()
+    store := &gblobs.LocalStore{}
+    if err := store.CreateStore(dir, "pw1234"); err != nil {
+        t.Fatalf("fail create store: %v", err)
+    }
+    put := func(s string) (string, string) {
+        id, err := store.PutBlob([]byte(s), gblobs.BlobType{Name: s})
+        if err != nil {
+            t.Fatalf("PutBlob failed: %v", err)
+        }
+        return id, filepath.Join(dir, gblobs.BlobIDToPath(id))
+    }
+
+    missing := gblobs.GenerateBlobID([]byte("never stored"))
+    if _, _, err := store.GetBlob(missing); !errors.Is(err, gblobs.ErrNotFound) || !errors.Is(err, os.ErrNotExist) {
+        t.Errorf("GetBlob of missing blob: expected ErrNotFound wrapping os.ErrNotExist, got %v", err)
+    }
+    if err := store.DeleteBlob(missing); err != nil {
+        t.Errorf("DeleteBlob of missing blob should succeed, got %v", err)
+    }
+
+    // Hand a blob to a store with another key
+    id, path := put("readable only with the right key")
+    otherDir := t.TempDir()
+    other := &gblobs.LocalStore{}
+    if err := other.CreateStore(otherDir, "not the key"); err != nil {
+        t.Fatalf("fail create store: %v", err)
+    }
+    otherPath := filepath.Join(otherDir, gblobs.BlobIDToPath(id))
+    if err := gblobs.EnsureDir(filepath.Dir(otherPath)); err != nil {
+        t.Fatal(err)
+    }
+    for _, ext := range []string{"", ".meta"} {
+        b, err := os.ReadFile(path + ext)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if err := os.WriteFile(otherPath+ext, b, 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if _, _, err := other.GetBlob(id); !errors.Is(err, gblobs.ErrWrongKey) {
+        t.Errorf("GetBlob with wrong key: expected ErrWrongKey, got %v", err)
+    }
+
+    id, path = put("truncated")
+    if err := os.WriteFile(path, []byte("abc"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    if _, _, err := store.GetBlob(id); !errors.Is(err, gblobs.ErrCorrupt) {
+        t.Errorf("GetBlob of truncated blob: expected ErrCorrupt, got %v", err)
+    }
+
+    id, path = put("metadata lost")
+    if err := os.Remove(path + ".meta"); err != nil {
+        t.Fatal(err)
+    }
+    if _, _, err := store.GetBlob(id); !errors.Is(err, gblobs.ErrCorrupt) {
+        t.Errorf("GetBlob without metadata: expected ErrCorrupt, got %v", err)
+    }
+
+    closed := &gblobs.LocalStore{}
+    if _, _, err := closed.GetBlob(id); !errors.Is(err, gblobs.ErrStoreClosed) {
+        t.Errorf("GetBlob on unopened store: expected ErrStoreClosed, got %v", err)
+    }
+    if _, err := closed.Search("anything"); !errors.Is(err, gblobs.ErrStoreClosed) {
+        t.Errorf("Search on unopened store: expected ErrStoreClosed, got %v", err)
+    }
+    if err := closed.OpenStore(filepath.Join(dir, "nope")); !errors.Is(err, gblobs.ErrNotFound) {
+        t.Errorf("OpenStore of missing directory: expected ErrNotFound, got %v", err)
+    }
+    if !errors.Is(gblobs.ErrIndexOutdated, gblobs.ErrIndexUnavailable) {
+        t.Error("ErrIndexOutdated should match ErrIndexUnavailable")
+    }
+}
+
+func TestInvalidBlobIDs(t *testing.T) {
+    store := quickStore(t, false)
+    outside := filepath.Join(t.TempDir(), "victim")
+    if err := os.WriteFile(outside, []byte("keep me"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    for _, id := range []string{"", "abc", "../../../../../../../../" + outside, strings.Repeat("F", 64)} {
+        if _, _, err := store.GetBlob(id); !errors.Is(err, gblobs.ErrInvalidID) {
+            t.Errorf("GetBlob(%q): expected ErrInvalidID, got %v", id, err)
+        }
+        if _, err := store.ExistsBlob(id); !errors.Is(err, gblobs.ErrInvalidID) {
+            t.Errorf("ExistsBlob(%q): expected ErrInvalidID, got %v", id, err)
+        }
+        if err := store.DeleteBlob(id); !errors.Is(err, gblobs.ErrInvalidID) {
+            t.Errorf("DeleteBlob(%q): expected ErrInvalidID, got %v", id, err)
+        }
+        if _, err := store.SimilarTo(id, 3); !errors.Is(err, gblobs.ErrInvalidID) {
+            t.Errorf("SimilarTo(%q): expected ErrInvalidID, got %v", id, err)
+        }
+    }
+    if _, err := os.Stat(outside); err != nil {
+        t.Errorf("file outside the store was touched: %v", err)
+    }
+}
+
+func TestCloseAndReopen(t *testing.T) {
+    dir := t.TempDir()
+    store := &gblobs.LocalStore{}
+    if err := store.CreateStore(dir); err != nil {
+        t.Fatalf("fail create store: %v", err)
+    }
+    id, err := store.PutBlob([]byte("outlives the first handle"), gblobs.BlobType{Name: "survivor.txt"})
+    if err != nil {
+        t.Fatalf("PutBlob failed: %v", err)
+    }
+    if err := store.Close(); err != nil {
+        t.Fatalf("Close failed: %v", err)
+    }
+    if err := store.Close(); err != nil {
+        t.Errorf("second Close should do nothing, got %v", err)
+    }
+
+    if _, _, err := store.GetBlob(id); !errors.Is(err, gblobs.ErrStoreClosed) {
+        t.Errorf("GetBlob after Close: expected ErrStoreClosed, got %v", err)
+    }
+    if _, err := store.PutBlob([]byte("too late"), gblobs.BlobType{}); !errors.Is(err, gblobs.ErrStoreClosed) {
+        t.Errorf("PutBlob after Close: expected ErrStoreClosed, got %v", err)
+    }
+    if _, err := store.Search("outlives"); !errors.Is(err, gblobs.ErrStoreClosed) {
+        t.Errorf("Search after Close: expected ErrStoreClosed, got %v", err)
+    }
+    if err := store.Flush(); !errors.Is(err, gblobs.ErrStoreClosed) {
+        t.Errorf("Flush after Close: expected ErrStoreClosed, got %v", err)
+    }
+    if _, err := store.IndexHealth(); !errors.Is(err, gblobs.ErrStoreClosed) {
+        t.Errorf("IndexHealth after Close: expected ErrStoreClosed, got %v", err)
+    }
+
+    // The index lock was released, so the same path opens again in this process,
+    // both on the closed handle and, after that, on top of the open one
+    for i := 0; i < 2; i++ {
+        if err := store.OpenStore(dir); err != nil {
+            t.Fatalf("OpenStore #%d failed: %v", i+1, err)
+        }
+        results, err := store.Search("outlives")
+        if err != nil {
+            t.Fatalf("Search failed: %v", err)
+        }
+        if len(results) != 1 || results[0].BlobID != id {
+            t.Errorf("expected the blob to be found after reopening, got %v", results)
+        }
+    }
+    if err := store.Close(); err != nil {
+        t.Errorf("Close failed: %v", err)
+    }
+
+    // A failed open leaves the store closed
+    if err := store.OpenStore(filepath.Join(dir, "missing")); err == nil {
+        t.Fatal("OpenStore of a missing directory should fail")
+    }
+    if _, _, err := store.GetBlob(id); !errors.Is(err, gblobs.ErrStoreClosed) {
+        t.Errorf("GetBlob after failed OpenStore: expected ErrStoreClosed, got %v", err)
+    }
+}
+
+func TestStoreLocking(t *testing.T) {
+    dir := t.TempDir()
+    writer := &gblobs.LocalStore{}
+    if err := writer.CreateStore(dir); err != nil {
+        t.Fatalf("fail create store: %v", err)
+    }
+    defer writer.Close()
+    id, err := writer.PutBlob([]byte("shared between writer and reader"), gblobs.BlobType{Name: "shared.txt"})
+    if err != nil {
+        t.Fatalf("PutBlob failed: %v", err)
+    }
+    if err := writer.Flush(); err != nil {
+        t.Fatalf("Flush failed: %v", err)
+    }
+
+    second := &gblobs.LocalStore{}
+    if err := second.OpenStore(dir); !errors.Is(err, gblobs.ErrStoreLocked) {
+        t.Fatalf("second writer: expected ErrStoreLocked, got %v", err)
+    }
+    if err := (&gblobs.LocalStore{ReadOnly: true}).CreateStore(t.TempDir()); !errors.Is(err, gblobs.ErrReadOnly) {
+        t.Errorf("CreateStore with ReadOnly: expected ErrReadOnly, got %v", err)
+    }
+
+    reader := &gblobs.LocalStore{ReadOnly: true}
+    if err := reader.OpenStore(dir); err != nil {
+        t.Fatalf("read-only OpenStore next to a writer failed: %v", err)
+    }
+    defer reader.Close()
+    if data, _, err := reader.GetBlob(id); err != nil || string(data) != "shared between writer and reader" {
+        t.Errorf("reader GetBlob: got %q, %v", data, err)
+    }
+    if _, err := reader.PutBlob([]byte("nope"), gblobs.BlobType{}); !errors.Is(err, gblobs.ErrReadOnly) {
+        t.Errorf("reader PutBlob: expected ErrReadOnly, got %v", err)
+    }
+    if err := reader.DeleteBlob(id); !errors.Is(err, gblobs.ErrReadOnly) {
+        t.Errorf("reader DeleteBlob: expected ErrReadOnly, got %v", err)
+    }
+    if _, err := reader.Search("shared"); !errors.Is(err, gblobs.ErrIndexInUse) || !errors.Is(err, gblobs.ErrIndexUnavailable) {
+        t.Errorf("reader Search while the writer holds the index: expected ErrIndexInUse, got %v", err)
+    }
+    if _, err := reader.SimilarTo(id, 5); !errors.Is(err, gblobs.ErrIndexInUse) {
+        t.Errorf("reader SimilarTo while the writer holds the index: expected ErrIndexInUse, got %v", err)
+    }
+    if err := writer.PurgeStore(); !errors.Is(err, gblobs.ErrStoreLocked) {
+        t.Errorf("PurgeStore with a reader open: expected ErrStoreLocked, got %v", err)
+    }
+    if exists, _ := writer.ExistsBlob(id); !exists {
+        t.Fatal("refused PurgeStore removed the blob")
+    }
+
+    // Once the writer is gone the reader can search, and a new writer can
+    // open the store while the reader stays open
+    if err := writer.Close(); err != nil {
+        t.Fatalf("Close failed: %v", err)
+    }
+    results, err := reader.Search("shared")
+    if err != nil {
+        t.Fatalf("reader Search failed: %v", err)
+    }
+    if len(results) != 1 || results[0].BlobID != id {
+        t.Errorf("reader Search: expected the blob, got %v", results)
+    }
+    if err := writer.OpenStore(dir); err != nil {
+        t.Fatalf("writer OpenStore next to a reader failed: %v", err)
+    }
+    if _, err := writer.PutBlob([]byte("written after the reader opened"), gblobs.BlobType{}); err != nil {
+        t.Errorf("PutBlob failed: %v", err)
+    }
+
+    if err := reader.Close(); err != nil {
+        t.Fatalf("reader Close failed: %v", err)
+    }
+    if err := writer.PurgeStore(); err != nil {
+        t.Errorf("PurgeStore failed: %v", err)
+    }
+    if exists, _ := writer.ExistsBlob(id); exists {
+        t.Error("PurgeStore kept the blob")
+    }
+}
+
+func TestConcurrentPutGetDelete(t *testing.T) {
+    store := quickStore(t, true)
+    var wg sync.WaitGroup
+    ids := make([]string, 16)
+    for g := 0; g < 16; g++ {
+        wg.Add(1)
+        go func(g int) {
+            defer wg.Done()
+            // Every goroutine also stores the shared blob, which is deduplicated
+            if _, err := store.PutBlob([]byte("the same for everyone"), gblobs.BlobType{Name: "shared.txt"}); err != nil {
+                t.Errorf("PutBlob shared failed: %v", err)
+            }
+            data := []byte("blob number " + strconv.Itoa(g))
+            id, err := store.PutBlob(data, gblobs.BlobType{Name: "own.txt"})
+            if err != nil {
+                t.Errorf("PutBlob failed: %v", err)
+                return
+            }
+            ids[g] = id
+            got, _, err := store.GetBlob(id)
+            if err != nil || !bytes.Equal(got, data) {
+                t.Errorf("GetBlob: got %q, %v", got, err)
+            }
+            if g%2 == 0 {
+                if err := store.DeleteBlob(id); err != nil {
+                    t.Errorf("DeleteBlob failed: %v", err)
+                }
+            }
+        }(g)
+    }
+    wg.Wait()
+
+    for g, id := range ids {
+        exists, err := store.ExistsBlob(id)
+        if err != nil || exists != (g%2 == 1) {
+            t.Errorf("blob %d: exists=%v, err=%v", g, exists, err)
+        }
+    }
+    shared := gblobs.GenerateBlobID([]byte("the same for everyone"))
+    if got, _, err := store.GetBlob(shared); err != nil || string(got) != "the same for everyone" {
+        t.Errorf("shared blob: got %q, %v", got, err)
+    }
+    stats, err := store.Stats()
+    if err != nil {
+        t.Fatalf("Stats failed: %v", err)
+    }
+    if stats.TotalBlobCount != 9 {
+        t.Errorf("expected 9 blobs, got %d", stats.TotalBlobCount)
+    }
+}
+
+func TestBatchOperations(t *testing.T) {
+    store := quickStore(t, true)
+    store.BatchWorkers = 4
+    var items []gblobs.PutItem
+    for i := 0; i < 20; i++ {
+        items = append(items, gblobs.PutItem{
+            Data: []byte("batch blob " + strconv.Itoa(i%10)),
+            Meta: gblobs.BlobType{Name: "batch" + strconv.Itoa(i) + ".txt"},
+        })
+    }
+    puts, err := store.PutBlobs(items)
+    if err != nil {
+        t.Fatalf("PutBlobs failed: %v", err)
+    }
+    if len(puts) != len(items) {
+        t.Fatalf("expected %d results, got %d", len(items), len(puts))
+    }
+    deduplicated := 0
+    for i, r := range puts {
+        if r.Err != nil {
+            t.Fatalf("item %d: %v", i, r.Err)
+        }
+        if r.BlobID != gblobs.GenerateBlobID(items[i].Data) {
+            t.Errorf("item %d: wrong blob id %s", i, r.BlobID)
+        }
+        if r.Deduplicated {
+            deduplicated++
+        }
+    }
+    // Every content is in the batch twice; one of each pair is stored
+    if deduplicated != 10 {
+        t.Errorf("expected 10 deduplicated items, got %d", deduplicated)
+    }
+    if err := store.Flush(); err != nil {
+        t.Fatalf("Flush failed: %v", err)
+    }
+    hits, err := store.SearchWithOptions(gblobs.SearchRequest{Query: "batch", Mode: gblobs.SearchMatch, Limit: 50})
+    if err != nil || len(hits) != 10 {
+        t.Errorf("expected 10 indexed blobs, got %d, %v", len(hits), err)
+    }
+
+    ids := []string{puts[0].BlobID, "not-an-id", gblobs.GenerateBlobID([]byte("never stored")), puts[1].BlobID}
+    gets, err := store.GetBlobs(ids)
+    if err != nil {
+        t.Fatalf("GetBlobs failed: %v", err)
+    }
+    if gets[0].Err != nil || !bytes.Equal(gets[0].Data, items[0].Data) {
+        t.Errorf("get 0: got %q, %v", gets[0].Data, gets[0].Err)
+    }
+    if !errors.Is(gets[1].Err, gblobs.ErrInvalidID) {
+        t.Errorf("get 1: expected ErrInvalidID, got %v", gets[1].Err)
+    }
+    if !errors.Is(gets[2].Err, gblobs.ErrNotFound) {
+        t.Errorf("get 2: expected ErrNotFound, got %v", gets[2].Err)
+    }
+    if gets[3].Err != nil || gets[3].BlobID != ids[3] {
+        t.Errorf("get 3: got %s, %v", gets[3].BlobID, gets[3].Err)
+    }
+
+    dels, err := store.DeleteBlobs(ids)
+    if err != nil {
+        t.Fatalf("DeleteBlobs failed: %v", err)
+    }
+    for i, r := range dels {
+        if (r.Err != nil) != (i == 1) {
+            t.Errorf("delete %d: %v", i, r.Err)
+        }
+    }
+    if exists, _ := store.ExistsBlob(ids[0]); exists {
+        t.Error("blob still exists after DeleteBlobs")
+    }
+    if err := store.Flush(); err != nil {
+        t.Fatalf("Flush failed: %v", err)
+    }
+    if hits, _ := store.SearchWithOptions(gblobs.SearchRequest{Query: "batch", Mode: gblobs.SearchMatch, Limit: 50}); len(hits) != 8 {
+        t.Errorf("expected 8 indexed blobs after delete, got %d", len(hits))
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    puts, err = store.PutBlobsContext(ctx, items)
+    if !errors.Is(err, context.Canceled) {
+        t.Errorf("expected context.Canceled, got %v", err)
+    }
+    for i, r := range puts {
+        if !errors.Is(r.Err, context.Canceled) {
+            t.Errorf("item %d: expected context.Canceled, got %v", i, r.Err)
+        }
+    }
+}
+
+func TestDeleteReportsRemovalFailure(t *testing.T) {
+    store := quickStore(t, false)
+    id, err := store.PutBlob([]byte("stubborn walnut"), gblobs.BlobType{Name: "walnut.txt"})
+    if err != nil {
+        t.Fatalf("PutBlob failed: %v", err)
+    }
+    // A non-empty directory in place of the blob file cannot be removed, even by root
+    blobPath := filepath.Join(store.Path(), gblobs.BlobIDToPath(id))
+    if err := os.Remove(blobPath); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.MkdirAll(filepath.Join(blobPath, "pinned"), 0o755); err != nil {
+        t.Fatal(err)
+    }
+    if err := store.DeleteBlob(id); err == nil {
+        t.Error("DeleteBlob: expected the removal failure")
+    }
+    results, err := store.DeleteBlobs([]string{id})
+    if err != nil || len(results) != 1 || results[0].Err == nil {
+        t.Errorf("DeleteBlobs: expected the removal failure in the result, got %+v, %v", results, err)
+    }
+    found, err := store.Search("walnut")
+    if err != nil {
+        t.Fatalf("Search failed: %v", err)
+    }
+    if len(found) != 1 || found[0].BlobID != id {
+        t.Errorf("a blob that could not be removed should stay in the index, got %+v", found)
+    }
+}
+
+func TestPutDir(t *testing.T) {
+    store := quickStore(t, false)
+    root := t.TempDir()
+    files := map[string]string{
+        "a.txt":           "alpha",
+        "sub/b.txt":       "beta",
+        "sub/copy.txt":    "alpha",
+        "sub/notes.md":    "notes",
+        "build/out.txt":   "generated",
+    }
+    for name, content := range files {
+        p := filepath.Join(root, filepath.FromSlash(name))
+        if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+            t.Fatal(err)
+        }
+        if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := os.Symlink("a.txt", filepath.Join(root, "link.txt")); err != nil {
+        t.Skipf("symlinks not supported: %v", err)
+    }
+    opts := gblobs.PutDirOptions{Include: []string{"*.txt"}, Exclude: []string{"build"}, DryRun: true}
+
+    dry, err := store.PutDir(root, opts)
+    if err != nil {
+        t.Fatalf("PutDir dry run failed: %v", err)
+    }
+    if dry.New != 2 || dry.Deduplicated != 1 || dry.Skipped != 2 || dry.SavedBytes != 5 {
+        t.Errorf("dry run: unexpected summary %+v", dry)
+    }
+    if stats, _ := store.Stats(); stats.TotalBlobCount != 0 {
+        t.Errorf("dry run stored %d blobs", stats.TotalBlobCount)
+    }
+
+    opts.DryRun = false
+    res, err := store.PutDir(root, opts)
+    if err != nil {
+        t.Fatalf("PutDir failed: %v", err)
+    }
+    if res.New != 2 || res.Deduplicated != 1 || res.Failed != 0 || res.NewBytes != 9 {
+        t.Errorf("unexpected summary %+v", res)
+    }
+    for _, f := range res.Files {
+        if f.Path == "build/out.txt" || f.Path == "link.txt" || f.Path == "sub/notes.md" {
+            t.Errorf("%s should have been skipped", f.Path)
+        }
+    }
+    _, meta, err := store.GetBlob(gblobs.GenerateBlobID([]byte("beta")))
+    if err != nil {
+        t.Fatalf("GetBlob failed: %v", err)
+    }
+    if meta.Name != "sub/b.txt" || !strings.HasPrefix(meta.URI, "file://") || !strings.HasSuffix(meta.URI, "/sub/b.txt") {
+        t.Errorf("unexpected name %q or URI %q", meta.Name, meta.URI)
+    }
+
+    // Everything is stored now; following the symlink adds one more duplicate
+    opts.FollowSymlinks = true
+    res, err = store.PutDir(root, opts)
+    if err != nil {
+        t.Fatalf("PutDir again failed: %v", err)
+    }
+    if res.New != 0 || res.Deduplicated != 4 {
+        t.Errorf("second run: unexpected summary %+v", res)
+    }
+
+    if _, err := store.PutDir(root, gblobs.PutDirOptions{Include: []string{"["}}); err == nil {
+        t.Error("expected an error for a malformed glob")
+    }
+}
+
+func TestSnapshotAndRestore(t *testing.T) {
+    store := quickStore(t, true)
+    src := t.TempDir()
+    old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+    mustWrite := func(name, content string, perm os.FileMode) {
+        p := filepath.Join(src, filepath.FromSlash(name))
+        if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+            t.Fatal(err)
+        }
+        if err := os.WriteFile(p, []byte(content), perm); err != nil {
+            t.Fatal(err)
+        }
+        if err := os.Chmod(p, perm); err != nil {
+            t.Fatal(err)
+        }
+        if err := os.Chtimes(p, old, old); err != nil {
+            t.Fatal(err)
+        }
+    }
+    mustWrite("run.sh", "#!/bin/sh\necho hi\n", 0o755)
+    mustWrite("docs/readme.txt", "read me", 0o644)
+    mustWrite("docs/copy.txt", "read me", 0o600)
+    if err := os.Mkdir(filepath.Join(src, "empty"), 0o700); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.Symlink("docs/readme.txt", filepath.Join(src, "link")); err != nil {
+        t.Skipf("symlinks not supported: %v", err)
+    }
+    for _, dir := range []string{"docs", "empty"} {
+        if err := os.Chtimes(filepath.Join(src, dir), old, old); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    dry, err := store.PutDir(src, gblobs.PutDirOptions{Snapshot: true, DryRun: true})
+    if err != nil {
+        t.Fatalf("dry run failed: %v", err)
+    }
+    res, err := store.PutDir(src, gblobs.PutDirOptions{Snapshot: true})
+    if err != nil {
+        t.Fatalf("PutDir failed: %v", err)
+    }
+    if res.TreeID == "" || res.TreeID != dry.TreeID {
+        t.Fatalf("tree ids differ: %q, dry run %q", res.TreeID, dry.TreeID)
+    }
+    // Same content, modes and times: same tree
+    again, err := store.PutDir(src, gblobs.PutDirOptions{Snapshot: true})
+    if err != nil || again.TreeID != res.TreeID {
+        t.Errorf("snapshot not reproducible: %q, %v", again.TreeID, err)
+    }
+
+    tree, err := store.GetTree(res.TreeID)
+    if err != nil {
+        t.Fatalf("GetTree failed: %v", err)
+    }
+    var names []string
+    for _, e := range tree.Entries {
+        names = append(names, e.Name)
+    }
+    if strings.Join(names, ",") != "docs,empty,link,run.sh" {
+        t.Errorf("unexpected root entries %v", names)
+    }
+
+    dest := filepath.Join(t.TempDir(), "restored")
+    if err := store.RestoreTree(res.TreeID, dest); err != nil {
+        t.Fatalf("RestoreTree failed: %v", err)
+    }
+    for _, name := range []string{"run.sh", "docs/readme.txt", "docs/copy.txt", "docs", "empty"} {
+        want, err := os.Lstat(filepath.Join(src, filepath.FromSlash(name)))
+        if err != nil {
+            t.Fatal(err)
+        }
+        got, err := os.Lstat(filepath.Join(dest, filepath.FromSlash(name)))
+        if err != nil {
+            t.Errorf("%s not restored: %v", name, err)
+            continue
+        }
+        if got.Mode() != want.Mode() || !got.ModTime().Equal(want.ModTime()) || (!want.IsDir() && got.Size() != want.Size()) {
+            t.Errorf("%s: restored as %v %v %d, want %v %v %d", name, got.Mode(), got.ModTime(), got.Size(), want.Mode(), want.ModTime(), want.Size())
+        }
+    }
+    if target, err := os.Readlink(filepath.Join(dest, "link")); err != nil || target != "docs/readme.txt" {
+        t.Errorf("symlink restored as %q, %v", target, err)
+    }
+    if data, _ := os.ReadFile(filepath.Join(dest, "run.sh")); string(data) != "#!/bin/sh\necho hi\n" {
+        t.Errorf("unexpected content %q", data)
+    }
+
+    if err := store.RestoreTree(res.TreeID, dest); !errors.Is(err, os.ErrExist) {
+        t.Errorf("expected an error restoring into a non-empty directory, got %v", err)
+    }
+    if _, err := store.GetTree(gblobs.GenerateBlobID([]byte("read me"))); !errors.Is(err, gblobs.ErrNotTree) {
+        t.Errorf("expected ErrNotTree, got %v", err)
+    }
+    bad := gblobs.Tree{Entries: []gblobs.TreeEntry{{Name: "../escape", BlobID: res.TreeID}}}
+    if _, err := store.PutTree(bad, gblobs.BlobType{}); err == nil {
+        t.Error("expected PutTree to reject an entry outside the directory")
+    }
+}
+
+func TestRestoreRejectsCraftedTrees(t *testing.T) {
+    store := quickStore(t, false)
+    fileID, err := store.PutBlob([]byte("planted"), gblobs.BlobType{Name: "planted.txt"})
+    if err != nil {
+        t.Fatalf("PutBlob failed: %v", err)
+    }
+    outside := filepath.Join(t.TempDir(), "victim.txt")
+    for name, entries := range map[string]string{
+        "duplicate": `{"name":"x","mode":134218239,"mtime":"2020-01-01T00:00:00Z","target":"` + outside + `"},` +
+            `{"name":"x","mode":420,"mtime":"2020-01-01T00:00:00Z","blob":"` + fileID + `"}`,
+        "unsorted": `{"name":"b","mode":420,"mtime":"2020-01-01T00:00:00Z","blob":"` + fileID + `"},` +
+            `{"name":"a","mode":420,"mtime":"2020-01-01T00:00:00Z","blob":"` + fileID + `"}`,
+    } {
+        // Stored as is, bypassing PutTree's checks
+        treeID, err := store.PutBlob([]byte(`{"entries":[`+entries+`]}`), gblobs.BlobType{MIMEType: gblobs.TreeMIMEType})
+        if err != nil {
+            t.Fatalf("%s: PutBlob failed: %v", name, err)
+        }
+        if _, err := store.GetTree(treeID); !errors.Is(err, gblobs.ErrCorrupt) {
+            t.Errorf("%s: GetTree: expected ErrCorrupt, got %v", name, err)
+        }
+        if err := store.RestoreTree(treeID, filepath.Join(t.TempDir(), "restored")); !errors.Is(err, gblobs.ErrCorrupt) {
+            t.Errorf("%s: RestoreTree: expected ErrCorrupt, got %v", name, err)
+        }
+    }
+    if _, err := os.Lstat(outside); !errors.Is(err, os.ErrNotExist) {
+        t.Errorf("restore wrote outside its destination: %v", err)
+    }
+}
+
+func TestDiffTrees(t *testing.T) {
+    store := quickStore(t, false)
+    blob := func(content string) string {
+        id, err := store.PutBlob([]byte(content), gblobs.BlobType{Name: content})
+        if err != nil {
+            t.Fatalf("PutBlob failed: %v", err)
+        }
+        return id
+    }
+    tree := func(entries ...gblobs.TreeEntry) string {
+        id, err := store.PutTree(gblobs.Tree{Entries: entries}, gblobs.BlobType{})
+        if err != nil {
+            t.Fatalf("PutTree failed: %v", err)
+        }
+        return id
+    }
+    file := func(name, content string) gblobs.TreeEntry {
+        return gblobs.TreeEntry{Name: name, Mode: 0o644, BlobID: blob(content)}
+    }
+    dir := func(name, treeID string) gblobs.TreeEntry {
+        return gblobs.TreeEntry{Name: name, Mode: os.ModeDir | 0o755, TreeID: treeID}
+    }
+    exe := file("run", "script")
+    exe.Mode = 0o755
+
+    shared := tree(file("same.txt", "unchanged"))
+    oldTree := tree(
+        dir("shared", shared),
+        dir("old", tree(file("logo.png", "png data"))),
+        file("app.js", "v1"),
+        file("run", "script"),
+        file("gone.txt", "bye"),
+        dir("lib", tree(file("x.go", "x"))),
+    )
+    newTree := tree(
+        dir("shared", shared),
+        dir("assets", tree(file("logo.png", "png data"))),
+        file("app.js", "v2"),
+        exe,
+        file("new.txt", "hello"),
+        file("lib", "now a file"),
+    )
+
+    changes, err := store.DiffTrees(oldTree, newTree)
+    if err != nil {
+        t.Fatalf("DiffTrees failed: %v", err)
+    }
+    var got []string
+    for _, c := range changes {
+        line := string(c.Kind) + " " + c.Path
+        if c.OldPath != "" {
+            line += " from " + c.OldPath
+        }
+        got = append(got, line)
+    }
+    want := []string{
+        "modified app.js",
+        "renamed assets/logo.png from old/logo.png",
+        "removed gone.txt",
+        "added lib",
+        "removed lib/x.go",
+        "added new.txt",
+        "modified run",
+    }
+    if strings.Join(got, "\n") != strings.Join(want, "\n") {
+        t.Errorf("unexpected changes:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+    }
+
+    if changes, err := store.DiffTrees(oldTree, oldTree); err != nil || len(changes) != 0 {
+        t.Errorf("expected no changes, got %v, %v", changes, err)
+    }
+    if _, err := store.DiffTrees(oldTree, blob("not a tree")); !errors.Is(err, gblobs.ErrNotTree) {
+        t.Errorf("expected ErrNotTree, got %v", err)
+    }
+}
+
+func TestRefs(t *testing.T) {
+    store := quickStore(t, false)
+    v1, _ := store.PutBlob([]byte("config v1"), gblobs.BlobType{Name: "config"})
+    v2, _ := store.PutBlob([]byte("config v2"), gblobs.BlobType{Name: "config"})
+
+    if err := store.SetRef("latest-config", v1); err != nil {
+        t.Fatalf("SetRef failed: %v", err)
+    }
+    if id, err := store.GetRef("refs/latest-config"); err != nil || id != v1 {
+        t.Errorf("GetRef: got %q, %v", id, err)
+    }
+    if data, _, err := store.GetBlob("latest-config"); err != nil || string(data) != "config v1" {
+        t.Errorf("GetBlob by ref: got %q, %v", data, err)
+    }
+
+    // Compare-and-swap
+    // GetBlobs takes ref names like GetBlob, and reports the IDs they resolve to
+    gets, err := store.GetBlobs([]string{"latest-config", v2, "no-such-ref"})
+    if err != nil {
+        t.Fatalf("GetBlobs failed: %v", err)
+    }
+    if gets[0].Err != nil || gets[0].BlobID != v1 || string(gets[0].Data) != "config v1" {
+        t.Errorf("GetBlobs by ref = %q, %q, %v", gets[0].BlobID, gets[0].Data, gets[0].Err)
+    }
+    if gets[1].Err != nil || string(gets[1].Data) != "config v2" {
+        t.Errorf("GetBlobs by ID = %q, %v", gets[1].Data, gets[1].Err)
+    }
+    if !errors.Is(gets[2].Err, gblobs.ErrInvalidID) || !errors.Is(gets[2].Err, gblobs.ErrNotFound) {
+        t.Errorf("GetBlobs of a missing ref = %v, want ErrInvalidID and ErrNotFound", gets[2].Err)
+    }
+    if err := store.UpdateRef("latest-config", v2, v2); !errors.Is(err, gblobs.ErrRefConflict) {
+        t.Errorf("expected ErrRefConflict, got %v", err)
+    }
+    if err := store.UpdateRef("latest-config", v1, v2); err != nil {
+        t.Errorf("UpdateRef failed: %v", err)
+    }
+    if err := store.UpdateRef("release/1.2", "", v1); err != nil {
+        t.Errorf("UpdateRef create failed: %v", err)
+    }
+    if err := store.UpdateRef("release/1.2", "", v2); !errors.Is(err, gblobs.ErrRefConflict) {
+        t.Errorf("expected ErrRefConflict creating an existing ref, got %v", err)
+    }
+    if err := store.SetRef("release", v1); !errors.Is(err, gblobs.ErrRefConflict) {
+        t.Errorf("expected ErrRefConflict for a ref over other refs, got %v", err)
+    }
+    if err := store.SetRef("missing", gblobs.GenerateBlobID([]byte("never stored"))); !errors.Is(err, gblobs.ErrNotFound) {
+        t.Errorf("expected ErrNotFound for a ref to a missing blob, got %v", err)
+    }
+    for _, name := range []string{"", "a//b", "../x", ".hidden", "x.tmp", "x.blob", v1, "with space"} {
+        if err := store.SetRef(name, v1); !errors.Is(err, gblobs.ErrInvalidRef) || !errors.Is(err, gblobs.ErrInvalidID) {
+            t.Errorf("SetRef(%q): expected ErrInvalidRef, got %v", name, err)
+        }
+    }
+
+    refs, err := store.ListRefs("")
+    if err != nil || len(refs) != 2 || refs[0].Name != "latest-config" || refs[1] != (gblobs.Ref{Name: "release/1.2", ID: v1}) {
+        t.Errorf("ListRefs: got %v, %v", refs, err)
+    }
+    if refs, _ := store.ListRefs("release/"); len(refs) != 1 {
+        t.Errorf("ListRefs with prefix: got %v", refs)
+    }
+
+    if err := store.DeleteRef("release/1.2"); err != nil {
+        t.Errorf("DeleteRef failed: %v", err)
+    }
+    if _, err := store.GetRef("release/1.2"); !errors.Is(err, gblobs.ErrNotFound) {
+        t.Errorf("expected ErrNotFound after delete, got %v", err)
+    }
+    if err := store.DeleteRef("release/1.2"); !errors.Is(err, gblobs.ErrNotFound) {
+        t.Errorf("expected ErrNotFound deleting again, got %v", err)
+    }
+    // The directory of the deleted ref is gone, so the name is free again
+    if err := store.SetRef("release", v1); err != nil {
+        t.Errorf("SetRef after delete failed: %v", err)
+    }
+
+    log, err := store.RefLog("latest-config")
+    if err != nil || len(log) != 2 || log[0].Old != "" || log[0].New != v1 || log[1].Old != v1 || log[1].New != v2 {
+        t.Errorf("RefLog: got %+v, %v", log, err)
+    }
+}
+
+func TestRefCompareAndSwapRace(t *testing.T) {
+    store := quickStore(t, false)
+    ids := make([]string, 9)
+    for i := range ids {
+        ids[i], _ = store.PutBlob([]byte("version "+strconv.Itoa(i)), gblobs.BlobType{})
+    }
+    if err := store.SetRef("counter", ids[0]); err != nil {
+        t.Fatal(err)
+    }
+    // Every goroutine tries to advance the ref from each version; exactly one wins each step
+    var wins atomic.Int32
+    var wg sync.WaitGroup
+    for g := 0; g < 8; g++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := 0; i < len(ids)-1; i++ {
+                if store.UpdateRef("counter", ids[i], ids[i+1]) == nil {
+                    wins.Add(1)
+                }
+            }
+        }()
+    }
+    wg.Wait()
+    if got, _ := store.GetRef("counter"); got != ids[len(ids)-1] {
+        t.Errorf("ref did not end at the last version")
+    }
+    if wins.Load() != int32(len(ids)-1) {
+        t.Errorf("expected %d successful updates, got %d", len(ids)-1, wins.Load())
+    }
+}
+
+func TestVersionedStore(t *testing.T) {
+    store := quickStore(t, false)
+    vs := &gblobs.VersionedStore{Store: store}
+    const uri = "file:///home/alice/report.docx"
+
+    if _, _, err := vs.GetLatest(uri); !errors.Is(err, gblobs.ErrNotFound) {
+        t.Errorf("expected ErrNotFound before the first version, got %v", err)
+    }
+    for i, content := range []string{"draft", "second draft", "second draft", "final"} {
+        if _, err := vs.PutVersion(uri, []byte(content)); err != nil {
+            t.Fatalf("PutVersion %d failed: %v", i, err)
+        }
+    }
+    versions, err := vs.ListVersions(uri)
+    if err != nil {
+        t.Fatalf("ListVersions failed: %v", err)
+    }
+    // The unchanged save did not add a version
+    if len(versions) != 3 || versions[0].Number != 1 || versions[2].Number != 3 {
+        t.Fatalf("unexpected versions %+v", versions)
+    }
+    if data, v, err := vs.GetVersion(uri, 2); err != nil || string(data) != "second draft" || v.Size != 12 {
+        t.Errorf("GetVersion(2): got %q, %+v, %v", data, v, err)
+    }
+    if data, v, err := vs.GetLatest(uri); err != nil || string(data) != "final" || v.Number != 3 {
+        t.Errorf("GetLatest: got %q, %+v, %v", data, v, err)
+    }
+    if _, meta, _ := store.GetBlob(versions[0].BlobID); meta.Name != "report.docx" || meta.URI != uri {
+        t.Errorf("unexpected metadata %+v", meta)
+    }
+
+    // Retention
+    vs.KeepVersions = 2
+    vs.DeletePruned = true
+    if _, err := vs.PutVersion(uri, []byte("final, really")); err != nil {
+        t.Fatalf("PutVersion failed: %v", err)
+    }
+    versions, _ = vs.ListVersions(uri)
+    if len(versions) != 2 || versions[0].Number != 3 || versions[1].Number != 4 {
+        t.Errorf("unexpected versions after pruning %+v", versions)
+    }
+    if _, _, err := vs.GetVersion(uri, 1); !errors.Is(err, gblobs.ErrNotFound) {
+        t.Errorf("expected ErrNotFound for a pruned version, got %v", err)
+    }
+    if exists, _ := store.ExistsBlob(gblobs.GenerateBlobID([]byte("second draft"))); exists {
+        t.Error("blob of a pruned version was not deleted")
+    }
+    // Only the current history blob is left besides the two versions
+    if stats, _ := store.Stats(); stats.TotalBlobCount != 3 {
+        t.Errorf("expected 3 blobs, got %d", stats.TotalBlobCount)
+    }
+}
+
+func TestVersionedStoreConcurrentWriters(t *testing.T) {
+    store := quickStore(t, false)
+    vs := &gblobs.VersionedStore{Store: store}
+    var wg sync.WaitGroup
+    for g := 0; g < 8; g++ {
+        wg.Add(1)
+        go func(g int) {
+            defer wg.Done()
+            for i := 0; i < 3; i++ {
+                if _, err := vs.PutVersion("shared.txt", []byte(strconv.Itoa(g)+"/"+strconv.Itoa(i))); err != nil {
+                    t.Errorf("PutVersion failed: %v", err)
+                }
+            }
+        }(g)
+    }
+    wg.Wait()
+    versions, err := vs.ListVersions("shared.txt")
+    if err != nil {
+        t.Fatalf("ListVersions failed: %v", err)
+    }
+    if len(versions) != 24 {
+        t.Fatalf("expected 24 versions, got %d", len(versions))
+    }
+    for i, v := range versions {
+        if v.Number != i+1 {
+            t.Errorf("version %d numbered %d", i, v.Number)
+        }
+    }
+}
+
+func TestVersionHistoriesHiddenAndKept(t *testing.T) {
+    store := quickStore(t, false)
+    vs := &gblobs.VersionedStore{Store: store}
+    const uri = "file:///home/alice/notes.txt"
+    for _, content := range []string{"lemon draft", "lemon final"} {
+        if _, err := vs.PutVersion(uri, []byte(content)); err != nil {
+            t.Fatalf("PutVersion failed: %v", err)
+        }
+    }
+    if err := store.SetRetentionRules([]gblobs.RetentionRule{{MIMEType: "application/*", MaxAge: time.Nanosecond}}); err != nil {
+        t.Fatalf("SetRetentionRules failed: %v", err)
+    }
+    if err := store.Flush(); err != nil {
+        t.Fatalf("Flush failed: %v", err)
+    }
+    // "versions" only occurs in the JSON of the history
+    if results, err := store.Search("versions"); err != nil || len(results) != 0 {
+        t.Errorf("Search found the version history: %+v, %v", results, err)
+    }
+    if results, err := store.Search("lemon"); err != nil || len(results) != 2 {
+        t.Errorf("Search: expected both versions, got %+v, %v", results, err)
+    }
+    if h, err := store.IndexHealth(); err != nil || !h.Healthy() {
+        t.Errorf("expected a healthy index, got %+v, %v", h, err)
+    }
+    if expired, err := store.Expire(false); err != nil || len(expired) != 0 {
+        t.Errorf("Expire: expected the history to be kept, got %+v, %v", expired, err)
+    }
+    if data, _, err := vs.GetLatest(uri); err != nil || string(data) != "lemon final" {
+        t.Errorf("GetLatest after Expire: got %q, %v", data, err)
+    }
+
+    // A write-once store keeps replaced histories and pruned versions without
+    // filling the audit log
+    worm := &gblobs.LocalStore{WORM: true}
+    if err := worm.CreateStore(t.TempDir()); err != nil {
+        t.Fatalf("CreateStore failed: %v", err)
+    }
+    defer worm.Close()
+    vs = &gblobs.VersionedStore{Store: worm, KeepVersions: 1, DeletePruned: true}
+    for _, content := range []string{"first", "second"} {
+        if _, err := vs.PutVersion(uri, []byte(content)); err != nil {
+            t.Fatalf("PutVersion in a write-once store failed: %v", err)
+        }
+    }
+    if versions, _ := vs.ListVersions(uri); len(versions) != 1 || versions[0].Number != 2 {
+        t.Errorf("unexpected versions %+v", versions)
+    }
+    if exists, _ := worm.ExistsBlob(gblobs.GenerateBlobID([]byte("first"))); !exists {
+        t.Error("pruned version deleted from a write-once store")
+    }
+    if log, err := worm.AuditLog(); err != nil || len(log) != 0 {
+        t.Errorf("expected an empty audit log, got %+v, %v", log, err)
+    }
+}
+
+func TestExpire(t *testing.T) {
+    store := quickStore(t, false)
+    now := time.Now().UTC()
+    put := func(content, owner string, expiresAt time.Time) string {
+        id, err := store.PutBlob([]byte(content), gblobs.BlobType{Name: content, Owner: owner, IngestionTime: now, ExpiresAt: expiresAt})
+        if err != nil {
+            t.Fatalf("PutBlob failed: %v", err)
+        }
+        return id
+    }
+    expired := put("ephemeral lemon", "", now.Add(-time.Minute))
+    pending := put("pending lemon", "", now.Add(time.Hour))
+    temp := put("scratch lemon", "tmp", time.Time{})
+    kept := put("lasting lemon", "alice", time.Time{})
+    if err := store.Flush(); err != nil {
+        t.Fatalf("Flush failed: %v", err)
+    }
+    found := func() map[string]bool {
+        results, err := store.Search("lemon")
+        if err != nil {
+            t.Fatalf("Search failed: %v", err)
+        }
+        ids := map[string]bool{}
+        for _, r := range results {
+            ids[r.BlobID] = true
+        }
+        return ids
+    }
+    // Hidden right away, before anything was deleted
+    if ids := found(); len(ids) != 3 || ids[expired] {
+        t.Errorf("expected the expired blob to be hidden, found %v", ids)
+    }
+
+    if err := store.SetRetentionRules([]gblobs.RetentionRule{{Owner: "tmp", MaxAge: 0}}); err == nil {
+        t.Error("expected a rule without max age to be rejected")
+    }
+    if err := store.SetRetentionRules([]gblobs.RetentionRule{{Owner: "tmp", MaxAge: time.Millisecond}}); err != nil {
+        t.Fatalf("SetRetentionRules failed: %v", err)
+    }
+    if ids := found(); len(ids) != 2 || !ids[pending] || !ids[kept] {
+        t.Errorf("expected the rule to hide the blob of tmp, found %v", ids)
+    }
+
+    list, err := store.Expire(true)
+    if err != nil {
+        t.Fatalf("Expire dry run failed: %v", err)
+    }
+    if len(list) != 2 {
+        t.Fatalf("expected 2 expired blobs, got %+v", list)
+    }
+    if ok, _ := store.ExistsBlob(expired); !ok {
+        t.Error("dry run deleted a blob")
+    }
+    if list, err = store.Expire(false); err != nil || len(list) != 2 {
+        t.Fatalf("Expire: got %+v, %v", list, err)
+    }
+    for _, id := range []string{expired, temp} {
+        if ok, _ := store.ExistsBlob(id); ok {
+            t.Errorf("expired blob %s still exists", id)
+        }
+    }
+    if ok, _ := store.ExistsBlob(pending); !ok {
+        t.Error("blob that has not expired yet was deleted")
+    }
+
+    // Rules outlive the handle
+    dir := store.Path()
+    store.Close()
+    reopened := &gblobs.LocalStore{ExpireInterval: 10 * time.Millisecond}
+    if err := reopened.OpenStore(dir); err != nil {
+        t.Fatalf("OpenStore failed: %v", err)
+    }
+    defer reopened.Close()
+    if rules := reopened.RetentionRules(); len(rules) != 1 || rules[0].Owner != "tmp" {
+        t.Errorf("unexpected rules after reopening: %+v", rules)
+    }
+    // The background sweeper deletes what expires while the store is open
+    soon, err := reopened.PutBlob([]byte("fleeting"), gblobs.BlobType{Name: "fleeting", IngestionTime: now, ExpiresAt: time.Now().Add(20 * time.Millisecond)})
+    if err != nil {
+        t.Fatalf("PutBlob failed: %v", err)
+    }
+    deadline := time.Now().Add(5 * time.Second)
+    for {
+        if ok, _ := reopened.ExistsBlob(soon); !ok {
+            break
+        }
+        if time.Now().After(deadline) {
+            t.Fatal("sweeper did not delete the expired blob")
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}
+
+func TestRetentionLockAndLegalHold(t *testing.T) {
+    store := quickStore(t, false)
+    now := time.Now().UTC()
+    meta := gblobs.BlobType{Name: "contract.txt", Owner: "legal", IngestionTime: now}
+    held, err := store.PutBlob([]byte("signed contract"), meta)
+    if err != nil {
+        t.Fatalf("PutBlob failed: %v", err)
+    }
+    locked, err := store.PutBlob([]byte("ledger 2025"), gblobs.BlobType{Name: "ledger.txt", IngestionTime: now, ExpiresAt: now.Add(-time.Minute)})
+    if err != nil {
+        t.Fatalf("PutBlob failed: %v", err)
+    }
+
+    if err := store.SetLegalHold(held, true); err != nil {
+        t.Fatalf("SetLegalHold failed: %v", err)
+    }
+    if err := store.DeleteBlob(held); !errors.Is(err, gblobs.ErrRetentionLocked) {
+        t.Errorf("DeleteBlob under legal hold: expected ErrRetentionLocked, got %v", err)
+    }
+    if results, _ := store.DeleteBlobs([]string{held}); !errors.Is(results[0].Err, gblobs.ErrRetentionLocked) {
+        t.Errorf("DeleteBlobs under legal hold: expected ErrRetentionLocked, got %v", results[0].Err)
+    }
+    if err := store.PurgeStore(); !errors.Is(err, gblobs.ErrRetentionLocked) {
+        t.Errorf("PurgeStore with a held blob: expected ErrRetentionLocked, got %v", err)
+    }
+    renamed := meta
+    renamed.Name = "nothing-to-see.txt"
+    // Storing the content again under another name leaves the held blob as it is
+    if _, err := store.PutBlob([]byte("signed contract"), renamed); err != nil {
+        t.Errorf("storing a held blob under another name failed: %v", err)
+    }
+    if _, got, _ := store.GetBlob(held); got.Name != meta.Name {
+        t.Errorf("name of a held blob changed to %q", got.Name)
+    }
+    if _, err := store.PutBlob([]byte("signed contract"), meta); err != nil {
+        t.Errorf("storing a held blob again unchanged failed: %v", err)
+    }
+
+    until := now.Add(time.Hour)
+    if err := store.SetRetentionLock(locked, until); err != nil {
+        t.Fatalf("SetRetentionLock failed: %v", err)
+    }
+    if err := store.SetRetentionLock(locked, now.Add(time.Minute)); !errors.Is(err, gblobs.ErrRetentionLocked) {
+        t.Errorf("shortening a retention lock: expected ErrRetentionLocked, got %v", err)
+    }
+    if err := store.SetRetentionLock(locked, until.Add(time.Hour)); err != nil {
+        t.Errorf("extending a retention lock failed: %v", err)
+    }
+    if err := store.DeleteBlob(locked); !errors.Is(err, gblobs.ErrRetentionLocked) {
+        t.Errorf("DeleteBlob under retention lock: expected ErrRetentionLocked, got %v", err)
+    }
+    // Expired, but locked: neither hidden nor deleted
+    if err := store.Flush(); err != nil {
+        t.Fatalf("Flush failed: %v", err)
+    }
+    if results, err := store.Search("ledger"); err != nil || len(results) != 1 {
+        t.Errorf("expected the locked blob to be found, got %v, %v", results, err)
+    }
+    if results, err := store.Search("nothing-to-see"); err != nil || len(results) != 0 {
+        t.Errorf("expected the held blob to keep its indexed name, got %v, %v", results, err)
+    }
+    if expired, err := store.Expire(false); err != nil || len(expired) != 0 {
+        t.Errorf("Expire: expected nothing, got %+v, %v", expired, err)
+    }
+    if _, meta, _ := store.GetBlob(locked); !meta.RetainUntil.Equal(until.Add(time.Hour)) {
+        t.Errorf("unexpected RetainUntil %v", meta.RetainUntil)
+    }
+
+    log, err := store.AuditLog()
+    if err != nil {
+        t.Fatalf("AuditLog failed: %v", err)
+    }
+    var ops []string
+    for _, e := range log {
+        ops = append(ops, e.Op)
+    }
+    want := []string{"delete", "delete", "purge", "shorten-retention", "delete"}
+    if strings.Join(ops, " ") != strings.Join(want, " ") {
+        t.Errorf("audit log: got %v, want %v", ops, want)
+    }
+    if log[0].BlobID != held || log[0].Reason != "legal hold" {
+        t.Errorf("unexpected audit entry %+v", log[0])
+    }
+
+    if err := store.SetLegalHold(held, false); err != nil {
+        t.Fatalf("releasing the legal hold failed: %v", err)
+    }
+    if err := store.DeleteBlob(held); err != nil {
+        t.Errorf("DeleteBlob after release failed: %v", err)
+    }
+    if err := store.SetLegalHold(held, true); !errors.Is(err, gblobs.ErrNotFound) {
+        t.Errorf("SetLegalHold of a deleted blob: expected ErrNotFound, got %v", err)
+    }
+}
+
+func TestWORMStore(t *testing.T) {
+    dir := t.TempDir()
+    store := &gblobs.LocalStore{WORM: true}
+    if err := store.CreateStore(dir); err != nil {
+        t.Fatalf("CreateStore failed: %v", err)
+    }
+    meta := gblobs.BlobType{Name: "record.txt", IngestionTime: time.Now().UTC(), ExpiresAt: time.Now().Add(-time.Hour)}
+    id, err := store.PutBlob([]byte("write once, read many"), meta)
+    if err != nil {
+        t.Fatalf("PutBlob failed: %v", err)
+    }
+    store.Close()
+
+    // The mode belongs to the store, not to the handle
+    reopened := &gblobs.LocalStore{}
+    if err := reopened.OpenStore(dir); err != nil {
+        t.Fatalf("OpenStore failed: %v", err)
+    }
+    defer reopened.Close()
+    if !reopened.WORM {
+        t.Error("expected WORM to be set after opening a write-once store")
+    }
+    if err := reopened.DeleteBlob(id); !errors.Is(err, gblobs.ErrRetentionLocked) {
+        t.Errorf("DeleteBlob: expected ErrRetentionLocked, got %v", err)
+    }
+    if err := reopened.PurgeStore(); !errors.Is(err, gblobs.ErrRetentionLocked) {
+        t.Errorf("PurgeStore: expected ErrRetentionLocked, got %v", err)
+    }
+    meta.Owner = "mallory"
+    if _, err := reopened.PutBlob([]byte("write once, read many"), meta); err != nil {
+        t.Errorf("storing a blob again with other metadata failed: %v", err)
+    }
+    if _, got, _ := reopened.GetBlob(id); got.Owner != "" {
+        t.Errorf("owner of a write-once blob changed to %q", got.Owner)
+    }
+    // Duplicate files are deduplicated against the protected first copy
+    src := t.TempDir()
+    for _, name := range []string{"a.txt", "b.txt"} {
+        if err := os.WriteFile(filepath.Join(src, name), []byte("same content twice"), 0o644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if res, err := reopened.PutDir(src, gblobs.PutDirOptions{}); err != nil || res.Failed != 0 || res.New != 1 || res.Deduplicated != 1 {
+        t.Errorf("PutDir with duplicate files: got %+v, %v", res, err)
+    }
+    if expired, err := reopened.Expire(false); err != nil || len(expired) != 0 {
+        t.Errorf("Expire: expected nothing to expire, got %+v, %v", expired, err)
+    }
+    if ok, _ := reopened.ExistsBlob(id); !ok {
+        t.Error("blob of a write-once store is gone")
+    }
+    if log, err := reopened.AuditLog(); err != nil || len(log) != 2 {
+        t.Errorf("expected 2 audit entries, got %+v, %v", log, err)
+    }
+
+    // Losing the manifest does not turn the mode off, and is audited
+    reopened.Close()
+    if err := os.Remove(filepath.Join(dir, "gblobs.json")); err != nil {
+        t.Fatal(err)
+    }
+    downgraded := &gblobs.LocalStore{MappingPolicy: gblobs.MappingIgnore}
+    if err := downgraded.OpenStore(dir); err != nil {
+        t.Fatalf("OpenStore without the manifest failed: %v", err)
+    }
+    defer downgraded.Close()
+    if !downgraded.WORM {
+        t.Error("expected WORM to survive a lost manifest")
+    }
+    if err := downgraded.DeleteBlob(id); !errors.Is(err, gblobs.ErrRetentionLocked) {
+        t.Errorf("DeleteBlob after a lost manifest: expected ErrRetentionLocked, got %v", err)
+    }
+    if log, err := downgraded.AuditLog(); err != nil || len(log) != 4 || log[2].Op != "downgrade" {
+        t.Errorf("expected the downgrade in the audit log, got %+v, %v", log, err)
+    }
+}
+
+// BenchmarkParallelPut stores distinct blobs from all goroutines at once. Run it
+// with -cpu 1,2,4,8 to see puts scale with GOMAXPROCS.
+func BenchmarkParallelPut(b *testing.B) {
+    store := &gblobs.LocalStore{}
+    if err := store.CreateStore(b.TempDir()); err != nil {
+        b.Fatalf("fail create store: %v", err)
+    }
+    defer store.Close()
+    payload := bytes.Repeat([]byte("parallel ingest of many unrelated blobs "), 1600)
+    var seq atomic.Int64
+    b.SetBytes(int64(len(payload)))
+    b.ResetTimer()
+    b.RunParallel(func(pb *testing.PB) {
+        for pb.Next() {
+            data := strconv.AppendInt(append([]byte(nil), payload...), seq.Add(1), 10)
+            if _, err := store.PutBlob(data, gblobs.BlobType{Name: "ingest.txt"}); err != nil {
+                b.Error(err)
+                return
+            }
+        }
+    })
+}
@@ -1,9 +1,12 @@
 package test
 
 import (
+    "archive/zip"
     "bytes"
+    "errors"
     "os"
     "path/filepath"
+    "strings"
     "testing"
     "github.com/example/gblobs/gblobs"
 )
@@ -41,6 +44,50 @@ func TestBlobIDToPath(t *testing.T) {
     }
 }
 
+func TestValidateBlobID(t *testing.T) {
+    if err := gblobs.ValidateBlobID(gblobs.GenerateBlobID([]byte("x"))); err != nil {
+        t.Errorf("generated id rejected: %v", err)
+    }
+    for _, id := range []string{
+        "",
+        "abc",
+        "../../etc/passwd",
+        strings.Repeat("a", 63),
+        strings.Repeat("a", 65),
+        strings.Repeat("A", 64),
+        strings.Repeat("g", 64),
+        strings.Repeat("a", 62) + "/a",
+    } {
+        if err := gblobs.ValidateBlobID(id); !errors.Is(err, gblobs.ErrInvalidID) {
+            t.Errorf("ValidateBlobID(%q): expected ErrInvalidID, got %v", id, err)
+        }
+    }
+}
+
+// FuzzBlobIDToPath checks that every id passing ValidateBlobID maps to a path
+// inside the store that maps back to the same id.
+func FuzzBlobIDToPath(f *testing.F) {
+    f.Add(gblobs.GenerateBlobID([]byte("seed")))
+    f.Add("ba7816bf8f01cfea414140de5")
+    f.Add("../../etc/passwd")
+    f.Add("abc")
+    f.Fuzz(func(t *testing.T, id string) {
+        path := gblobs.BlobIDToPath(id)
+        if gblobs.ValidateBlobID(id) != nil {
+            return
+        }
+        if !filepath.IsLocal(path) || filepath.Clean(path) != filepath.FromSlash(path) {
+            t.Fatalf("BlobIDToPath(%q) = %q escapes the store", id, path)
+        }
+        if got := strings.Count(path, "/"); got != 3 {
+            t.Fatalf("BlobIDToPath(%q) = %q: want 3 levels, got %d", id, path, got)
+        }
+        if back := gblobs.BlobPathToID(path); back != id {
+            t.Fatalf("BlobPathToID(%q) = %q, want %q", path, back, id)
+        }
+    })
+}
+
 func TestEnsureDir_Idempotent(t *testing.T) {
     tmp := t.TempDir()
     sub := filepath.Join(tmp, "foo/bar")
@@ -149,3 +196,35 @@ func TestEncryptDecryptRoundTrip(t *testing.T) {
         t.Error("expected empty output")
     }
 }
+
+func TestDetectMIMEType(t *testing.T) {
+    var docx bytes.Buffer
+    zw := zip.NewWriter(&docx)
+    w, _ := zw.Create("word/document.xml")
+    w.Write([]byte("<w:document/>"))
+    zw.Close()
+
+    cases := []struct {
+        data []byte
+        name string
+        want string
+    }{
+        {[]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), "", "image/png"},
+        {[]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), "photo.txt", "image/png"}, // magic beats a wrong extension
+        {[]byte("\xff\xd8\xff\xe0\x00\x10JFIF"), "", "image/jpeg"},
+        {[]byte("%PDF-1.7\n"), "", "application/pdf"},
+        {docx.Bytes(), "", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+        {[]byte("plain words"), "", "text/plain"},
+        {[]byte("# Title\n\nbody"), "README.md", "text/markdown"},
+        {[]byte(`{"a": 1}`), "data.json", "application/json"},
+        {[]byte("<html><body>hi</body></html>"), "", "text/html"},
+        {[]byte("From: a@example.com\nSubject: hi\n\nbody"), "", "message/rfc822"},
+        {[]byte("Добрый день"), "", "text/plain"},
+        {[]byte{0x00, 0x01, 0x02, 0x03, 0xfe, 0xff}, "", "application/octet-stream"},
+    }
+    for _, c := range cases {
+        if got := gblobs.DetectMIMEType(c.data, c.name); got != c.want {
+            t.Errorf("DetectMIMEType(%q, %q) = %q, want %q", c.data, c.name, got, c.want)
+        }
+    }
+}